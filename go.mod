@@ -13,6 +13,7 @@ require (
 	golang.org/x/sync v0.14.0
 	golang.org/x/tools v0.33.0
 	google.golang.org/protobuf v1.36.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
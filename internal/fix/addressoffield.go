@@ -0,0 +1,454 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// knownPointerUses describes how functions outside of the package being
+// rewritten use a pointer argument: false means that the function only reads
+// and writes through the pointer during the call, true means that it keeps the
+// pointer to write through it later. Keys are types.Func.FullName values.
+var knownPointerUses = map[string]bool{
+	"encoding/binary.Read":            false,
+	"(*encoding/gob.Decoder).Decode":  false,
+	"encoding/json.Unmarshal":         false,
+	"(*encoding/json.Decoder).Decode": false,
+	"encoding/xml.Unmarshal":          false,
+	"(*encoding/xml.Decoder).Decode":  false,
+	"fmt.Fscan":                       false,
+	"fmt.Fscanf":                      false,
+	"fmt.Fscanln":                     false,
+	"fmt.Scan":                        false,
+	"fmt.Scanf":                       false,
+	"fmt.Scanln":                      false,
+	"fmt.Sscan":                       false,
+	"fmt.Sscanf":                      false,
+	"fmt.Sscanln":                     false,
+
+	// The flag package writes the value when the flags are parsed.
+	"flag.BoolVar":                true,
+	"flag.DurationVar":            true,
+	"flag.Float64Var":             true,
+	"flag.Int64Var":               true,
+	"flag.IntVar":                 true,
+	"flag.StringVar":              true,
+	"flag.TextVar":                true,
+	"flag.Uint64Var":              true,
+	"flag.UintVar":                true,
+	"(*flag.FlagSet).BoolVar":     true,
+	"(*flag.FlagSet).DurationVar": true,
+	"(*flag.FlagSet).Float64Var":  true,
+	"(*flag.FlagSet).Int64Var":    true,
+	"(*flag.FlagSet).IntVar":      true,
+	"(*flag.FlagSet).StringVar":   true,
+	"(*flag.FlagSet).TextVar":     true,
+	"(*flag.FlagSet).Uint64Var":   true,
+	"(*flag.FlagSet).UintVar":     true,
+}
+
+// addressOfFieldPre rewrites the address of a scalar field that is passed to a
+// function which fills in the value, e.g.:
+//
+//	err := json.Unmarshal(b, &m.F)
+//	=>
+//	f := m.F
+//	err := json.Unmarshal(b, &f)
+//	m.F = f
+//
+// The getPost and assignPre stages then rewrite the helper variable
+// initialization and the write-back to use the getter and setter. The rewrite
+// is only done if the function does not keep the pointer beyond the call (see
+// fieldAddressEscapes). Otherwise, getPost reports why the address of the field
+// can't be rewritten.
+func addressOfFieldPre(c *cursor) bool {
+	if !c.lvl.ge(Yellow) {
+		return true
+	}
+	if _, ok := c.Parent().(*dst.BlockStmt); !ok {
+		c.Logf("ignoring node with parent of type %T (looking for BlockStmt)", c.Parent())
+		return true
+	}
+	stmt, ok := c.Node().(dst.Stmt)
+	if !ok {
+		return true
+	}
+	call, ok := fillingCall(stmt)
+	if !ok {
+		c.Logf("ignoring %T (looking for a call statement or assignment)", stmt)
+		return true
+	}
+
+	var defs, writeBacks []dst.Stmt
+	for i, arg := range call.Args {
+		ue, ok := arg.(*dst.UnaryExpr)
+		if !ok || ue.Op != token.AND {
+			continue
+		}
+		field, ok := c.trackedProtoFieldSelector(ue.X)
+		if !ok {
+			continue
+		}
+		if reason := c.fieldAddressEscapes(call, i, field); reason != "" {
+			c.Logf("ignoring address of field: %s", reason)
+			continue
+		}
+		t := c.typeOf(field)
+		helper := &dst.Ident{Name: c.helperNameWithPrefix(stmt, helperVarNameForName(field.Sel.Name))}
+		c.setType(helper, t)
+		updateASTMap(c, field, helper)
+
+		def := &dst.AssignStmt{
+			Lhs: []dst.Expr{helper},
+			Tok: token.DEFINE,
+			Rhs: []dst.Expr{cloneSelectorExpr(c, field)},
+		}
+		updateASTMap(c, stmt, def)
+		defs = append(defs, def)
+
+		ue.X = cloneIdent(c, helper)
+
+		writeBack := &dst.AssignStmt{
+			Lhs: []dst.Expr{field},
+			Tok: token.ASSIGN,
+			Rhs: []dst.Expr{cloneIdent(c, helper)},
+		}
+		updateASTMap(c, stmt, writeBack)
+		writeBacks = append(writeBacks, writeBack)
+	}
+	if len(defs) == 0 {
+		return true
+	}
+
+	moveDecsBeforeStart(defs[0], stmt)
+	last := writeBacks[len(writeBacks)-1]
+	last.Decorations().After = stmt.Decorations().After
+	stmt.Decorations().After = dst.NewLine
+	for _, def := range defs {
+		c.InsertBefore(def)
+	}
+	// InsertAfter inserts right after the current node, so insert in reverse
+	// order to keep the order of the arguments.
+	for i := len(writeBacks) - 1; i >= 0; i-- {
+		c.InsertAfter(writeBacks[i])
+	}
+	return true
+}
+
+// fillingCall returns the call of a statement that consists of a call whose
+// results are discarded or assigned.
+func fillingCall(stmt dst.Stmt) (*dst.CallExpr, bool) {
+	var x dst.Expr
+	switch stmt := stmt.(type) {
+	case *dst.ExprStmt:
+		x = stmt.X
+	case *dst.AssignStmt:
+		if len(stmt.Rhs) != 1 {
+			return nil, false
+		}
+		x = stmt.Rhs[0]
+	default:
+		return nil, false
+	}
+	call, ok := x.(*dst.CallExpr)
+	return call, ok
+}
+
+// fieldAddressEscapes returns why the address of the scalar field, which is the
+// argument with index arg of call, can't be replaced by the address of a helper
+// variable whose value is written back to the field after the call, or "" if it
+// can.
+func (c *cursor) fieldAddressEscapes(call *dst.CallExpr, arg int, field *dst.SelectorExpr) string {
+	if t := c.typeOf(field); !isScalar(t) || isPtrToBasic(t) {
+		return "not a scalar field"
+	}
+	if !c.isSideEffectFree(field) {
+		return "the message expression has side effects"
+	}
+	if root := rootIdent(field.X); root != nil {
+		obj := c.objectOf(root)
+		// The write-back after the call would write to the message that the
+		// assignment stores, e.g. in m = fill(&m.F).
+		for _, lhs := range c.assignedResults(call) {
+			if obj != nil && usesObject(c, lhs, obj) {
+				return fmt.Sprintf("%s is assigned the result of the call", root.Name)
+			}
+		}
+		for i, a := range call.Args {
+			if i == arg || obj == nil {
+				continue
+			}
+			if ue, ok := a.(*dst.UnaryExpr); ok && ue.Op == token.AND {
+				if _, ok := c.trackedProtoFieldSelector(ue.X); ok {
+					// Other fields of the message, e.g. fmt.Sscan(s, &m.A,
+					// &m.B), are replaced by helper variables as well.
+					continue
+				}
+			}
+			mentioned := false
+			dst.Inspect(a, func(n dst.Node) bool {
+				if id, ok := n.(*dst.Ident); ok && c.objectOf(id) == obj {
+					mentioned = true
+				}
+				return !mentioned
+			})
+			if mentioned {
+				return fmt.Sprintf("%s is also passed to the call", root.Name)
+			}
+		}
+	}
+
+	fn := c.calledFunc(call)
+	if fn == nil {
+		return "the pointer is passed to a function value, which might keep it"
+	}
+	if retains, ok := knownPointerUses[fn.FullName()]; ok {
+		if retains {
+			return fmt.Sprintf("%s keeps the pointer to write to it later", fn.FullName())
+		}
+		return ""
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Variadic() && arg >= sig.Params().Len()-1 {
+		return fmt.Sprintf("can't tell whether the variadic function %s keeps the pointer", fn.Name())
+	}
+	decl := c.funcDecl(fn)
+	if decl == nil || decl.Body == nil {
+		return fmt.Sprintf("can't tell whether %s keeps the pointer", fn.FullName())
+	}
+	param := sig.Params().At(arg)
+	if pos, ok := c.paramEscapes(decl.Body, param); ok {
+		p := c.pkg.Fileset.Position(pos)
+		return fmt.Sprintf("%s passes on or stores the pointer at %s:%d", fn.Name(), filepath.Base(p.Filename), p.Line)
+	}
+	return ""
+}
+
+// assignedResults returns the left-hand side of the assignment statement that
+// assigns the results of call, or nil if they are not assigned.
+func (c *cursor) assignedResults(call *dst.CallExpr) []dst.Expr {
+	n, ok := c.typesInfo.astMap[call]
+	if !ok {
+		return nil
+	}
+	path, _ := astutil.PathEnclosingInterval(c.curFile.AST, n.Pos(), n.End())
+	for i, p := range path {
+		if p != n {
+			continue
+		}
+		for _, parent := range path[i+1:] {
+			if _, ok := parent.(*ast.ParenExpr); ok {
+				continue
+			}
+			as, ok := parent.(*ast.AssignStmt)
+			if !ok || len(as.Rhs) != 1 || ast.Unparen(as.Rhs[0]) != n {
+				return nil
+			}
+			if das, ok := c.typesInfo.dstMap[as].(*dst.AssignStmt); ok {
+				return das.Lhs
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// writesThrough reports whether the function called by call is known to write
+// through the pointer argument with index arg: a function of knownPointerUses,
+// or a function of the package that assigns through the parameter or passes
+// it to such a function. A pointer to a copy of the value would lose the
+// writes.
+func (c *cursor) writesThrough(call *dst.CallExpr, arg int) bool {
+	fn := c.calledFunc(call)
+	if fn == nil {
+		return false
+	}
+	if _, ok := knownPointerUses[fn.FullName()]; ok {
+		return true
+	}
+	sig := fn.Type().(*types.Signature)
+	if arg >= sig.Params().Len() || (sig.Variadic() && arg >= sig.Params().Len()-1) {
+		return false
+	}
+	decl := c.funcDecl(fn)
+	if decl == nil || decl.Body == nil {
+		return false
+	}
+	param := sig.Params().At(arg)
+	isParam := func(x ast.Expr) bool {
+		id, ok := ast.Unparen(x).(*ast.Ident)
+		return ok && c.pkg.TypeInfo.Uses[id] == param
+	}
+	isDeref := func(x ast.Expr) bool {
+		star, ok := ast.Unparen(x).(*ast.StarExpr)
+		return ok && isParam(star.X)
+	}
+	writes := false
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				writes = writes || isDeref(lhs)
+			}
+		case *ast.IncDecStmt:
+			writes = writes || isDeref(n.X)
+		case *ast.CallExpr:
+			var fun *ast.Ident
+			switch f := ast.Unparen(n.Fun).(type) {
+			case *ast.Ident:
+				fun = f
+			case *ast.SelectorExpr:
+				fun = f.Sel
+			}
+			callee, ok := c.pkg.TypeInfo.Uses[fun].(*types.Func)
+			if !ok {
+				break
+			}
+			if _, ok := knownPointerUses[callee.Origin().FullName()]; ok {
+				for _, a := range n.Args {
+					writes = writes || isParam(a)
+				}
+			}
+		}
+		return !writes
+	})
+	return writes
+}
+
+// calledFunc returns the function or method called by call, or nil if call
+// calls a function value.
+func (c *cursor) calledFunc(call *dst.CallExpr) *types.Func {
+	var id *dst.Ident
+	switch fun := call.Fun.(type) {
+	case *dst.Ident:
+		id = fun
+	case *dst.SelectorExpr:
+		id = fun.Sel
+	default:
+		return nil
+	}
+	fn, ok := c.objectOf(id).(*types.Func)
+	if !ok {
+		return nil
+	}
+	return fn.Origin()
+}
+
+// funcDecl returns the declaration of fn if it is declared in the package
+// being rewritten.
+func (c *cursor) funcDecl(fn *types.Func) *ast.FuncDecl {
+	if fn.Pkg() != c.pkg.TypePkg {
+		return nil
+	}
+	for _, f := range c.pkg.Files {
+		for _, decl := range f.AST.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && c.pkg.TypeInfo.Defs[fd.Name] == fn {
+				return fd
+			}
+		}
+	}
+	return nil
+}
+
+// paramEscapes reports the position of the first use of the pointer parameter
+// param in body that does more than reading or writing through the pointer,
+// e.g. storing it, returning it, capturing it in a function literal or passing
+// it to a function that is not known to only fill in the value.
+func (c *cursor) paramEscapes(body *ast.BlockStmt, param *types.Var) (token.Pos, bool) {
+	var escape token.Pos
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if escape.IsValid() {
+			return false
+		}
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if id, ok := n.(*ast.Ident); ok && c.pkg.TypeInfo.Uses[id] == param && !c.derefOrFilled(id, stack) {
+			escape = id.Pos()
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	})
+	return escape, escape.IsValid()
+}
+
+// derefOrFilled reports whether the use id of a pointer parameter, whose
+// ancestors are stack, only reads or writes through the pointer.
+func (c *cursor) derefOrFilled(id *ast.Ident, stack []ast.Node) bool {
+	for _, n := range stack {
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+	}
+	switch parent := stack[len(stack)-1].(type) {
+	case *ast.StarExpr:
+		return true
+	case *ast.BinaryExpr:
+		// Comparisons, e.g. with nil.
+		return true
+	case *ast.CallExpr:
+		var fun *ast.Ident
+		switch f := ast.Unparen(parent.Fun).(type) {
+		case *ast.Ident:
+			fun = f
+		case *ast.SelectorExpr:
+			fun = f.Sel
+		}
+		fn, ok := c.pkg.TypeInfo.Uses[fun].(*types.Func)
+		if !ok || fun == id {
+			return false
+		}
+		retains, ok := knownPointerUses[fn.Origin().FullName()]
+		return ok && !retains
+	}
+	return false
+}
+
+// rootIdent returns the identifier that expressions like m.A.B, m[i].B or
+// (*m).B are rooted in, or nil.
+func rootIdent(x dst.Expr) *dst.Ident {
+	for {
+		switch e := x.(type) {
+		case *dst.Ident:
+			return e
+		case *dst.SelectorExpr:
+			x = e.X
+		case *dst.IndexExpr:
+			x = e.X
+		case *dst.StarExpr:
+			x = e.X
+		case *dst.ParenExpr:
+			x = e.X
+		default:
+			return nil
+		}
+	}
+}
+
+// callArg returns the call of which x is an argument, and the index of the
+// argument.
+func callArg(c *cursor, x dst.Expr) (*dst.CallExpr, int, bool) {
+	call, ok := c.Parent().(*dst.CallExpr)
+	if !ok {
+		return nil, 0, false
+	}
+	for i, arg := range call.Args {
+		if arg == x {
+			return call, i, true
+		}
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func TestAddressOfField(t *testing.T) {
+	const extra = `
+func fill(s *string) {
+	if s != nil && *s == "" {
+		*s = "x"
+	}
+}
+func fillBoth(s *string, i *int32) error { *s, *i = "x", 1; return nil }
+func keep(s *string) { *s = "x"; kept = s }
+func read(s *string) string { return *s }
+var kept *string
+`
+	tests := []test{{
+		desc:  "call statement",
+		extra: extra,
+		in: `
+// fill in the field
+fill(&m3.S) // end of line
+_ = m3
+`,
+		want: map[Level]string{
+			Green: `
+// fill in the field
+fill(&m3.S) // end of line
+_ = m3
+`,
+			Yellow: `
+// fill in the field
+s := m3.GetS()
+fill(&s) // end of line
+m3.SetS(s)
+_ = m3
+`,
+		},
+	}, {
+		desc:  "assignment with several fields",
+		extra: extra,
+		in: `
+s := "taken"
+err := fillBoth(&m3.S, &m3.I32)
+_, _ = s, err
+`,
+		want: map[Level]string{
+			Yellow: `
+s := "taken"
+s2 := m3.GetS()
+i32 := m3.GetI32()
+err := fillBoth(&s2, &i32)
+m3.SetS(s2)
+m3.SetI32(i32)
+_, _ = s, err
+`,
+		},
+	}, {
+		desc:  "pointer escapes",
+		extra: extra,
+		in: `
+keep(&m3.S)
+`,
+		want: map[Level]string{
+			Yellow: `
+keep(&m3.S)
+`,
+			Red: `
+keep(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: keep passes on or stores the pointer at pkg_test.go:36 */)
+`,
+		},
+	}, {
+		// Without knowing that the function writes through the pointer,
+		// it is passed a pointer to a copy of the field.
+		desc:  "function value",
+		extra: extra,
+		in: `
+f := fill
+f(&m3.S)
+`,
+		want: map[Level]string{
+			Red: `
+f := fill
+f(proto.String(m3.GetS()))
+`,
+		},
+	}, {
+		desc:  "reading function",
+		extra: extra,
+		in: `
+_ = len(read(&m3.S))
+_ = func() string { return read(&m3.S) }
+`,
+		want: map[Level]string{
+			Red: `
+_ = len(read(proto.String(m3.GetS())))
+_ = func() string { return read(proto.String(m3.GetS())) }
+`,
+		},
+	}, {
+		desc:  "message passed along",
+		extra: `func fillFrom(s *string, m *pb3.M3) { *s = m.GetS() }`,
+		in: `
+fillFrom(&m3.S, m3)
+`,
+		want: map[Level]string{
+			Red: `
+fillFrom(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: m3 is also passed to the call */, m3)
+`,
+		},
+	}, {
+		desc:  "result assigned to the message",
+		extra: `func fillRet(s *string) *pb3.M3 { *s = "x"; return nil }`,
+		in: `
+m3 = fillRet(&m3.S)
+`,
+		want: map[Level]string{
+			Red: `
+m3 = fillRet(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: m3 is assigned the result of the call */)
+`,
+		},
+	}, {
+		desc:  "call in expression",
+		extra: extra,
+		in: `
+if fillBoth(&m3.S, new(int32)) != nil {
+}
+`,
+		want: map[Level]string{
+			Red: `
+if fillBoth(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: the call is not a statement or assignment of its own */, new(int32)) != nil {
+}
+`,
+		},
+	}}
+
+	runTableTests(t, tests)
+}
+
+func TestKnownPointerUses(t *testing.T) {
+	const header = `package p
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	pb3 "google.golang.org/open2opaque/internal/fix/testdata/proto3test_go_proto"
+)
+
+var _, _, _ = json.Unmarshal, flag.StringVar, fmt.Sscan
+
+func test_function(b []byte) {
+	m3 := new(pb3.M3)
+	_ = "TEST CODE STARTS HERE"
+`
+	tests := []struct {
+		desc    string
+		in      string
+		wantRed string
+	}{{
+		desc: "encoding/json.Unmarshal",
+		in: `err := json.Unmarshal(b, &m3.S)
+_ = err`,
+		wantRed: `s := m3.GetS()
+err := json.Unmarshal(b, &s)
+m3.SetS(s)
+_ = err`,
+	}, {
+		desc: "fmt.Sscan",
+		in:   `fmt.Sscan(string(b), &m3.S, &m3.I32)`,
+		wantRed: `s := m3.GetS()
+i32 := m3.GetI32()
+fmt.Sscan(string(b), &s, &i32)
+m3.SetS(s)
+m3.SetI32(i32)`,
+	}, {
+		desc:    "flag.StringVar",
+		in:      `flag.StringVar(&m3.S, "s", "", "usage")`,
+		wantRed: `flag.StringVar(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: flag.StringVar keeps the pointer to write to it later */, "s", "", "usage")`,
+	}, {
+		desc:    "(*flag.FlagSet).StringVar",
+		in:      `flag.CommandLine.StringVar(&m3.S, "s", "", "usage")`,
+		wantRed: `flag.CommandLine.StringVar(&m3.S /* DO_NOT_SUBMIT: missing rewrite for address of field: (*flag.FlagSet).StringVar keeps the pointer to write to it later */, "s", "", "usage")`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			src := header + tt.in + "\n\t_ = \"TEST CODE ENDS HERE\"\n}\n"
+			got, _, err := fixSource(context.Background(), src, "pkg_test.go", ConfiguredPackage{}, []Level{Green, Yellow, Red})
+			if err != nil {
+				t.Fatalf("fixSource() failed: %v; Full input:\n%s", err, src)
+			}
+			if d := diff.Diff(tt.wantRed, got[Red]); d != "" {
+				t.Errorf("fixSource(%q) = (red) %q; want %q\ndiff:\n%s", tt.in, got[Red], tt.wantRed, d)
+			}
+		})
+	}
+}
@@ -13,7 +13,13 @@ import (
 )
 
 func cloneSelectorExpr(c *cursor, src *dst.SelectorExpr) *dst.SelectorExpr {
-	out := dst.Clone(src).(*dst.SelectorExpr)
+	return cloneTree(c, src).(*dst.SelectorExpr)
+}
+
+// cloneTree clones the expression src along with the type information of all
+// its subexpressions.
+func cloneTree(c *cursor, src dst.Expr) dst.Expr {
+	out := dst.Clone(src).(dst.Expr)
 
 	// Match type information for all subexpressions to corresponding type
 	// information from the source.
@@ -130,9 +136,14 @@ func cloneExpr(c *cursor, src dst.Expr) dst.Expr {
 	case *dst.BasicLit:
 		return cloneBasicLit(c, v)
 	case *dst.CallExpr:
+		if _, ok := v.Fun.(*dst.SelectorExpr); !ok || len(v.Args) > 0 {
+			return cloneTree(c, v)
+		}
 		return cloneSelectorCallExpr(c, v)
 	case *dst.SelectorExpr:
 		return cloneSelectorExpr(c, v)
+	case *dst.ParenExpr, *dst.StarExpr:
+		return cloneTree(c, v)
 	default:
 		panic(fmt.Sprintf("unhandled type for cloneExpr %T", src))
 	}
@@ -13,6 +13,30 @@ import (
 )
 
 func udiff(x, y []byte) ([]byte, error) {
+	stdout, err := runDiff(x, y)
+	if err != nil {
+		return nil, err
+	}
+	nl := []byte("\n")
+	lines := bytes.Split(stdout, nl)
+	if len(lines) < 2 {
+		return stdout, nil
+	}
+	if strings.HasPrefix(string(lines[0]), "--- /dev/fd/3\t") &&
+		strings.HasPrefix(string(lines[1]), "+++ /dev/fd/4\t") {
+		stdout = bytes.Join(lines[2:], nl)
+	}
+	return stdout, nil
+}
+
+// UnifiedDiff returns the unified diff between the content x and y of the file
+// path, using a/ and b/ prefixes like git.
+func UnifiedDiff(path string, x, y []byte) ([]byte, error) {
+	return runDiff(x, y, "--label", "a/"+path, "--label", "b/"+path)
+}
+
+// runDiff runs "diff -u" with the additional arguments args on x and y.
+func runDiff(x, y []byte, args ...string) ([]byte, error) {
 	if bytes.Equal(x, y) {
 		return nil, nil
 	}
@@ -28,7 +52,7 @@ func udiff(x, y []byte) ([]byte, error) {
 	defer yp.Close()
 
 	var stderr bytes.Buffer
-	cmd := exec.Command("diff", "-u", "/dev/fd/3", "/dev/fd/4")
+	cmd := exec.Command("diff", append(append([]string{"-u"}, args...), "/dev/fd/3", "/dev/fd/4")...)
 	cmd.ExtraFiles = []*os.File{xp, yp}
 	cmd.Stderr = &stderr
 	stdout, err := cmd.Output()
@@ -43,15 +67,6 @@ func udiff(x, y []byte) ([]byte, error) {
 	if stderr.Len() != 0 {
 		return nil, fmt.Errorf("diff: %s", &stderr)
 	}
-	nl := []byte("\n")
-	lines := bytes.Split(stdout, nl)
-	if len(lines) < 2 {
-		return stdout, nil
-	}
-	if strings.HasPrefix(string(lines[0]), "--- /dev/fd/3\t") &&
-		strings.HasPrefix(string(lines[1]), "+++ /dev/fd/4\t") {
-		stdout = bytes.Join(lines[2:], nl)
-	}
 	return stdout, nil
 }
 
@@ -0,0 +1,33 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestUdiff(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff(1) not available")
+	}
+	diff, err := UnifiedDiff("x.proto", []byte("a\nb\n"), []byte("a\nc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- a/x.proto
++++ b/x.proto
+@@ -1,2 +1,2 @@
+ a
+-b
++c
+`
+	if got := string(diff); got != want {
+		t.Errorf("UnifiedDiff() = %q, want %q", got, want)
+	}
+	if diff, err := UnifiedDiff("x.proto", []byte("a\n"), []byte("a\n")); err != nil || diff != nil {
+		t.Errorf("UnifiedDiff() of equal contents = %q, %v, want nil, nil", diff, err)
+	}
+}
@@ -13,8 +13,10 @@ import (
 	"go/format"
 	"go/token"
 	"go/types"
+	"maps"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/dave/dst"
@@ -84,7 +86,8 @@ type FixedFile struct {
 	Generated    bool                 // Whether the file is a generated file.
 	Stats        []*spb.Entry         // List of proto accesses in Code (i.e. after applying rewrites).
 	Drifted      bool                 // Whether the file has drifted between CBT and HEAD.
-	RedFixes     map[unsafeReason]int // Number of fixes per unsafe category.
+	RedFixes     map[unsafeReason]int // Number of fixes per unsafe category, up to and including this level.
+	Rewrites     []string             // Rewrite steps that changed the file at this level. Only set if ConfiguredPackage.RecordRewrites is true.
 }
 
 func (f *FixedFile) String() string {
@@ -223,8 +226,13 @@ type ConfiguredPackage struct {
 	Levels           []Level
 	ProcessedFiles   *syncset.Set
 	ShowWork         bool
+	RecordRewrites   bool // Record the rewrite steps that change each file in FixedFile.Rewrites.
 	Testonly         bool
 	UseBuilders      BuilderUseType
+	// ValueSignatures determines the functions whose message-valued
+	// parameters and results are changed to pointers at the Red level. If nil,
+	// the package is analyzed on its own.
+	ValueSignatures *ValueSignatures
 }
 
 // Fix fixes a Go package.
@@ -253,6 +261,11 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 	}
 	info := dstTypesInfo(cpkg.Pkg.TypeInfo, dec)
 
+	valueSignatures := cpkg.ValueSignatures
+	if valueSignatures == nil && slices.Contains(cpkg.Levels, Red) {
+		valueSignatures = packageValueSignatures(cpkg.Pkg)
+	}
+
 	// Only check for file drift (between Compilations Bigtable and Piper HEAD)
 	// when working in a CitC client, not when running as FlumeGo job in prod.
 	driftCheck := false
@@ -300,6 +313,7 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 			testonly:                         cpkg.Testonly,
 			helperVariableNames:              make(map[string]bool),
 			numUnsafeRewritesByReason:        map[unsafeReason]int{},
+			valueSignatures:                  valueSignatures,
 		}
 		knownNoType := exprsWithNoType(c, dstFile)
 		out[None] = append(out[None], &FixedFile{
@@ -316,9 +330,10 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 				log.Infof("----- LEVEL %s -----", lvl)
 			}
 			c.imports.importsToAdd = nil
+			var applied []string
 			for _, r := range rewrites {
 				before := ""
-				if cpkg.ShowWork {
+				if cpkg.ShowWork || cpkg.RecordRewrites {
 					before = fmtSource()
 				}
 
@@ -375,8 +390,11 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 					return true
 				}, nil)
 
+				after := ""
+				if cpkg.ShowWork || cpkg.RecordRewrites {
+					after = fmtSource()
+				}
 				if cpkg.ShowWork {
-					after := fmtSource()
 					// We are intentionally using udiff instead of
 					// cmp.Diff here, because it is too cumbersome to get a
 					// line-based diff out of cmp.Diff.
@@ -392,6 +410,9 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 						log.Infof("rewrite %s changed:\n%s", r.name, string(diff))
 					}
 				}
+				if cpkg.RecordRewrites && after != before && !slices.Contains(applied, r.name) {
+					applied = append(applied, r.name)
+				}
 			}
 
 			if len(c.imports.importsToAdd) > 0 {
@@ -453,7 +474,8 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 				Generated:    f.Generated,
 				Drifted:      drifted,
 				Stats:        stats(c, dstFile, f.Generated),
-				RedFixes:     c.numUnsafeRewritesByReason,
+				RedFixes:     maps.Clone(c.numUnsafeRewritesByReason),
+				Rewrites:     applied,
 			})
 		}
 	}
@@ -83,6 +83,10 @@ type cursor struct {
 
 	helperVariableNames map[string]bool
 
+	// valueSignatures determines the functions whose message-valued
+	// parameters and results are changed to pointers.
+	valueSignatures *ValueSignatures
+
 	numUnsafeRewritesByReason map[unsafeReason]int
 }
 
@@ -138,6 +142,28 @@ const (
 	MaybeNilPointerDeref
 )
 
+var unsafeReasonNames = map[unsafeReason]string{
+	Unknown:                "Unknown",
+	PointerAlias:           "PointerAlias",
+	SliceAlias:             "SliceAlias",
+	InexpressibleAPIUsage:  "InexpressibleAPIUsage",
+	PotentialBuildBreakage: "PotentialBuildBreakage",
+	EvalOrderChange:        "EvalOrderChange",
+	IncompleteRewrite:      "IncompleteRewrite",
+	OneofFieldAccess:       "OneofFieldAccess",
+	ShallowCopy:            "ShallowCopy",
+	MaybeOneofChange:       "MaybeOneofChange",
+	MaybeSemanticChange:    "MaybeSemanticChange",
+	MaybeNilPointerDeref:   "MaybeNilPointerDeref",
+}
+
+func (r unsafeReason) String() string {
+	if name, ok := unsafeReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("unsafeReason(%d)", int(r))
+}
+
 func (c *cursor) ReplaceUnsafe(n dst.Node, rt unsafeReason) {
 	c.numUnsafeRewritesByReason[rt]++
 	c.Replace(n)
@@ -379,6 +405,12 @@ func grabNameInScope(pkg *types.Package, s *types.Scope, prefix string) string {
 }
 
 func (c *cursor) helperNameFor(n dst.Node, t types.Type) string {
+	return c.helperNameWithPrefix(n, helperVarNameForType(t))
+}
+
+// helperNameWithPrefix returns a free name starting with prefix for a helper
+// variable that is declared right before n.
+func (c *cursor) helperNameWithPrefix(n dst.Node, prefix string) string {
 	// dst.Node objects do not have position information, so we need to
 	// look up the corresponding ast.Node to get to the scope.
 	astNode, ok := c.typesInfo.astMap[n]
@@ -392,7 +424,7 @@ func (c *cursor) helperNameFor(n dst.Node, t types.Type) string {
 		// that contains the *ast.IfStmt, and our helper variable.
 		inner = inner.Parent()
 	}
-	helperName := grabNameInScope(c.pkg.TypePkg, inner, helperVarNameForType(t))
+	helperName := grabNameInScope(c.pkg.TypePkg, inner, prefix)
 	c.helperVariableNames[helperName] = true
 	return helperName
 }
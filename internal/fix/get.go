@@ -174,6 +174,7 @@ func getPre(c *cursor) bool {
 // traversing the tree in postorder
 func getPost(c *cursor) bool {
 	// &m.F  => proto.Helper(m.GetF())   // proto3 scalars
+	// f(&m.F) => no rewrite             // proto3 scalars if f writes through the pointer, see addressOfFieldPre
 	// &m.F  => no rewrite               // everything else
 	if ue, ok := c.Node().(*dst.UnaryExpr); ok && ue.Op == token.AND && c.lvl.ge(Red) {
 		field, ok := c.trackedProtoFieldSelector(ue.X)
@@ -181,6 +182,16 @@ func getPost(c *cursor) bool {
 			return true
 		}
 		if t := c.typeOf(field); isScalar(t) && !isPtrToBasic(t) {
+			if call, arg, ok := callArg(c, ue); ok && c.writesThrough(call, arg) {
+				// addressOfFieldPre rewrites the arguments that can be
+				// rewritten. The writes to the others would be lost.
+				reason := c.fieldAddressEscapes(call, arg, field)
+				if reason == "" {
+					reason = "the call is not a statement or assignment of its own"
+				}
+				markMissingRewrite(field, "address of field: "+reason)
+				return true
+			}
 			c.ReplaceUnsafe(c.newProtoHelperCall(sel2call(c, "Get", field, nil, *c.Node().Decorations()), t), PointerAlias)
 			return true
 		}
@@ -287,8 +287,7 @@ func oneOfSelector(c *cursor, prefix, fieldName string, msg dst.Expr, fieldType
 	fnsel := &dst.Ident{
 		Name: prefix + name,
 	}
-	selX := dst.Clone(msg).(dst.Expr)
-	c.setType(selX, types.Typ[types.Invalid])
+	selX := cloneExpr(c, msg)
 	fn := &dst.CallExpr{
 		Fun: &dst.SelectorExpr{
 			X:   selX,
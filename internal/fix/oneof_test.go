@@ -764,7 +764,7 @@ case pb2.M2_IntOneof_case:
 `,
 		},
 	}, {
-		desc:     "oneof: with assignment; don't override the init",
+		desc:     "oneof: with assignment; don't override the init; combine with it",
 		srcfiles: []string{"code.go", "pkg_test.go"},
 		extra:    `func f() *pb2.M2 { return nil }`,
 		in: `
@@ -777,38 +777,119 @@ case *pb2.M2_IntOneof:
 }
 `,
 		want: map[Level]string{
-			Red: `
-switch x := 1; oneofField := f().GetM().GetOneofField().(type) {
+			Green: `
+switch x, xmsg := 1, f().GetM(); xmsg.WhichOneofField() {
+case pb2.M2_StringOneof_case:
+	_ = xmsg.GetStringOneof()
+	_ = x
+case pb2.M2_IntOneof_case:
+	_ = xmsg.GetIntOneof()
+}
+`,
+		},
+	}, {
+		desc:     "oneof: with assignment; hoist the init in a block",
+		srcfiles: []string{"code.go", "pkg_test.go"},
+		extra:    `func f() *pb2.M2 { return nil }`,
+		in: `
+// comment
+switch x := m2; oneofField := f().GetOneofField().(type) {
 case *pb2.M2_StringOneof:
 	_ = oneofField.StringOneof
 	_ = x
-case *pb2.M2_IntOneof:
-	_ = oneofField.IntOneof
-} /* DO_NOT_SUBMIT: missing rewrite for type switch with side effects and init statement */
+}
+`,
+		want: map[Level]string{
+			Green: `
+// comment
+{
+	x := m2
+	switch xmsg := f(); xmsg.WhichOneofField() {
+	case pb2.M2_StringOneof_case:
+		_ = xmsg.GetStringOneof()
+		_ = x
+	}
+}
+`,
+		},
+	}, {
+		desc:     "oneof: with assignment; message depends on the init",
+		srcfiles: []string{"code.go", "pkg_test.go"},
+		extra: `
+func g() int { return 0 }
+func h(int) *pb2.M2 { return nil }
+`,
+		in: `
+switch a := g(); x := h(a).GetOneofField().(type) {
+case *pb2.M2_StringOneof:
+	_ = x.StringOneof
+}
+`,
+		want: map[Level]string{
+			Green: `
+{
+	a := g()
+	switch xmsg := h(a); xmsg.WhichOneofField() {
+	case pb2.M2_StringOneof_case:
+		_ = xmsg.GetStringOneof()
+	}
+}
 `,
 		},
 	}, {
-		desc:     "oneof: with assignment; don't override the init; green nop",
+		desc:     "oneof: with assignment; hoist the init",
 		srcfiles: []string{"code.go", "pkg_test.go"},
 		extra:    `func f() *pb2.M2 { return nil }`,
 		in: `
-switch x := 1; oneofField := f().M.GetOneofField().(type) {
+var x *pb2.M2
+switch x = m2; oneofField := f().GetOneofField().(type) {
 case *pb2.M2_StringOneof:
 	_ = oneofField.StringOneof
 	_ = x
-case *pb2.M2_IntOneof:
-	_ = oneofField.IntOneof
 }
 `,
 		want: map[Level]string{
 			Green: `
-switch x := 1; oneofField := f().GetM().GetOneofField().(type) {
+var x *pb2.M2
+x = m2
+switch xmsg := f(); xmsg.WhichOneofField() {
+case pb2.M2_StringOneof_case:
+	_ = xmsg.GetStringOneof()
+	_ = x
+}
+`,
+		},
+	}, {
+		desc:     "oneof: with assignment; init with side effects in labeled statement",
+		srcfiles: []string{"code.go", "pkg_test.go"},
+		extra:    `func f() *pb2.M2 { return nil }`,
+		in: `
+L:
+switch x := m2; oneofField := f().GetOneofField().(type) {
 case *pb2.M2_StringOneof:
 	_ = oneofField.StringOneof
 	_ = x
-case *pb2.M2_IntOneof:
-	_ = oneofField.IntOneof
+	break L
 }
+`,
+		want: map[Level]string{
+			Green: `
+L:
+	switch x := m2; oneofField := f().GetOneofField().(type) {
+	case *pb2.M2_StringOneof:
+		_ = oneofField.StringOneof
+		_ = x
+		break L
+	}
+`,
+			Red: `
+L:
+	switch x := m2; oneofField := f().GetOneofField().(type) {
+	case *pb2.M2_StringOneof:
+		_ = oneofField.StringOneof
+		_ = x
+		break L
+	} /* DO_NOT_SUBMIT: missing rewrite for type switch with side effects and init statement */
 `,
 		},
 	}, {
@@ -0,0 +1,708 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+)
+
+// oneofAccessPre rewrites direct accesses of oneof fields that are neither
+// type switches (see oneofSwitchPost), nor comparisons with nil (see hasPre),
+// nor assignments of wrapper literals (see assignPre):
+//
+//	o := m.Choice                             switch m.Choice.(type) {
+//	switch o.(type) {                     =>  ...
+//	...
+//
+//	_ = m.Choice.(*pb.M_Foo).Foo          =>  _ = m.GetFoo()
+//	_, ok := m.Choice.(*pb.M_Foo)         =>  ok := m.HasFoo()
+//
+//	if w, ok := m.Choice.(*pb.M_Foo); ok {    if ok := m.HasFoo(); ok {
+//		_ = w.Foo                         =>  	_ = m.GetFoo()
+//	}                                         }
+//
+//	w := &pb.M_Foo{Foo: v}                    w := v
+//	w.Foo = x                             =>  w = x
+//	m.Choice = w                              m.Choice = &pb.M_Foo{Foo: w}
+//
+// The last rewrite turns a wrapper that is built in one place and assigned to
+// the oneof field in another into an assignment of a wrapper literal, which
+// assignPre then rewrites to a setter (m.SetFoo(w)). The rewrites that
+// involve variables are only done if all uses of the variables are local and
+// can be rewritten.
+func oneofAccessPre(c *cursor) bool {
+	switch n := c.Node().(type) {
+	case *dst.SelectorExpr:
+		oneofCaseGet(c, n)
+	case *dst.AssignStmt:
+		if !oneofVar(c, n) && !oneofCaseAssertion(c, n) {
+			oneofWrapperVar(c, n)
+		}
+	}
+	return true
+}
+
+// oneofCase is a case of a oneof field that is asserted with
+// m.Choice.(*pb.M_Foo).
+type oneofCase struct {
+	field *dst.SelectorExpr // m.Choice
+	name  string            // Foo
+	typ   types.Type        // type of Foo
+}
+
+// assertedOneofCase returns the oneof case if x is a type assertion of a oneof
+// field to a wrapper type.
+func assertedOneofCase(c *cursor, x dst.Expr) (oneofCase, bool) {
+	ta, ok := dstutil.Unparen(x).(*dst.TypeAssertExpr)
+	if !ok || ta.Type == nil {
+		return oneofCase{}, false
+	}
+	field, ok := c.trackedProtoFieldSelector(ta.X)
+	if !ok || !isOneof(c.typeOf(field)) {
+		return oneofCase{}, false
+	}
+	t := c.typeOfOrNil(ta.Type)
+	if t == nil {
+		return oneofCase{}, false
+	}
+	name, typ, ok := oneofWrapperField(t)
+	if !ok {
+		return oneofCase{}, false
+	}
+	return oneofCase{field: field, name: name, typ: typ}, true
+}
+
+// oneofWrapperField returns the name and type of the field of the oneof
+// wrapper type t, e.g. *pb.M_Foo.
+func oneofWrapperField(t types.Type) (string, types.Type, bool) {
+	p, ok := types.Unalias(t).(*types.Pointer)
+	if !ok {
+		return "", nil, false
+	}
+	s, ok := p.Elem().Underlying().(*types.Struct)
+	if !ok || s.NumFields() != 1 {
+		return "", nil, false
+	}
+	for _, tag := range strings.Split(reflect.StructTag(s.Tag(0)).Get("protobuf"), ",") {
+		if tag == "oneof" {
+			return s.Field(0).Name(), s.Field(0).Type(), true
+		}
+	}
+	return "", nil, false
+}
+
+// oneofCaseGet rewrites m.Choice.(*pb.M_Foo).Foo to m.GetFoo(). This is a
+// yellow rewrite because the type assertion panics if the case is not set
+// while the getter returns the zero value.
+func oneofCaseGet(c *cursor, sel *dst.SelectorExpr) {
+	if !c.lvl.ge(Yellow) {
+		return
+	}
+	oc, ok := assertedOneofCase(c, sel.X)
+	if !ok || sel.Sel.Name != oc.name {
+		return
+	}
+	if !isReadOnly(sel, c.Parent()) || isLValue(c) {
+		c.Logf("ignoring: oneof case is modified")
+		return
+	}
+	c.Replace(oneOfSelector(c, "Get", oc.name, oc.field.X, oc.typ, nil, *sel.Decorations()))
+}
+
+// oneofVar inlines a variable that holds the value of a oneof field if it is
+// only used in type switches and type assertions, which are then rewritten by
+// oneofSwitchPost and oneofCaseAssertion, and reports whether it did:
+//
+//	o := m.Choice
+//	if w, ok := o.(*pb.M_Foo); ok {  =>  if w, ok := m.Choice.(*pb.M_Foo); ok {
+func oneofVar(c *cursor, as *dst.AssignStmt) bool {
+	if !c.lvl.ge(Yellow) {
+		return false
+	}
+	if as.Tok != token.DEFINE || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		return false
+	}
+	oIdent, ok := as.Lhs[0].(*dst.Ident)
+	if !ok {
+		return false
+	}
+	field, ok := c.trackedProtoFieldSelector(as.Rhs[0])
+	if !ok || !isOneof(c.typeOf(field)) {
+		return false
+	}
+	if _, ok := c.Parent().(*dst.BlockStmt); !ok {
+		return false
+	}
+	if !c.isSideEffectFree(field.X) {
+		c.Logf("ignoring: message expression is not side effect free")
+		return false
+	}
+	oObj := c.objectOf(oIdent)
+	if oObj == nil {
+		return false
+	}
+	uses := make(map[*dst.Ident]bool)
+	ok = true
+	var stack []dst.Node
+	dstutil.Apply(c.Parent(), func(cur *dstutil.Cursor) bool {
+		n := cur.Node()
+		if !ok || n == as {
+			return false
+		}
+		if id, isIdent := n.(*dst.Ident); isIdent && c.objectOf(id) == oObj {
+			ta, isTA := cur.Parent().(*dst.TypeAssertExpr)
+			if !isTA || ta.X != id || insideFuncLit(stack) {
+				c.Logf("ignoring: oneof variable is used other than in type assertions")
+				ok = false
+			}
+			uses[id] = true
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	}, func(cur *dstutil.Cursor) bool {
+		stack = stack[:len(stack)-1]
+		return true
+	})
+	if !ok || len(uses) == 0 {
+		return false
+	}
+	if !c.unchangedAfter(c.Parent(), field.X, as) {
+		c.Logf("ignoring: message might be modified while the oneof variable is in use")
+		return false
+	}
+	replaceNodes(c.Parent(), func(n dst.Node) dst.Node {
+		if id, ok := n.(*dst.Ident); ok && uses[id] {
+			sel := cloneSelectorExpr(c, field)
+			sel.Decs.NodeDecs = id.Decs.NodeDecs
+			return sel
+		}
+		return nil
+	})
+	c.Delete()
+	return true
+}
+
+// oneofCaseAssertion rewrites comma-ok type assertions of oneof fields and
+// reports whether it did:
+//
+//	_, ok := m.Choice.(*pb.M_Foo)  =>  ok := m.HasFoo()
+//	w, ok := m.Choice.(*pb.M_Foo)  =>  ok := m.HasFoo()
+//
+// In the latter case, all uses of w must be reads of w.Foo, which are
+// rewritten to m.GetFoo(). The single-value form w := m.Choice.(*pb.M_Foo) is
+// removed under the same condition.
+func oneofCaseAssertion(c *cursor, as *dst.AssignStmt) bool {
+	if len(as.Rhs) != 1 || (len(as.Lhs) != 1 && len(as.Lhs) != 2) {
+		return false
+	}
+	oc, ok := assertedOneofCase(c, as.Rhs[0])
+	if !ok {
+		return false
+	}
+	var okExpr dst.Expr
+	if len(as.Lhs) == 2 {
+		okExpr = as.Lhs[1]
+		if isBlank(okExpr) {
+			c.Logf("ignoring: result of the type assertion is discarded")
+			return false
+		}
+	}
+	has := func() dst.Expr {
+		return oneOfSelector(c, "Has", oc.name, oc.field.X, oc.typ, nil, dst.NodeDecs{})
+	}
+
+	w := as.Lhs[0]
+	if isBlank(w) {
+		if okExpr == nil {
+			// _ = m.Choice.(*pb.M_Foo) panics if the case is not set.
+			c.Logf("ignoring: type assertion without ok result")
+			return false
+		}
+		if !c.isSideEffectFree(oc.field.X) {
+			c.Logf("ignoring: message expression is not side effect free")
+			return false
+		}
+		as.Lhs = []dst.Expr{okExpr}
+		as.Rhs = []dst.Expr{has()}
+		if as.Tok == token.DEFINE && !c.isDefinition(okExpr) {
+			as.Tok = token.ASSIGN
+		}
+		return true
+	}
+
+	if !c.lvl.ge(Yellow) || as.Tok != token.DEFINE {
+		return false
+	}
+	wIdent, ok := w.(*dst.Ident)
+	if !ok {
+		return false
+	}
+	if okExpr == nil {
+		if _, ok := c.Parent().(*dst.BlockStmt); !ok {
+			c.Logf("ignoring: type assertion is not a statement in a block")
+			return false
+		}
+	}
+	if !c.isSideEffectFree(oc.field.X) {
+		c.Logf("ignoring: message expression is not side effect free")
+		return false
+	}
+	wObj := c.objectOf(wIdent)
+	reads, ok := c.wrapperFieldUses(c.Parent(), wObj, oc.name, false, as)
+	if !ok {
+		return false
+	}
+	if !c.unchangedAfter(c.Parent(), oc.field.X, as) {
+		c.Logf("ignoring: message might be modified while the wrapper is in use")
+		return false
+	}
+
+	replaceNodes(c.Parent(), func(n dst.Node) dst.Node {
+		if sel, ok := n.(*dst.SelectorExpr); ok && reads[sel] {
+			return oneOfSelector(c, "Get", oc.name, oc.field.X, oc.typ, nil, *sel.Decorations())
+		}
+		return nil
+	})
+	if okExpr == nil {
+		c.Delete()
+		return true
+	}
+	as.Lhs = []dst.Expr{okExpr}
+	as.Rhs = []dst.Expr{has()}
+	if !c.isDefinition(okExpr) {
+		as.Tok = token.ASSIGN
+	}
+	return true
+}
+
+// oneofWrapperVar rewrites the definition of a wrapper variable that is later
+// assigned to a oneof field in the same block:
+//
+//	w := &pb.M_Foo{Foo: v}                    w := v
+//	w.Foo = x                             =>  w = x
+//	m.Choice = w                              m.Choice = &pb.M_Foo{Foo: w}
+//
+// Between definition and assignment, w may only be used as w.Foo; it must not
+// be used after the assignment, which would modify the oneof field.
+func oneofWrapperVar(c *cursor, def *dst.AssignStmt) {
+	if !c.lvl.ge(Yellow) {
+		return
+	}
+	if def.Tok != token.DEFINE || len(def.Lhs) != 1 || len(def.Rhs) != 1 {
+		return
+	}
+	block, ok := c.Parent().(*dst.BlockStmt)
+	if !ok {
+		return
+	}
+	wIdent, ok := def.Lhs[0].(*dst.Ident)
+	if !ok {
+		return
+	}
+	ue, ok := def.Rhs[0].(*dst.UnaryExpr)
+	if !ok || ue.Op != token.AND {
+		return
+	}
+	clit, ok := ue.X.(*dst.CompositeLit)
+	if !ok {
+		return
+	}
+	name, typ, ok := oneofWrapperField(c.typeOf(ue))
+	if !ok {
+		return
+	}
+	wObj := c.objectOf(wIdent)
+	if wObj == nil {
+		return
+	}
+
+	// Find the assignment to the oneof field.
+	defIdx := -1
+	var assign *dst.AssignStmt
+	for i, s := range block.List {
+		if s == def {
+			defIdx = i
+			continue
+		}
+		if defIdx < 0 {
+			continue
+		}
+		as, ok := s.(*dst.AssignStmt)
+		if !ok || as.Tok != token.ASSIGN || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+			continue
+		}
+		if id, ok := as.Rhs[0].(*dst.Ident); !ok || c.objectOf(id) != wObj {
+			continue
+		}
+		field, ok := c.trackedProtoFieldSelector(as.Lhs[0])
+		if !ok || !isOneof(c.typeOf(field)) {
+			c.Logf("ignoring: wrapper is not assigned to a oneof field")
+			return
+		}
+		assign = as
+		break
+	}
+	if assign == nil {
+		c.Logf("ignoring: no assignment of the wrapper to a oneof field in the same block")
+		return
+	}
+	uses, ok := c.wrapperFieldUses(block, wObj, name, true, def, assign)
+	if !ok {
+		return
+	}
+	// All uses must be before the assignment: afterwards, w aliases the
+	// oneof field.
+	afterAssign := false
+	for _, s := range block.List {
+		if s == assign {
+			afterAssign = true
+			continue
+		}
+		if !afterAssign {
+			continue
+		}
+		used := false
+		dst.Inspect(s, func(n dst.Node) bool {
+			if id, ok := n.(*dst.Ident); ok && c.objectOf(id) == wObj {
+				used = true
+			}
+			return !used
+		})
+		if used {
+			c.Logf("ignoring: wrapper is used after the assignment to the oneof field")
+			return
+		}
+	}
+
+	var val dst.Expr
+	switch {
+	case len(clit.Elts) == 0:
+	case isKV(clit.Elts[0]):
+		val = clit.Elts[0].(*dst.KeyValueExpr).Value
+	default:
+		val = clit.Elts[0]
+	}
+	if id, ok := val.(*dst.Ident); ok && id.Name == "nil" {
+		val = nil
+	}
+	if val == nil {
+		if len(uses) > 0 {
+			// A variable of the field type would need to be declared with
+			// its zero value, which we don't do for non-basic types.
+			b, ok := types.Unalias(typ).(*types.Basic)
+			if !ok {
+				c.Logf("ignoring: empty wrapper of non-basic type %v is modified", typ)
+				return
+			}
+			val = c.typedValue(scalarTypeZeroExpr(c, b), b)
+		} else {
+			// The empty wrapper has no side effects: assign it directly.
+			assign.Rhs[0] = ue
+			moveDecsBeforeStart(assign, def)
+			c.Delete()
+			return
+		}
+	} else if val = c.typedValue(val, typ); val == nil {
+		c.Logf("ignoring: can't declare a variable of type %v with the wrapper value", typ)
+		return
+	}
+	if clit.Elts != nil {
+		// Keep the decorations of the value.
+		*val.Decorations() = *clit.Elts[0].Decorations()
+	}
+
+	def.Rhs[0] = val
+	c.setType(wIdent, typ)
+	replaceNodes(block, func(n dst.Node) dst.Node {
+		sel, ok := n.(*dst.SelectorExpr)
+		if !ok || !uses[sel] {
+			return nil
+		}
+		id := cloneIdent(c, sel.X.(*dst.Ident))
+		c.setType(id, typ)
+		id.Decs.NodeDecs = sel.Decs.NodeDecs
+		return id
+	})
+
+	key := &dst.Ident{Name: name}
+	c.setType(key, typ)
+	wVal := cloneIdent(c, assign.Rhs[0].(*dst.Ident))
+	c.setType(wVal, typ)
+	kv := &dst.KeyValueExpr{Key: key, Value: wVal}
+	c.setType(kv, typ)
+	clit.Elts = []dst.Expr{kv}
+	ue.Decs = dst.UnaryExprDecorations{}
+	assign.Rhs[0] = ue
+}
+
+// typedValue returns val such that a variable defined as v := val has type t,
+// converting untyped constants to t if necessary, or nil if that's not
+// possible.
+func (c *cursor) typedValue(val dst.Expr, t types.Type) dst.Expr {
+	tv, ok := c.typesInfo.types[val]
+	if !ok || tv.Value == nil {
+		// Not a constant: the expression already has type t.
+		return val
+	}
+	var kind types.BasicKind
+	switch v := val.(type) {
+	case *dst.BasicLit:
+		kind = map[token.Token]types.BasicKind{
+			token.INT:    types.Int,
+			token.FLOAT:  types.Float64,
+			token.IMAG:   types.Complex128,
+			token.CHAR:   types.Rune,
+			token.STRING: types.String,
+		}[v.Kind]
+	case *dst.Ident:
+		if obj, ok := c.objectOf(v).(*types.Const); ok && obj.Type() == t {
+			// Typed constant, e.g. an enum value.
+			return val
+		}
+		if tv.Value.Kind() == constant.Bool {
+			kind = types.Bool
+		}
+	}
+	b, ok := types.Unalias(t).(*types.Basic)
+	if !ok {
+		return nil
+	}
+	if kind == b.Kind() {
+		return val
+	}
+	fun := &dst.Ident{Name: b.Name()}
+	c.setType(fun, b)
+	conv := &dst.CallExpr{Fun: fun, Args: []dst.Expr{val}}
+	c.setType(conv, b)
+	return conv
+}
+
+// wrapperFieldUses returns the uses of the wrapper variable wObj in root,
+// except in the statements skip, if all of them are selections of the wrapper
+// field name. Unless modifiable is set, the selections must only read the
+// field.
+func (c *cursor) wrapperFieldUses(root dst.Node, wObj types.Object, name string, modifiable bool, skip ...dst.Stmt) (map[*dst.SelectorExpr]bool, bool) {
+	uses := make(map[*dst.SelectorExpr]bool)
+	ok := true
+	var stack []dst.Node
+	dstutil.Apply(root, func(cur *dstutil.Cursor) bool {
+		n := cur.Node()
+		if !ok {
+			return false
+		}
+		if s, isStmt := n.(dst.Stmt); isStmt && slices.Contains(skip, s) {
+			return false
+		}
+		if id, isIdent := n.(*dst.Ident); isIdent && c.objectOf(id) == wObj {
+			sel, isSel := cur.Parent().(*dst.SelectorExpr)
+			switch {
+			case !isSel || sel.X != id || sel.Sel.Name != name:
+				c.Logf("ignoring: wrapper is used other than through its field")
+				ok = false
+			case !modifiable && !isReadOnly(sel, stack[len(stack)-2]):
+				c.Logf("ignoring: wrapper field is modified")
+				ok = false
+			case modifiable && isAddressTaken(sel, stack[len(stack)-2]):
+				c.Logf("ignoring: address of the wrapper field is taken")
+				ok = false
+			case insideFuncLit(stack):
+				c.Logf("ignoring: wrapper is used in a function literal")
+				ok = false
+			default:
+				uses[sel] = true
+			}
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	}, func(cur *dstutil.Cursor) bool {
+		stack = stack[:len(stack)-1]
+		return true
+	})
+	return uses, ok
+}
+
+// unchangedAfter reports whether, in root after the statement from, the
+// message expression x refers to the same unmodified message: its root
+// variable is only read (see onlyReadAfter) and no other variable in x, e.g.
+// the index i in ms[i], is written.
+func (c *cursor) unchangedAfter(root dst.Node, x dst.Expr, from dst.Stmt) bool {
+	rootID := rootIdent(x)
+	if rootID == nil || !c.onlyReadAfter(root, c.objectOf(rootID), from) {
+		return false
+	}
+	ok := true
+	dst.Inspect(x, func(n dst.Node) bool {
+		if id, isIdent := n.(*dst.Ident); isIdent && id != rootID {
+			if v, isVar := c.objectOf(id).(*types.Var); isVar && !v.IsField() && c.writtenAfter(root, v, from) {
+				ok = false
+			}
+		}
+		return ok
+	})
+	return ok
+}
+
+// writtenAfter reports whether, in root after the statement from, the
+// variable obj is assigned, incremented or has its address taken.
+func (c *cursor) writtenAfter(root dst.Node, obj types.Object, from dst.Stmt) bool {
+	seen := false
+	written := false
+	var stack []dst.Node
+	dstutil.Apply(root, func(cur *dstutil.Cursor) bool {
+		n := cur.Node()
+		if n == from {
+			seen = true
+			return false
+		}
+		if written {
+			return false
+		}
+		if id, isIdent := n.(*dst.Ident); isIdent && seen && c.objectOf(id) == obj && isLHS(id, stack) {
+			written = true
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	}, func(cur *dstutil.Cursor) bool {
+		stack = stack[:len(stack)-1]
+		return true
+	})
+	return written
+}
+
+// onlyReadAfter reports whether, in root after the statement from, the
+// variable obj is only used to read fields or to call getters (Get, Has or
+// Which methods) on it.
+func (c *cursor) onlyReadAfter(root dst.Node, obj types.Object, from dst.Stmt) bool {
+	if obj == nil {
+		return false
+	}
+	seen := false
+	ok := true
+	var stack []dst.Node
+	dstutil.Apply(root, func(cur *dstutil.Cursor) bool {
+		n := cur.Node()
+		if n == from {
+			seen = true
+			return false
+		}
+		if !ok {
+			return false
+		}
+		if id, isIdent := n.(*dst.Ident); isIdent && seen && c.objectOf(id) == obj {
+			sel, isSel := cur.Parent().(*dst.SelectorExpr)
+			if !isSel || sel.X != id {
+				ok = false
+				return false
+			}
+			if call, isCall := stack[len(stack)-2].(*dst.CallExpr); isCall && call.Fun == sel {
+				name := sel.Sel.Name
+				ok = strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "Has") || strings.HasPrefix(name, "Which")
+				return false
+			}
+			ok = isReadOnly(sel, stack[len(stack)-2]) && !isLHS(sel, stack)
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	}, func(cur *dstutil.Cursor) bool {
+		stack = stack[:len(stack)-1]
+		return true
+	})
+	return ok
+}
+
+// isReadOnly reports whether the expression x with the given parent only
+// reads x, i.e. x is not assigned, incremented or has its address taken.
+func isReadOnly(x dst.Expr, parent dst.Node) bool {
+	switch p := parent.(type) {
+	case *dst.AssignStmt:
+		for _, lhs := range p.Lhs {
+			if lhs == x {
+				return false
+			}
+		}
+	case *dst.IncDecStmt:
+		return false
+	}
+	return !isAddressTaken(x, parent)
+}
+
+func isAddressTaken(x dst.Expr, parent dst.Node) bool {
+	ue, ok := parent.(*dst.UnaryExpr)
+	return ok && ue.Op == token.AND && ue.X == x
+}
+
+// isLHS reports whether x, whose ancestors are stack, is part of the left-hand
+// side of an assignment, e.g. m in m.F.G = v.
+func isLHS(x dst.Expr, stack []dst.Node) bool {
+	child := dst.Node(x)
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *dst.SelectorExpr, *dst.IndexExpr, *dst.StarExpr, *dst.ParenExpr:
+			child = n
+			continue
+		case *dst.AssignStmt:
+			for _, lhs := range n.Lhs {
+				if lhs == child {
+					return true
+				}
+			}
+		case *dst.IncDecStmt:
+			return n.X == child
+		case *dst.UnaryExpr:
+			return n.Op == token.AND
+		}
+		return false
+	}
+	return false
+}
+
+func insideFuncLit(stack []dst.Node) bool {
+	for _, n := range stack {
+		if _, ok := n.(*dst.FuncLit); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlank(x dst.Expr) bool {
+	id, ok := x.(*dst.Ident)
+	return ok && id.Name == "_"
+}
+
+// isDefinition reports whether the identifier x is defined (rather than
+// assigned) by a := statement.
+func (c *cursor) isDefinition(x dst.Expr) bool {
+	id, ok := x.(*dst.Ident)
+	if !ok {
+		return false
+	}
+	_, ok = c.typesInfo.defs[id]
+	return ok
+}
+
+// replaceNodes replaces the nodes in root for which replacement returns a
+// non-nil node.
+func replaceNodes(root dst.Node, replacement func(dst.Node) dst.Node) {
+	dstutil.Apply(root, func(cur *dstutil.Cursor) bool {
+		if r := replacement(cur.Node()); r != nil {
+			cur.Replace(r)
+			return false
+		}
+		return true
+	}, nil)
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"testing"
+)
+
+func TestOneofAccess(t *testing.T) {
+	tests := []test{{
+		desc: "field of asserted case",
+		in: `
+_ = m3.OneofField.(*pb3.M3_StringOneof).StringOneof
+_ = m2.OneofField.(*pb2.M2_IntOneof).IntOneof + 1
+`,
+		want: map[Level]string{
+			Green: `
+_ = m3.OneofField.(*pb3.M3_StringOneof).StringOneof
+_ = m2.OneofField.(*pb2.M2_IntOneof).IntOneof + 1
+`,
+			Yellow: `
+_ = m3.GetStringOneof()
+_ = m2.GetIntOneof() + 1
+`,
+		},
+	}, {
+		desc: "comma-ok assertion",
+		in: `
+_, ok := m3.OneofField.(*pb3.M3_StringOneof)
+if _, ok := m2.OneofField.(*pb2.M2_IntOneof); ok {
+}
+_, ok = m3.OneofField.(*pb3.M3_IntOneof)
+_ = ok
+`,
+		want: map[Level]string{
+			Green: `
+ok := m3.HasStringOneof()
+if ok := m2.HasIntOneof(); ok {
+}
+ok = m3.HasIntOneof()
+_ = ok
+`,
+		},
+	}, {
+		desc: "single-value assertion to blank",
+		in: `
+_ = m3.OneofField.(*pb3.M3_StringOneof)
+`,
+		want: map[Level]string{
+			Red: `
+_ = m3.OneofField.(*pb3.M3_StringOneof)
+`,
+		},
+	}, {
+		desc: "asserted wrapper variable",
+		in: `
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	_ = w.StringOneof
+	_ = m3.GetB()
+}
+w := m2.OneofField.(*pb2.M2_IntOneof)
+_ = w.IntOneof
+`,
+		want: map[Level]string{
+			Green: `
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	_ = w.StringOneof
+	_ = m3.GetB()
+}
+w := m2.OneofField.(*pb2.M2_IntOneof)
+_ = w.IntOneof
+`,
+			Yellow: `
+if ok := m3.HasStringOneof(); ok {
+	_ = m3.GetStringOneof()
+	_ = m3.GetB()
+}
+_ = m2.GetIntOneof()
+`,
+		},
+	}, {
+		desc:     "asserted wrapper variable used otherwise",
+		srcfiles: []string{"code.go", "pkg_test.go"},
+		in: `
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	w.StringOneof = "hello"
+}
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	m3.SetB(true)
+	_ = w.StringOneof
+}
+`,
+		want: map[Level]string{
+			Red: `
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	w.StringOneof = "hello"
+}
+if w, ok := m3.OneofField.(*pb3.M3_StringOneof); ok {
+	m3.SetB(true)
+	_ = w.StringOneof
+}
+`,
+		},
+	}, {
+		desc: "oneof variable",
+		in: `
+o := m3.OneofField
+switch o.(type) {
+case *pb3.M3_StringOneof:
+}
+if w, ok := o.(*pb3.M3_IntOneof); ok {
+	_ = w.IntOneof
+}
+`,
+		want: map[Level]string{
+			Green: `
+o := m3.OneofField
+switch o.(type) {
+case *pb3.M3_StringOneof:
+}
+if w, ok := o.(*pb3.M3_IntOneof); ok {
+	_ = w.IntOneof
+}
+`,
+			Yellow: `
+switch m3.WhichOneofField() {
+case pb3.M3_StringOneof_case:
+}
+if ok := m3.HasIntOneof(); ok {
+	_ = m3.GetIntOneof()
+}
+`,
+		},
+	}, {
+		desc: "message expressions other than identifiers",
+		in: `
+ms := []*pb3.M3{m3}
+_ = ms[0].OneofField.(*pb3.M3_StringOneof).StringOneof
+_, ok := m3.GetMsgOneof().OneofField.(*pb3.M3_StringOneof)
+_, ok = ms[0].OneofField.(*pb3.M3_IntOneof)
+_, ok = f().OneofField.(*pb3.M3_IntOneof)
+_ = ok
+`,
+		extra: `func f() *pb3.M3 { return nil }`,
+		want: map[Level]string{
+			Green: `
+ms := []*pb3.M3{m3}
+_ = ms[0].OneofField.(*pb3.M3_StringOneof).StringOneof
+ok := m3.GetMsgOneof().HasStringOneof()
+ok = ms[0].HasIntOneof()
+_, ok = f().OneofField.(*pb3.M3_IntOneof)
+_ = ok
+`,
+			Yellow: `
+ms := []*pb3.M3{m3}
+_ = ms[0].GetStringOneof()
+ok := m3.GetMsgOneof().HasStringOneof()
+ok = ms[0].HasIntOneof()
+_, ok = f().OneofField.(*pb3.M3_IntOneof)
+_ = ok
+`,
+		},
+	}, {
+		desc: "index of the message expression",
+		in: `
+ms := []*pb3.M3{m3, m3}
+i := 0
+o := ms[i].OneofField
+i = 1
+switch o.(type) {
+case *pb3.M3_StringOneof:
+}
+j := 0
+if w, ok := ms[j].OneofField.(*pb3.M3_StringOneof); ok {
+	j++
+	_ = w.StringOneof
+}
+k := 1
+o2 := ms[k].OneofField
+switch o2.(type) {
+case *pb3.M3_IntOneof:
+}
+`,
+		want: map[Level]string{
+			Yellow: `
+ms := []*pb3.M3{m3, m3}
+i := 0
+o := ms[i].OneofField
+i = 1
+switch o.(type) {
+case *pb3.M3_StringOneof:
+}
+j := 0
+if w, ok := ms[j].OneofField.(*pb3.M3_StringOneof); ok {
+	j++
+	_ = w.StringOneof
+}
+k := 1
+switch ms[k].WhichOneofField() {
+case pb3.M3_IntOneof_case:
+}
+`,
+		},
+	}, {
+		desc: "wrapper built before the assignment",
+		in: `
+w := &pb3.M3_StringOneof{StringOneof: "hello"}
+w.StringOneof += " world"
+m3.OneofField = w
+i := &pb3.M3_IntOneof{IntOneof: 42}
+m3.OneofField = i
+e := &pb3.M3_IntOneof{}
+m3.OneofField = e
+`,
+		want: map[Level]string{
+			Green: `
+w := &pb3.M3_StringOneof{StringOneof: "hello"}
+w.StringOneof += " world"
+m3.OneofField = w
+i := &pb3.M3_IntOneof{IntOneof: 42}
+m3.OneofField = i
+e := &pb3.M3_IntOneof{}
+m3.OneofField = e
+`,
+			Yellow: `
+w := "hello"
+w += " world"
+m3.SetStringOneof(w)
+i := int64(42)
+m3.SetIntOneof(i)
+m3.SetIntOneof(0)
+`,
+		},
+	}, {
+		desc:     "wrapper used after the assignment",
+		srcfiles: []string{"code.go", "pkg_test.go"},
+		in: `
+w := &pb3.M3_StringOneof{StringOneof: "hello"}
+m3.OneofField = w
+w.StringOneof = "changed"
+`,
+		want: map[Level]string{
+			Yellow: `
+w := &pb3.M3_StringOneof{StringOneof: "hello"}
+m3.OneofField = w
+w.StringOneof = "changed"
+`,
+		},
+	}}
+	runTableTests(t, tests)
+}
@@ -131,25 +131,42 @@ func oneofSwitchPost(c *cursor) bool {
 		return true
 	}
 
-	// If recv may have side effects, then move it to the init statement if
-	// possible (bail otherwise) in order to avoid cloning side effects into
-	// the body of the type-switch.
+	// If recv may have side effects, then move it to the init statement in
+	// order to avoid cloning side effects into the body of the type-switch. An
+	// existing init statement is evaluated first (see hoistSwitchInit).
+	//
+	// hoisted are the statements to insert before the switch statement. If
+	// wrap is set, the switch statement is wrapped in a block together with
+	// them because they define variables that must not leak into the
+	// enclosing scope.
+	var hoisted []dst.Stmt
+	var wrap bool
 	if !c.isSideEffectFree(recv) {
+		name := "xmsg"
 		if initStmt != nil {
-			if c.lvl.ge(Red) {
-				c.numUnsafeRewritesByReason[IncompleteRewrite]++
-				markMissingRewrite(stmt, "type switch with side effects and init statement")
-			}
-			c.Logf("ignoring: cannot move init statement with side effects")
-			return true
+			name = c.helperNameWithPrefix(stmt, name)
 		}
-		newVar := dst.NewIdent("xmsg")
+		newVar := dst.NewIdent(name)
 		c.setType(newVar, c.typeOf(recv))
-		initStmt = &dst.AssignStmt{
+		def := &dst.AssignStmt{
 			Lhs: []dst.Expr{newVar},
 			Tok: token.DEFINE,
 			Rhs: []dst.Expr{recv},
 		}
+		if initStmt != nil {
+			var ok bool
+			initStmt, hoisted, wrap, ok = c.hoistSwitchInit(initStmt, def)
+			if !ok {
+				if c.lvl.ge(Red) {
+					c.numUnsafeRewritesByReason[IncompleteRewrite]++
+					markMissingRewrite(stmt, "type switch with side effects and init statement")
+				}
+				c.Logf("ignoring: cannot move init statement with side effects")
+				return true
+			}
+		} else {
+			initStmt = def
+		}
 		recv = cloneIdent(c, newVar)
 	}
 
@@ -526,7 +543,7 @@ func oneofSwitchPost(c *cursor) bool {
 		c.setType(tag, c.typeOf(oneofIdent))
 	}
 	// Change the type from TypeSwitchStmt to SwitchStmt.
-	c.Replace(&dst.SwitchStmt{
+	sw := &dst.SwitchStmt{
 		Init: initStmt,
 		Tag:  tag,
 		Body: stmt.Body,
@@ -536,11 +553,109 @@ func oneofSwitchPost(c *cursor) bool {
 			Init:     stmt.Decs.Init,
 			Tag:      stmt.Decs.Assign,
 		},
-	})
+	}
+	switch {
+	case wrap:
+		block := &dst.BlockStmt{List: append(hoisted, sw)}
+		block.Decs.NodeDecs = sw.Decs.NodeDecs
+		sw.Decs.NodeDecs = dst.NodeDecs{Before: dst.NewLine, After: dst.NewLine}
+		c.Replace(block)
+	case len(hoisted) > 0:
+		moveDecsBeforeStart(hoisted[0], sw)
+		sw.Decs.Before = dst.NewLine
+		for _, h := range hoisted {
+			h.Decorations().After = dst.NewLine
+			c.InsertBefore(h)
+		}
+		c.Replace(sw)
+	default:
+		c.Replace(sw)
+	}
 
 	return true
 }
 
+// hoistSwitchInit combines the init statement of a type switch with the
+// definition def of the helper variable for the switched-on message such that
+// the init statement is still evaluated first:
+//
+//	switch a := g(); x := f().GetOneofField().(type) {
+//	=>
+//	switch a, xmsg := g(), f(); xmsg.WhichOneofField() {
+//
+// Function calls are evaluated in order, but other operands are not ordered
+// relative to them. If the init statement can't be combined with def, e.g.
+// because def uses a variable that the init statement defines, it is
+// hoisted before the switch statement (in a block if it defines variables) and
+// def becomes the new init statement:
+//
+//	switch a := v; x := f().GetOneofField().(type) {
+//	=>
+//	{
+//		a := v
+//		switch xmsg := f(); xmsg.WhichOneofField() {
+//		...
+//	}
+//
+// It returns the new init statement, the statements to hoist, whether to wrap
+// the switch statement in a block and whether the rewrite is possible.
+func (c *cursor) hoistSwitchInit(init dst.Stmt, def *dst.AssignStmt) (newInit dst.Stmt, hoisted []dst.Stmt, wrap, ok bool) {
+	as, isAssign := init.(*dst.AssignStmt)
+	if isAssign && as.Tok == token.DEFINE && len(as.Lhs) == len(as.Rhs) {
+		ordered := true
+		// The switched-on message can't be evaluated in the same assignment
+		// if it uses a variable that the init statement defines.
+		for _, lhs := range as.Lhs {
+			id, ok := lhs.(*dst.Ident)
+			if !ok {
+				continue
+			}
+			if obj := c.objectOf(id); obj != nil && slices.ContainsFunc(def.Rhs, func(rhs dst.Expr) bool { return usesObject(c, rhs, obj) }) {
+				ordered = false
+			}
+		}
+		for _, rhs := range as.Rhs {
+			if !ordered {
+				break
+			}
+			if tv, ok := c.typesInfo.types[rhs]; ok && tv.Value != nil {
+				continue // constant
+			}
+			call, ok := rhs.(*dst.CallExpr)
+			if !ok {
+				ordered = false
+				break
+			}
+			if tv, ok := c.typesInfo.types[call.Fun]; ok && tv.IsType() {
+				ordered = false // conversion
+				break
+			}
+		}
+		if ordered {
+			combined := &dst.AssignStmt{
+				Lhs:  append(slices.Clone(as.Lhs), def.Lhs...),
+				Tok:  token.DEFINE,
+				Rhs:  append(slices.Clone(as.Rhs), def.Rhs...),
+				Decs: as.Decs,
+			}
+			updateASTMap(c, as, combined)
+			return combined, nil, false, true
+		}
+	}
+
+	if _, isLabeled := c.Parent().(*dst.LabeledStmt); isLabeled {
+		// Wrapping the switch statement in a block would break the label.
+		return nil, nil, false, false
+	}
+	init.Decorations().Before = dst.NewLine
+	if isAssign && as.Tok == token.DEFINE {
+		return def, []dst.Stmt{init}, true, true
+	}
+	// The init statement defines no variables, so it can be inserted before
+	// the switch statement if that is in a statement list.
+	return def, []dst.Stmt{init}, c.Index() < 0, true
+}
+
 // whichOneofSignature returns the signature type of the method, of type t, with
 // the given name. It must have "Which" prefix (i.e. it is the method for
 // getting the oneof type). It returns an in-band nil (instead of a separate
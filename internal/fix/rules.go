@@ -32,6 +32,14 @@ func init() {
 		{name: "hasPre", pre: hasPre},
 		// converttosetter.go
 		{name: "convertToSetterPost", post: convertToSetterPost},
+		// The oneofAccessPre stage needs to run before oneofSwitchPost, which
+		// rewrites the type switches on the oneof variables it inlines, and
+		// before getPost, which marks the oneof field accesses it can't
+		// rewrite. It produces assignments of wrapper literals that assignPre
+		// rewrites to setters.
+		//
+		// oneofaccess.go
+		{name: "oneofAccessPre", pre: oneofAccessPre},
 		// oneofswitch.go
 		{name: "oneofSwitchPost", pre: oneofSwitchPost},
 		// appendprotos.go
@@ -43,6 +51,12 @@ func init() {
 		//
 		// assignswap.go
 		{name: "assignSwapPre", pre: assignSwapPre},
+		// The addressOfFieldPre stage needs to run before getPost and
+		// assignPre, which rewrite the helper variables it introduces to use
+		// getters and setters.
+		//
+		// addressoffield.go
+		{name: "addressOfFieldPre", pre: addressOfFieldPre},
 		// get.go
 		{name: "getPre", pre: getPre},
 		{name: "getPost", post: getPost},
@@ -32,7 +32,9 @@ func goListBase() (*fakeLoaderBase, error) {
 		"-deps=true",
 		// https://cs.opensource.google/go/x/tools/+/master:go/packages/golist.go;l=818-824;drc=977f6f71501a7b7b9b35d6125bf740401be8ce29
 		"-pgo=off",
-		"google.golang.org/open2opaque/internal/fix/testdata/fake")
+		"google.golang.org/open2opaque/internal/fix/testdata/fake",
+		"google.golang.org/open2opaque/internal/fix/testdata/containers",
+		"encoding/json", "flag", "fmt")
 	goList.Stderr = os.Stderr
 	stdout, err := goList.Output()
 	if err != nil {
@@ -51,6 +53,12 @@ func goListBase() (*fakeLoaderBase, error) {
 		// Imported by .pb.go files:
 		"google.golang.org/protobuf/reflect/protoreflect": true,
 		"google.golang.org/protobuf/runtime/protoimpl":    true,
+		// Imported by tests of containers from other packages:
+		"google.golang.org/open2opaque/internal/fix/testdata/containers": true,
+		// Imported by tests of knownPointerUses:
+		"encoding/json": true,
+		"flag":          true,
+		"fmt":           true,
 	}
 
 	importPathToFiles := make(map[string][]string)
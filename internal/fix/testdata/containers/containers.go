@@ -0,0 +1,24 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package containers declares containers of messages that are used by the
+// tests of rewriting value containers in other packages.
+package containers
+
+import pb2 "google.golang.org/open2opaque/internal/fix/testdata/proto2test_go_proto"
+
+// A has methods returning containers of messages.
+type A struct{}
+
+// List returns a slice of messages.
+func (A) List() []pb2.M2 { return nil }
+
+// Index returns a map of messages.
+func (A) Index() (map[string]pb2.M2, error) { return nil, nil }
+
+// List is a named slice of messages.
+type List []pb2.M2
+
+// Msgs is a slice of messages.
+var Msgs []pb2.M2
@@ -25,6 +25,18 @@ func usePointersPre(c *cursor) bool {
 		return false
 	}
 
+	// func f(m pb.M) pb.M   =>   func f(m *pb.M) *pb.M
+	// f(m)                  =>   f(&m)
+	// This is ok if all calls of f in the rewritten packages can be updated
+	// (see ValueSignatures).
+	declLists := c.rewriteValueSignatures(c.Node())
+
+	// []pb.M{pb.M{...}}   =>   []*pb.M{&pb.M{...}}
+	// f(&x.f)             =>   f(x.f)
+	// This is ok if the elements and struct fields are never copied (see
+	// rewriteValueContainers).
+	c.rewriteValueContainers(c.Node())
+
 	// x := pb.M{}       =>   x := &pb.M{}
 	// x := pb.M{F: V}   =>   x := &pb.M{F: V}
 	// This is ok if x is never copied (shallow copy).
@@ -46,6 +58,10 @@ func usePointersPre(c *cursor) bool {
 				}
 			}
 
+		case *dst.FieldList:
+			// Function declarations are handled by rewriteValueSignatures.
+			return !declLists[n]
+
 		case *dst.Field:
 			T := c.typeOf(n.Type)
 			_, alreadyStar := n.Type.(*dst.StarExpr)
@@ -74,6 +90,12 @@ func usePointersPre(c *cursor) bool {
 			// (e.g. &pb.M2{literal}), resulting in a pointer. Skip.
 			return true
 		}
+		if lit.Type == nil {
+			// The elided literal is an element of a container that is
+			// not changed (e.g. of another package): &{literal} is
+			// invalid. Skip.
+			return true
+		}
 
 		typ := c.typeOf(lit)
 		if _, ok := typ.Underlying().(*types.Pointer); ok {
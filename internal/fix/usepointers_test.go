@@ -228,8 +228,7 @@ _ = ms
 `,
 			want: map[Level]string{
 				Red: `
-// DO NOT SUBMIT: fix callers to work with a pointer (go/goprotoapi-findings#message-value)
-ms := []pb2.M2{&{S: nil}, pb2.M2_builder{S: nil}.Build(), &{}}
+ms := []*pb2.M2{pb2.M2_builder{S: nil}.Build(), pb2.M2_builder{S: nil}.Build(), {}}
 _ = ms
 `,
 			},
@@ -246,10 +245,10 @@ _ = ms
 `,
 			want: map[Level]string{
 				Red: `
-// DO NOT SUBMIT: fix callers to work with a pointer (go/goprotoapi-findings#message-value)
 m := &pb2.M2{}
-ms := []pb2.M2{m}
-f(&m)
+// DO NOT SUBMIT: stores a pointer instead of a copy of the message (go/goprotoapi-findings#message-value)
+ms := []*pb2.M2{m}
+f(m)
 _ = ms
 `,
 			},
@@ -267,9 +266,8 @@ g(m)
 `,
 			want: map[Level]string{
 				Red: `
-// DO NOT SUBMIT: fix callers to work with a pointer (go/goprotoapi-findings#message-value)
 m := pb2.M2_builder{S: nil}.Build()
-f(&m)
+f(m)
 g(m)
 `,
 			},
@@ -421,7 +419,6 @@ for _, tt := range []struct {
 `,
 			want: map[Level]string{
 				Red: `
-// DO NOT SUBMIT: fix callers to work with a pointer (go/goprotoapi-findings#message-value)
 for _, tt := range []struct {
 	want *pb2.M2
 }{
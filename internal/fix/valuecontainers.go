@@ -0,0 +1,649 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+const (
+	shallowCopyComment  = "// DO NOT SUBMIT: shallow copy of a message (go/goprotoapi-findings#message-value)"
+	storedPtrComment    = "// DO NOT SUBMIT: stores a pointer instead of a copy of the message (go/goprotoapi-findings#message-value)"
+	storeComment        = "// DO NOT SUBMIT: fix to store a pointer to the message (go/goprotoapi-findings#message-value)"
+	rangeComment        = "// DO NOT SUBMIT: fix the loop to work with a pointer (go/goprotoapi-findings#message-value)"
+	nilElementsComment  = "// DO NOT SUBMIT: the messages are nil pointers until they are set (go/goprotoapi-findings#message-value)"
+	nilFieldUsedComment = "// DO NOT SUBMIT: the message may be a nil pointer (go/goprotoapi-findings#message-value)"
+)
+
+// rewriteValueContainers changes the message-valued elements of slices,
+// arrays, maps and channels to pointers and updates the uses of those
+// elements, and of the message-valued struct fields declared in the package
+// (which usePointersPre changes to pointers):
+//
+//	[]pb.M{{...}, pb.M{...}}     =>   []*pb.M{{...}, &pb.M{...}}
+//	f(&s[i])                     =>   f(s[i])
+//	_ = x.f.GetS()               =>   _ = x.f.GetS()
+//	for _, v := range s { ... }  =>   (v is a pointer if it is never copied)
+//	ch <- pb.M{...}              =>   ch <- &pb.M{...}
+//
+// Uses that depend on the elements being values are kept working if possible
+// and marked:
+//
+//	s[i] = m   =>   s[i] = &m     (m is no longer copied)
+//	g(s[i])    =>   g(*s[i])      (shallow copy)
+//	b := a     =>   b := a        (a and b share the messages)
+//
+// Elements and fields that are not set explicitly are nil pointers instead of
+// empty messages. Such cases are marked, too.
+func (c *cursor) rewriteValueContainers(file dst.Node) {
+	// The element uses that are already handled as part of their parent.
+	done := make(map[dst.Expr]bool)
+	dstutil.Apply(file, func(cur *dstutil.Cursor) bool {
+		switch n := cur.Node().(type) {
+		case *dst.CompositeLit:
+			c.pointerElements(file, n, done)
+
+		case *dst.SendStmt:
+			if _, ok := c.changedElem(n.Chan); ok {
+				n.Value = c.storedPointer(file, n.Value, done)
+			}
+
+		case *dst.CallExpr:
+			if !c.isBuiltin(n.Fun) && !c.looksLikePrintf(n) {
+				c.markContainerCopies(file, n.Args)
+			}
+			if !c.isBuiltinFunc(n.Fun, "append") || n.Ellipsis || len(n.Args) < 2 {
+				break
+			}
+			if _, ok := c.changedElem(n.Args[0]); !ok {
+				break
+			}
+			for i, arg := range n.Args[1:] {
+				n.Args[i+1] = c.storedPointer(file, arg, done)
+			}
+
+		case *dst.ReturnStmt:
+			c.markContainerCopies(file, n.Results)
+
+		case *dst.ValueSpec:
+			c.markContainerCopies(file, n.Values)
+
+		case *dst.AssignStmt:
+			if (n.Tok == token.ASSIGN || n.Tok == token.DEFINE) && len(n.Lhs) == len(n.Rhs) {
+				for i, rhs := range n.Rhs {
+					if !isBlank(n.Lhs[i]) {
+						c.markContainerCopies(file, []dst.Expr{rhs})
+					}
+				}
+			}
+			if n.Tok != token.ASSIGN || len(n.Lhs) != len(n.Rhs) {
+				break
+			}
+			for i, lhs := range n.Lhs {
+				if c.containerElem(lhs) {
+					done[lhs] = true
+					c.setType(lhs, types.NewPointer(c.typeOf(lhs)))
+					n.Rhs[i] = c.storedPointer(file, n.Rhs[i], done)
+				}
+			}
+
+		case *dst.RangeStmt:
+			c.pointerRangeValue(n)
+
+		case *dst.UnaryExpr:
+			if n.Op == token.AND && c.containerElem(n.X) && !done[n.X] {
+				// &s[i]   =>   s[i]
+				done[n.X] = true
+				c.setType(n.X, c.typeOf(n))
+				cur.Replace(n.X)
+				return true
+			}
+			if n.Op == token.ARROW && c.containerElem(n) && !done[n] {
+				done[n] = true
+				c.pointerElementUse(file, cur, n)
+			}
+
+		case *dst.IndexExpr, *dst.SelectorExpr:
+			x := n.(dst.Expr)
+			if c.containerElem(x) && !done[x] {
+				done[x] = true
+				c.pointerElementUse(file, cur, x)
+			}
+		}
+		return true
+	}, nil)
+
+	// The element types are changed last: the uses above are identified
+	// by the original types.
+	dstutil.Apply(file, func(cur *dstutil.Cursor) bool {
+		switch n := cur.Node().(type) {
+		case *dst.ArrayType:
+			if c.pointerElementType(&n.Elt) && n.Len != nil && !isFullArrayLit(n, cur.Parent()) {
+				// var a [2]pb.M   =>   var a [2]*pb.M
+				addCommentAbove(file, n, nilElementsComment)
+				c.numUnsafeRewritesByReason[MaybeNilPointerDeref]++
+			}
+		case *dst.MapType:
+			c.pointerElementType(&n.Value)
+		case *dst.ChanType:
+			c.pointerElementType(&n.Value)
+		case *dst.CallExpr:
+			// make([]pb.M, n)   =>   make([]*pb.M, n)
+			if c.isBuiltinFunc(n.Fun, "make") && len(n.Args) > 1 && !isZero(n.Args[1]) {
+				if _, ok := n.Args[0].(*dst.ArrayType); ok {
+					if elem, ok := c.changedElem(n); ok && c.shouldUpdateType(elem) {
+						addCommentAbove(file, n, nilElementsComment)
+						c.numUnsafeRewritesByReason[MaybeNilPointerDeref]++
+					}
+				}
+			}
+		}
+		return true
+	}, nil)
+}
+
+// pointerElementType changes the message-valued element type *elt to a
+// pointer. It reports whether it changed *elt.
+func (c *cursor) pointerElementType(elt *dst.Expr) bool {
+	t := c.typeOf(*elt)
+	if !isMessageValue(t) || !c.shouldUpdateType(t) {
+		return false
+	}
+	sexpr := &dst.StarExpr{X: *elt}
+	c.setType(sexpr, types.NewPointer(t))
+	*elt = sexpr
+	c.numUnsafeRewritesByReason[PotentialBuildBreakage]++
+	return true
+}
+
+// changedElem returns the element type of the container x if
+// rewriteValueContainers changes it to a pointer: x must be a slice, array,
+// map or channel of messages that is declared in the current package.
+func (c *cursor) changedElem(x dst.Expr) (types.Type, bool) {
+	var elem types.Type
+	switch t := c.underlyingTypeOfOrNil(x).(type) {
+	case *types.Slice:
+		elem = t.Elem()
+	case *types.Array:
+		elem = t.Elem()
+	case *types.Map:
+		elem = t.Elem()
+	case *types.Chan:
+		elem = t.Elem()
+	case *types.Pointer:
+		a, ok := t.Elem().Underlying().(*types.Array)
+		if !ok {
+			return nil, false
+		}
+		elem = a.Elem()
+	default:
+		return nil, false
+	}
+	if !isMessageValue(elem) || !c.shouldUpdateType(elem) || !c.declaredLocally(x) {
+		return nil, false
+	}
+	return elem, true
+}
+
+// declaredLocally reports whether the type of the container x is written in
+// the current package, i.e. whether the rewrite changes its element type:
+// x is a composite literal or make call of a type written in the package, or
+// a variable, field or function result declared with such a type. Containers
+// that come from other packages are left alone.
+func (c *cursor) declaredLocally(x dst.Expr) bool {
+	switch e := x.(type) {
+	case *dst.ParenExpr:
+		return c.declaredLocally(e.X)
+	case *dst.StarExpr:
+		return c.declaredLocally(e.X)
+	case *dst.UnaryExpr:
+		return e.Op == token.AND && c.declaredLocally(e.X)
+	case *dst.IndexExpr:
+		return c.declaredLocally(e.X)
+	case *dst.ArrayType, *dst.MapType, *dst.ChanType:
+		return true
+	case *dst.CompositeLit:
+		// The type of an elided literal is written in the enclosing one.
+		return e.Type == nil || c.declaredLocally(e.Type)
+	case *dst.Ident:
+		return c.localObject(c.objectOf(e))
+	case *dst.SelectorExpr:
+		return c.localObject(c.objectOf(e.Sel))
+	case *dst.CallExpr:
+		switch {
+		case c.isBuiltinFunc(e.Fun, "make"):
+			return len(e.Args) > 0 && c.declaredLocally(e.Args[0])
+		case c.isBuiltinFunc(e.Fun, "append"):
+			return len(e.Args) > 0 && c.declaredLocally(e.Args[0])
+		}
+		if tv, ok := c.typesInfo.types[e.Fun]; ok && tv.IsType() {
+			// A conversion.
+			return c.declaredLocally(e.Fun)
+		}
+		return c.localResult(c.calledFunc(e), 0)
+	}
+	return false
+}
+
+// localObject reports whether obj is a type declared in the current package or
+// a variable whose type is written in the current package.
+func (c *cursor) localObject(obj types.Object) bool {
+	if obj == nil || obj.Pkg() != c.pkg.TypePkg {
+		return false
+	}
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		_, ok := obj.Type().(*types.TypeParam)
+		return !ok
+	case *types.Var:
+		return c.localVarType(obj)
+	}
+	return false
+}
+
+// localVarType reports whether the type of v, which is declared in the current
+// package, is written in the package: in its declaration or in the expression
+// that it is initialized with.
+func (c *cursor) localVarType(v *types.Var) bool {
+	for _, f := range c.pkg.Files {
+		if v.Pos() < f.AST.Pos() || v.Pos() >= f.AST.End() {
+			continue
+		}
+		path, _ := astutil.PathEnclosingInterval(f.AST, v.Pos(), v.Pos())
+		if len(path) < 2 {
+			return false
+		}
+		switch decl := path[1].(type) {
+		case *ast.Field:
+			return c.declaredLocallyAST(decl.Type)
+		case *ast.ValueSpec:
+			if decl.Type != nil {
+				return c.declaredLocallyAST(decl.Type)
+			}
+			for i, name := range decl.Names {
+				if name.Pos() == v.Pos() {
+					return c.initializedLocally(decl.Values, i)
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range decl.Lhs {
+				if lhs.Pos() == v.Pos() {
+					return c.initializedLocally(decl.Rhs, i)
+				}
+			}
+		case *ast.RangeStmt:
+			// The elements of a container of containers.
+			return c.declaredLocallyAST(decl.X)
+		}
+		return false
+	}
+	return false
+}
+
+// initializedLocally reports whether the type of the i-th value of values, the
+// initialization of a declaration, is written in the current package.
+func (c *cursor) initializedLocally(values []ast.Expr, i int) bool {
+	switch {
+	case i < len(values) && (len(values) > 1 || i == 0):
+		return c.declaredLocallyAST(values[i])
+	case len(values) == 1:
+		// a, b := f()
+		call, ok := c.typesInfo.dstMap[values[0]].(*dst.CallExpr)
+		return ok && c.localResult(c.calledFunc(call), i)
+	}
+	return false
+}
+
+// declaredLocallyAST is declaredLocally for an expression of the original
+// syntax tree.
+func (c *cursor) declaredLocallyAST(x ast.Expr) bool {
+	d, ok := c.typesInfo.dstMap[x].(dst.Expr)
+	return ok && c.declaredLocally(d)
+}
+
+// localResult reports whether the type of the i-th result of fn is written in
+// the current package. The results of generic functions are not: their
+// instantiated types are not written in the declaration.
+func (c *cursor) localResult(fn *types.Func, i int) bool {
+	if fn == nil {
+		return false
+	}
+	if sig := fn.Type().(*types.Signature); sig.TypeParams().Len() > 0 || sig.RecvTypeParams().Len() > 0 {
+		return false
+	}
+	fd := c.funcDecl(fn)
+	if fd == nil || fd.Type.Results == nil {
+		return false
+	}
+	for _, res := range fd.Type.Results.List {
+		n := max(len(res.Names), 1)
+		if i < n {
+			return c.declaredLocallyAST(res.Type)
+		}
+		i -= n
+	}
+	return false
+}
+
+// isBuiltinFunc reports whether fun refers to the builtin function name.
+func (c *cursor) isBuiltinFunc(fun dst.Expr, name string) bool {
+	id, ok := fun.(*dst.Ident)
+	return ok && id.Name == name && c.isBuiltin(id)
+}
+
+// containerElem reports whether x is a message-valued element or struct
+// field that rewriteValueContainers changes to a pointer: s[i], m[k], <-ch or
+// x.f.
+func (c *cursor) containerElem(x dst.Expr) bool {
+	if t := c.typeOfOrNil(x); t == nil || !isMessageValue(t) || !c.shouldUpdateType(t) {
+		return false
+	}
+	switch e := x.(type) {
+	case *dst.IndexExpr:
+		_, ok := c.changedElem(e.X)
+		return ok
+	case *dst.UnaryExpr:
+		if e.Op != token.ARROW {
+			return false
+		}
+		_, ok := c.changedElem(e.X)
+		return ok
+	case *dst.SelectorExpr:
+		v, ok := c.objectOf(e.Sel).(*types.Var)
+		return ok && v.IsField() && v.Pkg() == c.pkg.TypePkg
+	}
+	return false
+}
+
+// markContainerCopies marks the values in xs that copy an array or struct
+// with changed elements or fields. The copy shares the messages with the
+// original now.
+func (c *cursor) markContainerCopies(file dst.Node, xs []dst.Expr) {
+	for _, x := range xs {
+		if c.copiesChangedElems(x) {
+			addCommentAbove(file, x, shallowCopyComment)
+			c.numUnsafeRewritesByReason[ShallowCopy]++
+		}
+	}
+}
+
+// copiesChangedElems reports whether using x as a value copies an array or
+// struct whose message-valued elements or fields rewriteValueContainers
+// changes to pointers. Composite literals and call results are not copies of
+// anything else.
+func (c *cursor) copiesChangedElems(x dst.Expr) bool {
+	switch e := x.(type) {
+	case *dst.ParenExpr:
+		return c.copiesChangedElems(e.X)
+	case *dst.CompositeLit, *dst.CallExpr:
+		return false
+	}
+	t := c.typeOfOrNil(x)
+	if t == nil || isMessageValue(t) {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Array:
+		_, ok := c.changedElem(x)
+		return ok
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if f.Pkg() == c.pkg.TypePkg && isMessageValue(f.Type()) && c.shouldUpdateType(f.Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pointerElementUse updates the use of the element x, which has become a
+// pointer.
+func (c *cursor) pointerElementUse(file dst.Node, cur *dstutil.Cursor, x dst.Expr) {
+	t := c.typeOf(x)
+	ptr := types.NewPointer(t)
+	switch p := cur.Parent().(type) {
+	case *dst.SelectorExpr:
+		// s[i].GetS() and x.f.GetS() work with pointers, but zero
+		// struct fields and array elements are nil pointers now.
+		c.setType(x, ptr)
+		if mayBeNil(c, x) && !nilSafeSelection(c, p) {
+			addCommentAbove(file, p, nilFieldUsedComment)
+			c.numUnsafeRewritesByReason[MaybeNilPointerDeref]++
+		}
+		return
+
+	case *dst.ExprStmt:
+		// <-ch
+		c.setType(x, ptr)
+		return
+
+	case *dst.AssignStmt:
+		if cur.Name() == "Rhs" && len(p.Lhs) == len(p.Rhs) && isBlank(p.Lhs[cur.Index()]) {
+			// _ = s[i]
+			c.setType(x, ptr)
+			return
+		}
+		if cur.Name() == "Rhs" && p.Tok == token.DEFINE && len(p.Rhs) == 1 && c.definePointer(p, ptr) {
+			c.setType(x, ptr)
+			return
+		}
+	}
+	if c.looksLikePrintf(cur.Parent()) {
+		// Printing a pointer only adds a "&" to the output.
+		c.setType(x, ptr)
+		return
+	}
+
+	// Keep the shallow copy working, but mark it.
+	c.setType(x, ptr)
+	star := &dst.StarExpr{X: x}
+	c.setType(star, t)
+	cur.Replace(star)
+	addCommentAbove(file, star, shallowCopyComment)
+	c.numUnsafeRewritesByReason[ShallowCopy]++
+}
+
+// mayBeNil reports whether the element x, which has become a pointer, is nil
+// unless it was set: a struct field or an array element.
+func mayBeNil(c *cursor, x dst.Expr) bool {
+	switch x := x.(type) {
+	case *dst.SelectorExpr:
+		return true
+	case *dst.IndexExpr:
+		t := c.underlyingTypeOfOrNil(x.X)
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem().Underlying()
+		}
+		_, ok := t.(*types.Array)
+		return ok
+	}
+	return false
+}
+
+// nilSafeSelection reports whether sel, whose X has become a pointer, works
+// for nil pointers just like for empty messages.
+func nilSafeSelection(c *cursor, sel *dst.SelectorExpr) bool {
+	if _, ok := c.objectOf(sel.Sel).(*types.Func); !ok {
+		// Direct field accesses dereference the pointer.
+		return false
+	}
+	name := sel.Sel.Name
+	for _, prefix := range []string{"Get", "Has", "Which"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return name == "String" || name == "ProtoReflect"
+}
+
+// definePointer updates the variable defined by as (v := s[i] or
+// v, ok := m[k]) to be a pointer of type ptr, if v is never copied. It
+// reports whether it did.
+func (c *cursor) definePointer(as *dst.AssignStmt, ptr types.Type) bool {
+	id, ok := as.Lhs[0].(*dst.Ident)
+	if !ok {
+		return false
+	}
+	if id.Name == "_" {
+		return true
+	}
+	block := c.enclosingBlock(as)
+	if block == nil {
+		return false
+	}
+	rest := block.List[slices.Index(block.List, dst.Stmt(as))+1:]
+	if !replaceWithPtr(c, id, rest) {
+		return false
+	}
+	c.setVarType(block, id, ptr)
+	return true
+}
+
+// pointerRangeValue updates the value variable of the range loop n over a
+// changed container to be a pointer. Loops that copy the value are marked.
+func (c *cursor) pointerRangeValue(n *dst.RangeStmt) {
+	elem, ok := c.changedElem(n.X)
+	if !ok {
+		return
+	}
+	v := n.Value
+	if _, ok := c.underlyingTypeOfOrNil(n.X).(*types.Chan); ok {
+		v = n.Key
+	}
+	id, ok := v.(*dst.Ident)
+	if !ok || id.Name == "_" {
+		return
+	}
+	if n.Tok == token.DEFINE && replaceWithPtr(c, id, n.Body.List) {
+		c.setVarType(n, id, types.NewPointer(elem))
+		return
+	}
+	n.Decs.Start.Append(rangeComment)
+	n.Decs.Before = dst.NewLine
+	c.numUnsafeRewritesByReason[IncompleteRewrite]++
+}
+
+// pointerElements stores pointers in the composite literal lit if it is of a
+// changed container type, or of a struct type of the current package with
+// message-valued fields.
+func (c *cursor) pointerElements(file dst.Node, lit *dst.CompositeLit, done map[dst.Expr]bool) {
+	t := c.typeOfOrNil(lit)
+	if t == nil {
+		return
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		// An elided &T{...} in []*T{{...}}.
+		t = p.Elem()
+	}
+	var changed func(i int, kv *dst.KeyValueExpr) bool
+	switch u := t.Underlying().(type) {
+	case *types.Slice, *types.Array, *types.Map:
+		if _, ok := c.changedElem(lit); !ok {
+			return
+		}
+		changed = func(int, *dst.KeyValueExpr) bool { return true }
+	case *types.Struct:
+		changed = func(i int, kv *dst.KeyValueExpr) bool {
+			var f *types.Var
+			if kv == nil {
+				f = u.Field(i)
+			} else if id, ok := kv.Key.(*dst.Ident); ok {
+				f, _ = c.objectOf(id).(*types.Var)
+			}
+			return f != nil && f.Pkg() == c.pkg.TypePkg && isMessageValue(f.Type()) && c.shouldUpdateType(f.Type())
+		}
+	default:
+		return
+	}
+	for i, elt := range lit.Elts {
+		kv, _ := elt.(*dst.KeyValueExpr)
+		if !changed(i, kv) {
+			continue
+		}
+		if kv != nil {
+			kv.Value = c.storedPointer(file, kv.Value, done)
+		} else {
+			lit.Elts[i] = c.storedPointer(file, elt, done)
+		}
+	}
+}
+
+// storedPointer returns a pointer to the message x that is stored in a
+// changed container or struct field.
+func (c *cursor) storedPointer(file dst.Node, x dst.Expr, done map[dst.Expr]bool) dst.Expr {
+	t := c.typeOfOrNil(x)
+	if t == nil || !isMessageValue(t) {
+		return x
+	}
+	switch e := x.(type) {
+	case *dst.CompositeLit:
+		if e.Type == nil {
+			// []*pb.M{{...}} is short for []*pb.M{&pb.M{...}}.
+			c.setType(e, types.NewPointer(t))
+			return e
+		}
+		return addr(c, e)
+	case *dst.StarExpr, *dst.Ident:
+		if id, ok := e.(*dst.Ident); ok {
+			if _, ok := c.objectOf(id).(*types.Var); !ok {
+				break
+			}
+		}
+		// s[i] = *p   =>   s[i] = p
+		// s[i] = m    =>   s[i] = &m
+		addCommentAbove(file, x, storedPtrComment)
+		c.numUnsafeRewritesByReason[PointerAlias]++
+		return addr(c, e)
+	}
+	if c.containerElem(x) {
+		// The element is a pointer, too.
+		done[x] = true
+		c.setType(x, types.NewPointer(t))
+		addCommentAbove(file, x, storedPtrComment)
+		c.numUnsafeRewritesByReason[PointerAlias]++
+		return x
+	}
+	addCommentAbove(file, x, storeComment)
+	c.numUnsafeRewritesByReason[IncompleteRewrite]++
+	return x
+}
+
+// isFullArrayLit reports whether the array type typ is the type of the
+// composite literal parent, which sets all of its elements.
+func isFullArrayLit(typ *dst.ArrayType, parent dst.Node) bool {
+	lit, ok := parent.(*dst.CompositeLit)
+	if !ok || lit.Type != typ {
+		return false
+	}
+	if _, ok := typ.Len.(*dst.Ellipsis); ok {
+		return true
+	}
+	n, ok := typ.Len.(*dst.BasicLit)
+	if !ok || n.Kind != token.INT {
+		return false
+	}
+	if len(lit.Elts) > 0 {
+		if _, ok := lit.Elts[0].(*dst.KeyValueExpr); ok {
+			return false
+		}
+	}
+	return n.Value == strconv.Itoa(len(lit.Elts))
+}
+
+// isZero reports whether x is the constant 0.
+func isZero(x dst.Expr) bool {
+	n, ok := x.(*dst.BasicLit)
+	return ok && n.Kind == token.INT && n.Value == "0"
+}
@@ -0,0 +1,287 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func TestValueContainers(t *testing.T) {
+	const extra = `
+func f(*pb2.M2) {}
+func G(pb2.M2) {}
+
+type config struct {
+	m   pb2.M2
+	ms  []pb2.M2
+	idx map[string]pb2.M2
+}
+`
+	tests := []test{{
+		desc:  "slice elements",
+		extra: extra,
+		in: `
+ms := []pb2.M2{pb2.M2{S: nil}}
+ms = append(ms, pb2.M2{})
+f(&ms[0])
+_ = ms[0].GetS()
+G(ms[0])
+`,
+		want: map[Level]string{
+			Yellow: `
+ms := []pb2.M2{pb2.M2{S: nil}}
+ms = append(ms, pb2.M2{})
+f(&ms[0])
+_ = ms[0].GetS()
+G(ms[0])
+`,
+			Red: `
+ms := []*pb2.M2{pb2.M2_builder{S: nil}.Build()}
+ms = append(ms, &pb2.M2{})
+f(ms[0])
+_ = ms[0].GetS()
+// DO NOT SUBMIT: shallow copy of a message (go/goprotoapi-findings#message-value)
+G(*ms[0])
+`,
+		},
+	}, {
+		desc:  "range value",
+		extra: extra,
+		in: `
+var ms []pb2.M2
+for _, m := range ms {
+	f(&m)
+}
+for _, m := range ms {
+	G(m)
+}
+`,
+		want: map[Level]string{
+			Red: `
+var ms []*pb2.M2
+for _, m := range ms {
+	f(m)
+}
+// DO NOT SUBMIT: fix the loop to work with a pointer (go/goprotoapi-findings#message-value)
+for _, m := range ms {
+	G(m)
+}
+`,
+		},
+	}, {
+		desc:  "map values",
+		extra: extra,
+		in: `
+idx := map[string]pb2.M2{"a": {}}
+idx["b"] = pb2.M2{}
+m, ok := idx["a"]
+_, _ = m.GetS(), ok
+`,
+		want: map[Level]string{
+			Red: `
+idx := map[string]*pb2.M2{"a": {}}
+idx["b"] = &pb2.M2{}
+m, ok := idx["a"]
+_, _ = m.GetS(), ok
+`,
+		},
+	}, {
+		desc:  "channel",
+		extra: extra,
+		in: `
+ch := make(chan pb2.M2, 1)
+ch <- pb2.M2{}
+m := <-ch
+f(&m)
+`,
+		want: map[Level]string{
+			Red: `
+ch := make(chan *pb2.M2, 1)
+ch <- &pb2.M2{}
+m := <-ch
+f(m)
+`,
+		},
+	}, {
+		desc:  "struct fields",
+		extra: extra,
+		in: `
+c := &config{m: pb2.M2{}}
+f(&c.m)
+_ = c.m.GetS()
+c.m = *m2
+`,
+		want: map[Level]string{
+			Red: `
+c := &config{m: &pb2.M2{}}
+f(c.m)
+_ = c.m.GetS()
+// DO NOT SUBMIT: stores a pointer instead of a copy of the message (go/goprotoapi-findings#message-value)
+c.m = m2
+`,
+		},
+	}, {
+		desc:  "nil elements and fields",
+		extra: extra,
+		in: `
+ms := make([]pb2.M2, 3)
+var c config
+c.m.SetS("hello")
+_ = ms
+`,
+		want: map[Level]string{
+			Red: `
+// DO NOT SUBMIT: the messages are nil pointers until they are set (go/goprotoapi-findings#message-value)
+ms := make([]*pb2.M2, 3)
+var c config
+// DO NOT SUBMIT: the message may be a nil pointer (go/goprotoapi-findings#message-value)
+c.m.SetS("hello")
+_ = ms
+`,
+		},
+	}, {
+		desc:  "arrays",
+		extra: extra,
+		in: `
+var a [2]pb2.M2
+b := [...]pb2.M2{{}, {}}
+a[0] = b[1]
+_ = a
+`,
+		want: map[Level]string{
+			Red: `
+// DO NOT SUBMIT: the messages are nil pointers until they are set (go/goprotoapi-findings#message-value)
+var a [2]*pb2.M2
+b := [...]*pb2.M2{{}, {}}
+// DO NOT SUBMIT: stores a pointer instead of a copy of the message (go/goprotoapi-findings#message-value)
+a[0] = b[1]
+_ = a
+`,
+		},
+	}, {
+		desc: "copied containers",
+		extra: extra + `
+func keep(c config) config { return c }
+`,
+		in: `
+a := [2]pb2.M2{}
+b := a
+b[0].SetS("x")
+c := config{}
+d := c
+d.m.SetS("y")
+_ = keep(c)
+_ = len(a)
+_ = [2]pb2.M2{}
+`,
+		want: map[Level]string{
+			Red: `
+// DO NOT SUBMIT: the messages are nil pointers until they are set (go/goprotoapi-findings#message-value)
+a := [2]*pb2.M2{}
+// DO NOT SUBMIT: shallow copy of a message (go/goprotoapi-findings#message-value)
+b := a
+// DO NOT SUBMIT: the message may be a nil pointer (go/goprotoapi-findings#message-value)
+b[0].SetS("x")
+c := config{}
+// DO NOT SUBMIT: shallow copy of a message (go/goprotoapi-findings#message-value)
+d := c
+// DO NOT SUBMIT: the message may be a nil pointer (go/goprotoapi-findings#message-value)
+d.m.SetS("y")
+// DO NOT SUBMIT: shallow copy of a message (go/goprotoapi-findings#message-value)
+_ = keep(c)
+_ = len(a)
+// DO NOT SUBMIT: the messages are nil pointers until they are set (go/goprotoapi-findings#message-value)
+_ = [2]*pb2.M2{}
+`,
+		},
+	}}
+	runTableTests(t, tests)
+}
+
+func TestValueContainersOtherPackage(t *testing.T) {
+	const header = `package p
+
+import (
+	"google.golang.org/open2opaque/internal/fix/testdata/containers"
+
+	pb2 "google.golang.org/open2opaque/internal/fix/testdata/proto2test_go_proto"
+)
+
+func fill(*pb2.M2) {}
+
+type local []pb2.M2
+
+func list() local { return nil }
+
+func test_function(a containers.A) {
+	_ = "TEST CODE STARTS HERE"
+`
+	tests := []struct {
+		desc    string
+		in      string
+		wantRed string
+	}{{
+		desc: "method result",
+		in: `s := a.List()
+fill(&s[0])
+for _, x := range s {
+	fill(&x)
+}`,
+		wantRed: `s := a.List()
+fill(&s[0])
+for _, x := range s {
+	fill(&x)
+}`,
+	}, {
+		desc: "multiple results",
+		in: `idx, _ := a.Index()
+for _, x := range idx {
+	fill(&x)
+}`,
+		wantRed: `idx, _ := a.Index()
+for _, x := range idx {
+	fill(&x)
+}`,
+	}, {
+		desc: "named type and variable",
+		in: `var l containers.List
+l = append(l, containers.Msgs...)
+fill(&l[0])
+fill(&containers.Msgs[0])
+c := containers.List{{}}
+fill(&c[0])`,
+		wantRed: `var l containers.List
+l = append(l, containers.Msgs...)
+fill(&l[0])
+fill(&containers.Msgs[0])
+c := containers.List{{}}
+fill(&c[0])`,
+	}, {
+		desc: "local type",
+		in: `s := list()
+fill(&s[0])
+var l local = s
+fill(&l[0])`,
+		wantRed: `s := list()
+fill(s[0])
+var l local = s
+fill(l[0])`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			src := header + tt.in + "\n\t_ = \"TEST CODE ENDS HERE\"\n}\n"
+			got, _, err := fixSource(context.Background(), src, "pkg.go", ConfiguredPackage{}, []Level{Green, Yellow, Red})
+			if err != nil {
+				t.Fatalf("fixSource() failed: %v; Full input:\n%s", err, src)
+			}
+			if d := diff.Diff(tt.wantRed, got[Red]); d != "" {
+				t.Errorf("fixSource(%q) = (red) %q; want %q\ndiff:\n%s", tt.in, got[Red], tt.wantRed, d)
+			}
+		})
+	}
+}
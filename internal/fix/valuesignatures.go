@@ -0,0 +1,838 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+)
+
+// ValueSignatures determines the functions and methods whose message-valued
+// parameters and results (pb.M) usePointersPre changes to pointers (*pb.M),
+// together with all of their calls.
+//
+// A function can only be changed if all of its calls can be updated: the
+// arguments must be addressable, composite literals or dereferenced pointers,
+// the results must only be used as pointers and the function must not be used
+// as a value (e.g. as a callback), which would require a different function
+// type. Functions that are part of an exported API are refused unless no code
+// outside of the rewritten packages can call them.
+//
+// Without a ValueSignatures in the ConfiguredPackage, each package is analyzed
+// on its own. For a cross-package rewrite, create a ValueSignatures with
+// NewValueSignatures and add all packages that are rewritten before fixing any
+// of them.
+type ValueSignatures struct {
+	// rewritten is the set of import paths of the rewritten packages and
+	// importers lists the packages below an import path, for a cross-package
+	// rewrite. Both are nil otherwise.
+	rewritten map[string]bool
+	importers func(parent string) ([]string, error)
+
+	mu sync.Mutex
+	// funcs is keyed by types.Func.FullName because the packages are loaded
+	// (and type-checked) separately.
+	funcs map[string]*valueFunc
+	// open is keyed by the import path of the directory containing an
+	// internal directory. It records why the API of the internal packages
+	// below it is not closed, or "" if it is.
+	open map[string]string
+}
+
+// valueFunc describes a function with message-valued parameters or results.
+type valueFunc struct {
+	declared bool
+	// exported is set if code outside of the declaring package can call the
+	// function, closed if that code is known to be rewritten as well.
+	exported, closed bool
+	// refused is the first reason why the function can't be changed, or "".
+	refused string
+}
+
+// NewValueSignatures returns an empty ValueSignatures for a cross-package
+// rewrite of the packages with the import paths rewritten. The exported API of
+// an added internal package is only changed if all packages that can import it
+// are rewritten. importers returns the import paths of the packages that can
+// import the internal packages of parent: parent itself and all packages below
+// it.
+func NewValueSignatures(rewritten []string, importers func(parent string) ([]string, error)) *ValueSignatures {
+	vs := &ValueSignatures{
+		rewritten: make(map[string]bool),
+		importers: importers,
+		funcs:     make(map[string]*valueFunc),
+		open:      make(map[string]string),
+	}
+	for _, path := range rewritten {
+		vs.rewritten[path] = true
+	}
+	return vs
+}
+
+// packageValueSignatures returns the ValueSignatures of a rewrite of pkg on its
+// own.
+func packageValueSignatures(pkg *loader.Package) *ValueSignatures {
+	vs := &ValueSignatures{funcs: make(map[string]*valueFunc)}
+	vs.Add(pkg)
+	return vs
+}
+
+// Refused returns why the function with the given full name (see
+// types.Func.FullName) can't be changed, or "" if it can. Functions that
+// neither take nor return messages by value or that are declared in packages
+// that were not added are refused.
+func (vs *ValueSignatures) Refused(fullName string) string {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	f, ok := vs.funcs[fullName]
+	switch {
+	case !ok || !f.declared:
+		return "not declared in the rewritten packages"
+	case f.refused != "":
+		return f.refused
+	case f.exported && !f.closed:
+		return "part of an exported API that code outside of the rewritten packages may use"
+	}
+	return ""
+}
+
+func (vs *ValueSignatures) changed(fn *types.Func) bool {
+	return fn != nil && vs.Refused(fn.FullName()) == ""
+}
+
+// refuse records the first reason why fn can't be changed.
+func (vs *ValueSignatures) refuse(fn *types.Func, format string, a ...any) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	f := vs.funcLocked(fn)
+	if f.refused == "" {
+		f.refused = fmt.Sprintf(format, a...)
+	}
+}
+
+func (vs *ValueSignatures) funcLocked(fn *types.Func) *valueFunc {
+	f, ok := vs.funcs[fn.FullName()]
+	if !ok {
+		f = &valueFunc{}
+		vs.funcs[fn.FullName()] = f
+	}
+	return f
+}
+
+// Add analyzes the declarations of functions with message-valued parameters or
+// results in pkg, and all calls of such functions. It is safe to call Add
+// concurrently.
+func (vs *ValueSignatures) Add(pkg *loader.Package) {
+	a := &valueAnalysis{vs: vs, pkg: pkg}
+	closed := pkg.TypePkg.Name() == "main"
+	var open string
+	if parent, ok := internalParent(pkg.TypePkg.Path()); ok && !closed && vs.importers != nil {
+		open = vs.internalOpen(parent)
+		closed = open == ""
+	}
+	for _, f := range pkg.Files {
+		for _, decl := range f.AST.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			fn, ok := pkg.TypeInfo.Defs[fd.Name].(*types.Func)
+			if !ok || !hasMessageValues(fn.Type().(*types.Signature)) {
+				continue
+			}
+			vs.mu.Lock()
+			f := vs.funcLocked(fn)
+			f.declared = true
+			f.exported = fn.Exported()
+			f.closed = closed
+			if f.exported && open != "" && f.refused == "" {
+				f.refused = open
+			}
+			vs.mu.Unlock()
+			a.decl(fd, fn)
+		}
+		a.uses(f.AST)
+	}
+}
+
+// internalParent returns the import path of the directory that contains the
+// innermost internal directory of the package path. Only packages in that
+// directory or below it can import the package. ok is false if the package is
+// not internal.
+func internalParent(path string) (parent string, ok bool) {
+	elems := strings.Split(path, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] == "internal" {
+			return strings.Join(elems[:i], "/"), true
+		}
+	}
+	return "", false
+}
+
+// internalOpen returns why the API of the internal packages below parent can
+// be used outside of the rewritten packages, or "" if it can't.
+func (vs *ValueSignatures) internalOpen(parent string) string {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if open, ok := vs.open[parent]; ok {
+		return open
+	}
+	open := ""
+	if parent == "" {
+		open = "part of the API of an internal package that any package can import"
+	} else if paths, err := vs.importers(parent); err != nil {
+		open = fmt.Sprintf("part of the API of an internal package and the packages that can import it are unknown: %v", err)
+	} else {
+		for _, path := range paths {
+			if !vs.rewritten[path] {
+				open = fmt.Sprintf("part of the API of an internal package that %s can import, which is not rewritten", path)
+				break
+			}
+		}
+	}
+	vs.open[parent] = open
+	return open
+}
+
+// isMessageValue reports whether t is a message type (not a pointer to one).
+func isMessageValue(t types.Type) bool {
+	return (protodetecttypes.Type{T: t}).IsMessage()
+}
+
+// messageValues returns the indices of the message-valued variables in t.
+func messageValues(t *types.Tuple) []int {
+	var idx []int
+	for i := 0; i < t.Len(); i++ {
+		if isMessageValue(t.At(i).Type()) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func hasMessageValues(sig *types.Signature) bool {
+	return len(messageValues(sig.Params())) > 0 || len(messageValues(sig.Results())) > 0
+}
+
+// valueAnalysis adds the functions of a package to a ValueSignatures.
+type valueAnalysis struct {
+	vs  *ValueSignatures
+	pkg *loader.Package
+}
+
+func (a *valueAnalysis) position(pos token.Pos) string {
+	p := a.pkg.Fileset.Position(pos)
+	return fmt.Sprintf("%s:%d", filepath.Base(p.Filename), p.Line)
+}
+
+// decl checks whether the declaration of fn can be changed.
+func (a *valueAnalysis) decl(fd *ast.FuncDecl, fn *types.Func) {
+	sig := fn.Type().(*types.Signature)
+	switch {
+	case fd.Body == nil:
+		a.vs.refuse(fn, "%s has no body", fn.Name())
+		return
+	case sig.TypeParams().Len() > 0 || sig.RecvTypeParams().Len() > 0:
+		a.vs.refuse(fn, "%s is generic", fn.Name())
+		return
+	case sig.Variadic() && isMessageValue(sig.Params().At(sig.Params().Len()-1).Type().(*types.Slice).Elem()):
+		a.vs.refuse(fn, "%s has a variadic message parameter", fn.Name())
+		return
+	}
+	if sig.Recv() != nil && a.interfaceMethod(fn.Name()) {
+		a.vs.refuse(fn, "%s might implement an interface", fn.Name())
+		return
+	}
+	results := messageValues(sig.Results())
+	for _, i := range results {
+		if sig.Results().At(i).Name() != "" {
+			a.vs.refuse(fn, "%s has named results", fn.Name())
+			return
+		}
+	}
+	for _, i := range messageValues(sig.Params()) {
+		if pos, ok := a.copied(fd.Body, sig.Params().At(i), len(results) > 0); ok {
+			a.vs.refuse(fn, "%s copies the parameter %s at %s", fn.Name(), sig.Params().At(i).Name(), a.position(pos))
+			return
+		}
+		if pos, ok := a.changed(fd.Body, sig.Params().At(i)); ok {
+			a.vs.refuse(fn, "%s changes the parameter %s at %s", fn.Name(), sig.Params().At(i).Name(), a.position(pos))
+			return
+		}
+	}
+	if len(results) == 0 {
+		return
+	}
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if len(n.Results) != sig.Results().Len() {
+				a.vs.refuse(fn, "%s returns the results of a call at %s", fn.Name(), a.position(n.Pos()))
+				return false
+			}
+			for _, i := range results {
+				if !a.returnable(n.Results[i]) {
+					a.vs.refuse(fn, "%s returns a message that can't be returned by pointer at %s", fn.Name(), a.position(n.Results[i].Pos()))
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// interfaceMethod reports whether an interface type of the package has a
+// method with the given name.
+func (a *valueAnalysis) interfaceMethod(name string) bool {
+	for _, tv := range a.pkg.TypeInfo.Types {
+		if !tv.IsType() {
+			continue
+		}
+		if it, ok := tv.Type.Underlying().(*types.Interface); ok {
+			for i := 0; i < it.NumMethods(); i++ {
+				if it.Method(i).Name() == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// copied reports the position of the first use of the message-valued variable
+// v in body that depends on v being a value, i.e. anything but a field
+// selection, a method call or taking its address. If returned is set, v may be
+// returned as well.
+func (a *valueAnalysis) copied(body ast.Node, v *types.Var, returned bool) (token.Pos, bool) {
+	var copyPos token.Pos
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if copyPos.IsValid() {
+			return false
+		}
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if id, ok := n.(*ast.Ident); ok && a.pkg.TypeInfo.Uses[id] == v {
+			switch parent := stack[len(stack)-1].(type) {
+			case *ast.SelectorExpr:
+				if parent.X == id {
+					return false
+				}
+			case *ast.UnaryExpr:
+				if parent.Op == token.AND {
+					return false
+				}
+			case *ast.ReturnStmt:
+				if returned && !slices.ContainsFunc(stack, isFuncLit) {
+					return false
+				}
+			}
+			copyPos = id.Pos()
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	})
+	return copyPos, copyPos.IsValid()
+}
+
+// changed reports the position of the first use of the message-valued
+// parameter v in body that might change the message: assigning to or taking
+// the address of one of its fields, using a method other than a getter, or
+// taking the address of v for anything but calling a getter. Once v is a
+// pointer, such changes would modify the caller's message.
+func (a *valueAnalysis) changed(body ast.Node, v *types.Var) (token.Pos, bool) {
+	var changePos token.Pos
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if changePos.IsValid() {
+			return false
+		}
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if id, ok := n.(*ast.Ident); ok && a.pkg.TypeInfo.Uses[id] == v {
+			if a.changes(append(stack, id)) {
+				changePos = id.Pos()
+			}
+			return false
+		}
+		stack = append(stack, n)
+		return true
+	})
+	return changePos, changePos.IsValid()
+}
+
+// changes reports whether the use of a message-valued parameter at the top of
+// stack might change the message.
+func (a *valueAnalysis) changes(stack []ast.Node) bool {
+	i := len(stack) - 1
+	x := stack[i]
+	if ue, ok := stack[i-1].(*ast.UnaryExpr); ok && ue.Op == token.AND && ue.X == x {
+		// &m may only be used to call a getter: (&m).GetF().
+		x, i = ue, i-1
+		for {
+			p, ok := stack[i-1].(*ast.ParenExpr)
+			if !ok {
+				break
+			}
+			x, i = p, i-1
+		}
+		if sel, ok := stack[i-1].(*ast.SelectorExpr); !ok || sel.X != x {
+			return true
+		}
+	}
+	sel, ok := stack[i-1].(*ast.SelectorExpr)
+	if !ok || sel.X != x {
+		// Any other use copies the parameter, see copied.
+		return false
+	}
+	selection, ok := a.pkg.TypeInfo.Selections[sel]
+	if !ok {
+		return true
+	}
+	switch parent := stack[i-2].(type) {
+	case *ast.CallExpr:
+		if parent.Fun == sel {
+			return selection.Kind() == types.MethodVal && !isGetter(sel.Sel.Name)
+		}
+	case *ast.AssignStmt:
+		if slices.Contains(parent.Lhs, ast.Expr(sel)) {
+			return true
+		}
+	case *ast.IncDecStmt:
+		return parent.X == sel
+	case *ast.UnaryExpr:
+		return parent.Op == token.AND
+	}
+	// Method values may be called to change the message later.
+	return selection.Kind() != types.FieldVal
+}
+
+// isGetter reports whether the message method name only reads the message.
+func isGetter(name string) bool {
+	for _, prefix := range []string{"Get", "Has", "Which"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return name == "String"
+}
+
+func isFuncLit(n ast.Node) bool {
+	_, ok := n.(*ast.FuncLit)
+	return ok
+}
+
+// returnable reports whether the returned message x can be returned by
+// pointer.
+func (a *valueAnalysis) returnable(x ast.Expr) bool {
+	switch x := ast.Unparen(x).(type) {
+	case *ast.CompositeLit, *ast.StarExpr:
+		return true
+	case *ast.Ident:
+		// Local variables can be returned by address. Parameters are pointers
+		// already.
+		v, ok := a.pkg.TypeInfo.Uses[x].(*types.Var)
+		return ok && v.Parent() != nil && v.Parent() != a.pkg.TypePkg.Scope()
+	}
+	return false
+}
+
+// uses checks whether the calls of functions with message-valued parameters or
+// results in f can be updated.
+func (a *valueAnalysis) uses(f *ast.File) {
+	var stack []ast.Node
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			if fn, ok := a.pkg.TypeInfo.Uses[id].(*types.Func); ok {
+				fn = fn.Origin()
+				if sig := fn.Type().(*types.Signature); hasMessageValues(sig) {
+					a.use(fn, id, stack)
+				}
+			}
+		}
+		stack = append(stack, n)
+		return true
+	})
+}
+
+// use checks whether the use id of fn, whose ancestors are stack, can be
+// updated.
+func (a *valueAnalysis) use(fn *types.Func, id *ast.Ident, stack []ast.Node) {
+	var fun ast.Node = id
+	i := len(stack) - 1
+	if sel, ok := stack[i].(*ast.SelectorExpr); ok && sel.Sel == id {
+		fun = sel
+		i--
+	}
+	call, ok := stack[i].(*ast.CallExpr)
+	if !ok || call.Fun != fun {
+		a.vs.refuse(fn, "%s is used as a function value at %s", fn.Name(), a.position(id.Pos()))
+		return
+	}
+	sig := fn.Type().(*types.Signature)
+	for _, p := range messageValues(sig.Params()) {
+		if p >= len(call.Args) {
+			break
+		}
+		if !a.pointerArg(call.Args[p]) {
+			a.vs.refuse(fn, "the argument of %s at %s can't be passed by pointer", fn.Name(), a.position(call.Args[p].Pos()))
+			return
+		}
+	}
+	if len(messageValues(sig.Results())) == 0 {
+		return
+	}
+	if len(call.Args) == 1 && sig.Params().Len() > 1 {
+		a.vs.refuse(fn, "%s is called with the results of a call at %s", fn.Name(), a.position(call.Pos()))
+		return
+	}
+	if pos, ok := a.resultCopied(call, sig, stack[:i]); ok {
+		a.vs.refuse(fn, "the result of %s is used as a value at %s", fn.Name(), a.position(pos))
+	}
+}
+
+// pointerArg reports whether the address of the argument x can be passed
+// instead of x.
+func (a *valueAnalysis) pointerArg(x ast.Expr) bool {
+	switch ast.Unparen(x).(type) {
+	case *ast.CompositeLit, *ast.StarExpr:
+		return true
+	}
+	return a.addressable(x)
+}
+
+func (a *valueAnalysis) addressable(x ast.Expr) bool {
+	switch x := ast.Unparen(x).(type) {
+	case *ast.Ident:
+		_, ok := a.pkg.TypeInfo.Uses[x].(*types.Var)
+		return ok
+	case *ast.SelectorExpr:
+		sel, ok := a.pkg.TypeInfo.Selections[x]
+		if !ok || sel.Kind() != types.FieldVal {
+			return false
+		}
+		if sel.Indirect() {
+			return true
+		}
+		if _, ok := a.pkg.TypeInfo.TypeOf(x.X).Underlying().(*types.Pointer); ok {
+			return true
+		}
+		return a.addressable(x.X)
+	case *ast.IndexExpr:
+		switch t := a.pkg.TypeInfo.TypeOf(x.X).Underlying().(type) {
+		case *types.Slice:
+			return true
+		case *types.Pointer:
+			_, ok := t.Elem().Underlying().(*types.Array)
+			return ok
+		case *types.Array:
+			return a.addressable(x.X)
+		}
+	}
+	return false
+}
+
+// resultCopied reports the position of a use of the message-valued results of
+// call (of a function with signature sig), whose ancestors are stack, that
+// depends on the results being values. The results may be discarded, have
+// their fields or methods selected or be assigned to new variables that are
+// only used like that.
+func (a *valueAnalysis) resultCopied(call *ast.CallExpr, sig *types.Signature, stack []ast.Node) (token.Pos, bool) {
+	switch parent := stack[len(stack)-1].(type) {
+	case *ast.ExprStmt:
+		return token.NoPos, false
+	case *ast.SelectorExpr:
+		if parent.X == call {
+			return token.NoPos, false
+		}
+	case *ast.AssignStmt:
+		if len(parent.Lhs) != sig.Results().Len() || len(parent.Rhs) != 1 {
+			break
+		}
+		block, _ := stack[len(stack)-2].(*ast.BlockStmt)
+		for _, i := range messageValues(sig.Results()) {
+			id, ok := parent.Lhs[i].(*ast.Ident)
+			if ok && id.Name == "_" {
+				continue
+			}
+			v, ok := a.pkg.TypeInfo.Defs[id].(*types.Var)
+			if !ok || parent.Tok != token.DEFINE || block == nil {
+				return parent.Lhs[i].Pos(), true
+			}
+			if pos, ok := a.copied(block, v, false); ok {
+				return pos, true
+			}
+		}
+		return token.NoPos, false
+	}
+	return call.Pos(), true
+}
+
+// rewriteValueSignatures changes the message-valued parameters and results of
+// the functions declared in the current file that are changed according to
+// c.valueSignatures to pointers and updates all calls of changed functions:
+//
+//	func f(m pb.M) pb.M {         func f(m *pb.M) *pb.M {
+//		_ = &m                =>      _ = m
+//		return pb.M{}                 return &pb.M{}
+//	}                             }
+//
+//	r := f(m)                     r := f(&m)
+//	_ = &r                    =>  _ = r
+//
+// It returns the parameter and result lists of all function declarations,
+// which usePointersPre must not change otherwise. Declarations of functions
+// that can't be changed are marked with the reason.
+func (c *cursor) rewriteValueSignatures(file dst.Node) map[*dst.FieldList]bool {
+	declLists := make(map[*dst.FieldList]bool)
+	dstutil.Apply(file, func(cur *dstutil.Cursor) bool {
+		switch n := cur.Node().(type) {
+		case *dst.FuncDecl:
+			declLists[n.Type.Params] = true
+			if n.Type.Results != nil {
+				declLists[n.Type.Results] = true
+			}
+			if n.Recv != nil {
+				// Methods can't be declared on message types.
+				declLists[n.Recv] = true
+			}
+			fn, ok := c.objectOf(n.Name).(*types.Func)
+			if !ok || !hasMessageValues(fn.Type().(*types.Signature)) {
+				return true
+			}
+			if reason := c.valueSignatures.Refused(fn.FullName()); reason != "" {
+				n.Decs.Start.Append("// DO NOT SUBMIT: can't pass messages by pointer: " + reason + " (go/goprotoapi-findings#message-value)")
+				n.Decs.Before = dst.NewLine
+				c.numUnsafeRewritesByReason[IncompleteRewrite]++
+				return true
+			}
+			c.changeSignature(n, fn)
+
+		case *dst.CallExpr:
+			fn := c.calledFunc(n)
+			if fn == nil || !hasMessageValues(fn.Type().(*types.Signature)) || !c.valueSignatures.changed(fn) {
+				return true
+			}
+			c.updateCall(cur, n, fn)
+		}
+		return true
+	}, nil)
+	return declLists
+}
+
+// changeSignature changes the message-valued parameters and results of the
+// declaration of fn to pointers.
+func (c *cursor) changeSignature(decl *dst.FuncDecl, fn *types.Func) {
+	sig := fn.Type().(*types.Signature)
+	params := make(map[types.Object]bool)
+	for _, i := range messageValues(sig.Params()) {
+		params[sig.Params().At(i)] = true
+	}
+	pointerFields := func(fl *dst.FieldList) {
+		if fl == nil {
+			return
+		}
+		for _, f := range fl.List {
+			t := c.typeOf(f.Type)
+			if !isMessageValue(t) {
+				continue
+			}
+			star := &dst.StarExpr{X: f.Type}
+			c.setType(star, types.NewPointer(t))
+			f.Type = star
+			for _, name := range f.Names {
+				c.setType(name, types.NewPointer(t))
+			}
+		}
+	}
+	pointerFields(decl.Type.Params)
+	pointerFields(decl.Type.Results)
+
+	results := messageValues(sig.Results())
+	dstutil.Apply(decl.Body, func(cur *dstutil.Cursor) bool {
+		switch n := cur.Node().(type) {
+		case *dst.FuncLit:
+			// Returns in function literals return from the literal.
+			c.pointerParamUses(n, params)
+			return false
+		case *dst.UnaryExpr:
+			if id, ok := n.X.(*dst.Ident); ok && n.Op == token.AND && params[c.objectOf(id)] {
+				c.setType(id, c.typeOf(n))
+				cur.Replace(id)
+				return false
+			}
+		case *dst.Ident:
+			if params[c.objectOf(n)] {
+				c.setType(n, types.NewPointer(c.objectOf(n).Type()))
+			}
+		case *dst.ReturnStmt:
+			for _, i := range results {
+				n.Results[i] = c.pointerTo(n.Results[i], params)
+			}
+		}
+		return true
+	}, nil)
+	c.numUnsafeRewritesByReason[MaybeSemanticChange]++
+}
+
+// pointerParamUses updates the uses of the changed parameters params in the
+// function literal fl.
+func (c *cursor) pointerParamUses(fl *dst.FuncLit, params map[types.Object]bool) {
+	dstutil.Apply(fl.Body, func(cur *dstutil.Cursor) bool {
+		switch n := cur.Node().(type) {
+		case *dst.UnaryExpr:
+			if id, ok := n.X.(*dst.Ident); ok && n.Op == token.AND && params[c.objectOf(id)] {
+				c.setType(id, c.typeOf(n))
+				cur.Replace(id)
+				return false
+			}
+		case *dst.Ident:
+			if params[c.objectOf(n)] {
+				c.setType(n, types.NewPointer(c.objectOf(n).Type()))
+			}
+		}
+		return true
+	}, nil)
+}
+
+// pointerTo returns a pointer to the message-valued expression x, which is a
+// composite literal, a dereferenced pointer or an addressable expression. Uses
+// of the changed parameters params are pointers already.
+func (c *cursor) pointerTo(x dst.Expr, params map[types.Object]bool) dst.Expr {
+	switch e := x.(type) {
+	case *dst.StarExpr:
+		return e.X
+	case *dst.Ident:
+		if params[c.objectOf(e)] || c.funcLitResult(e) {
+			return e
+		}
+	}
+	return addr(c, x)
+}
+
+// funcLitResult reports whether the variable id is defined with the result of
+// a function literal call, which usePointersPre changes to a pointer:
+//
+//	m := func() pb.M { ... }()
+func (c *cursor) funcLitResult(id *dst.Ident) bool {
+	obj := c.objectOf(id)
+	found := false
+	dst.Inspect(c.curFileDST, func(n dst.Node) bool {
+		as, ok := n.(*dst.AssignStmt)
+		if !ok || as.Tok != token.DEFINE || len(as.Rhs) != 1 {
+			return !found
+		}
+		call, ok := as.Rhs[0].(*dst.CallExpr)
+		if !ok {
+			return true
+		}
+		if _, ok := dstutil.Unparen(call.Fun).(*dst.FuncLit); !ok {
+			return true
+		}
+		for _, lhs := range as.Lhs {
+			if lhsID, ok := lhs.(*dst.Ident); ok && c.typesInfo.defs[lhsID] == obj {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// updateCall updates the call of the changed function fn: message-valued
+// arguments are passed by pointer, and variables that are defined with a
+// message-valued result become pointers.
+func (c *cursor) updateCall(cur *dstutil.Cursor, call *dst.CallExpr, fn *types.Func) {
+	sig := fn.Type().(*types.Signature)
+	for _, i := range messageValues(sig.Params()) {
+		if i < len(call.Args) {
+			call.Args[i] = c.pointerTo(call.Args[i], nil)
+		}
+	}
+	results := messageValues(sig.Results())
+	if len(results) == 0 {
+		return
+	}
+	if sig.Results().Len() == 1 {
+		c.setType(call, types.NewPointer(sig.Results().At(0).Type()))
+	} else {
+		vars := make([]*types.Var, sig.Results().Len())
+		for i := range vars {
+			r := sig.Results().At(i)
+			vars[i] = r
+			if slices.Contains(results, i) {
+				vars[i] = types.NewVar(r.Pos(), r.Pkg(), r.Name(), types.NewPointer(r.Type()))
+			}
+		}
+		c.setType(call, types.NewTuple(vars...))
+	}
+	as, ok := cur.Parent().(*dst.AssignStmt)
+	if !ok || as.Tok != token.DEFINE || len(as.Lhs) != sig.Results().Len() {
+		return
+	}
+	block := c.enclosingBlock(as)
+	if block == nil {
+		return
+	}
+	rest := block.List[slices.Index(block.List, dst.Stmt(as))+1:]
+	for _, i := range results {
+		id, ok := as.Lhs[i].(*dst.Ident)
+		if !ok || id.Name == "_" {
+			continue
+		}
+		// The variable is only used as a pointer (see
+		// valueAnalysis.resultCopied), so replaceWithPtr only drops the
+		// address operators.
+		replaceWithPtr(c, id, rest)
+		c.setVarType(block, id, types.NewPointer(sig.Results().At(i).Type()))
+	}
+}
+
+// setVarType sets the type of the variable defined by id, and of all of its
+// uses in scope, to t.
+func (c *cursor) setVarType(scope dst.Node, id *dst.Ident, t types.Type) {
+	obj := c.objectOf(id)
+	c.setType(id, t)
+	dstutil.Apply(scope, func(cur *dstutil.Cursor) bool {
+		if use, ok := cur.Node().(*dst.Ident); ok && c.objectOf(use) == obj {
+			c.setType(use, t)
+		}
+		return true
+	}, nil)
+}
+
+// enclosingBlock returns the block of the current file that contains stmt as a
+// direct child, or nil.
+func (c *cursor) enclosingBlock(stmt dst.Stmt) *dst.BlockStmt {
+	var block *dst.BlockStmt
+	dst.Inspect(c.curFileDST, func(n dst.Node) bool {
+		if b, ok := n.(*dst.BlockStmt); ok && slices.Contains(b.List, stmt) {
+			block = b
+		}
+		return block == nil
+	})
+	return block
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/open2opaque/internal/o2o/fakeloader"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/o2o/syncset"
+)
+
+func TestValueSignatures(t *testing.T) {
+	tests := []test{{
+		desc: "parameter and result",
+		extra: `
+func value(m pb2.M2) pb2.M2 {
+	_ = m.GetS()
+	return pb2.M2{}
+}
+`,
+		in: `
+r := value(*m2)
+_ = r.GetS()
+_ = value(pb2.M2{})
+`,
+		want: map[Level]string{
+			Yellow: `
+r := value(*m2)
+_ = r.GetS()
+_ = value(pb2.M2{})
+`,
+			Red: `
+r := value(m2)
+_ = r.GetS()
+_ = value(&pb2.M2{})
+`,
+		},
+	}, {
+		desc: "function value",
+		extra: `
+var valueFunc = value
+
+func value(m pb2.M2) {
+	_ = m.GetS()
+}
+`,
+		in: `
+value(*m2)
+`,
+		want: map[Level]string{
+			Red: `
+value(*m2)
+`,
+		},
+	}, {
+		desc: "copied parameter",
+		extra: `
+func value(m pb2.M2) {
+	c := m
+	_ = c
+}
+`,
+		in: `
+value(*m2)
+`,
+		want: map[Level]string{
+			Red: `
+value(*m2)
+`,
+		},
+	}, {
+		desc: "changed parameter",
+		extra: `
+func assign(m pb2.M2) {
+	m.S = nil
+}
+
+func set(m pb2.M2) {
+	m.SetS("x")
+}
+
+func clear(m pb2.M2) {
+	reset := m.Reset
+	reset()
+}
+
+func fill(m *pb2.M2) {}
+
+func filled(m pb2.M2) {
+	fill(&m)
+}
+`,
+		in: `
+assign(*m2)
+set(*m2)
+clear(*m2)
+filled(*m2)
+`,
+		want: map[Level]string{
+			Red: `
+assign(*m2)
+set(*m2)
+clear(*m2)
+filled(*m2)
+`,
+		},
+	}}
+	runTableTests(t, tests)
+}
+
+func TestValueSignatureDecls(t *testing.T) {
+	const extra = `
+func value(m pb2.M2) pb2.M2 {
+	_ = m.GetS()
+	_ = (&m).GetS()
+	return pb2.M2{}
+}
+
+func fill(m *pb2.M2) {}
+
+func filled(m pb2.M2) {
+	fill(&m)
+}
+
+func copied(m pb2.M2) {
+	c := m
+	_ = c
+}
+
+func Exported(m pb2.M2) {
+	_ = m.GetS()
+}
+`
+	const in = `
+_ = value(*m2)
+copied(*m2)
+filled(*m2)
+Exported(*m2)
+`
+	ruleName := "google.golang.org/open2opaque/internal/fix/testdata/fake"
+	srcfile := ruleName + "/code.go"
+	flBase.ImportPathToFiles[ruleName] = []string{srcfile}
+	flBase.PathToContent[srcfile] = NewSrc(in, extra)
+	l := fakeloader.NewFakeLoader(
+		flBase.ImportPathToFiles,
+		flBase.PathToContent,
+		nil,
+		flBase.ExportFor)
+
+	for _, tc := range []struct {
+		desc            string
+		valueSignatures func(*loader.Package) *ValueSignatures
+		want            []string
+	}{{
+		desc: "package",
+		want: []string{
+			"func value(m *pb2.M2) *pb2.M2 {\n\t_ = m.GetS()\n\t_ = (m).GetS()\n\treturn &pb2.M2{}\n}",
+			"// DO NOT SUBMIT: can't pass messages by pointer: copied copies the parameter m at code.go:",
+			"func copied(m pb2.M2) {",
+			"// DO NOT SUBMIT: can't pass messages by pointer: filled changes the parameter m at code.go:",
+			"func filled(m pb2.M2) {",
+			"// DO NOT SUBMIT: can't pass messages by pointer: part of an exported API that code outside of the rewritten packages may use",
+			"func Exported(m pb2.M2) {",
+			"_ = value(m2)\n\tcopied(*m2)\n\tfilled(*m2)\n\tExported(*m2)",
+		},
+	}, {
+		desc: "loaded packages",
+		valueSignatures: func(pkg *loader.Package) *ValueSignatures {
+			vs := NewValueSignatures([]string{ruleName}, func(parent string) ([]string, error) {
+				if parent != "google.golang.org/open2opaque" {
+					t.Errorf("importers(%q), want the parent of the internal directory", parent)
+				}
+				return []string{ruleName}, nil
+			})
+			vs.Add(pkg)
+			return vs
+		},
+		want: []string{
+			"func Exported(m *pb2.M2) {",
+			"Exported(m2)",
+		},
+	}, {
+		desc: "loaded packages, importers not rewritten",
+		valueSignatures: func(pkg *loader.Package) *ValueSignatures {
+			vs := NewValueSignatures([]string{ruleName}, func(string) ([]string, error) {
+				return []string{"google.golang.org/open2opaque/cmd", ruleName}, nil
+			})
+			vs.Add(pkg)
+			return vs
+		},
+		want: []string{
+			"// DO NOT SUBMIT: can't pass messages by pointer: part of the API of an internal package that google.golang.org/open2opaque/cmd can import, which is not rewritten",
+			"func Exported(m pb2.M2) {",
+			"func value(m *pb2.M2) *pb2.M2 {",
+			"Exported(*m2)",
+		},
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			pkg, err := loader.LoadOne(context.Background(), l, &loader.Target{ID: ruleName})
+			if err != nil {
+				t.Fatalf("Can't load %q: %v", ruleName, err)
+			}
+			cPkg := ConfiguredPackage{
+				Loader:         l,
+				Pkg:            pkg,
+				Levels:         []Level{Red},
+				ProcessedFiles: syncset.New(),
+				UseBuilders:    BuildersTestsOnly,
+			}
+			if tc.valueSignatures != nil {
+				cPkg.ValueSignatures = tc.valueSignatures(pkg)
+			}
+			fixed, err := cPkg.Fix()
+			if err != nil {
+				t.Fatalf("Can't fix %q: %v", ruleName, err)
+			}
+			got := fixed[Red][0].Code
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Fix() = \n%s\nwant it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
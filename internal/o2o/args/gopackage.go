@@ -0,0 +1,141 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package args
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// goImportPath returns the import path of a go_package option value, which
+// may be followed by ";" and an explicit package name.
+func goImportPath(goPackage string) string {
+	path, _, _ := strings.Cut(goPackage, ";")
+	return path
+}
+
+// ParseGoOpt parses protoc-gen-go options like
+// "Mfoo/bar.proto=example.com/foo/foopb" and adds the mapping from proto import
+// path to Go import path to mappings. Options other than M are ignored, so
+// that the same options as for protoc-gen-go can be passed.
+func ParseGoOpt(opt string, mappings map[string]string) error {
+	for _, o := range strings.Split(opt, ",") {
+		if !strings.HasPrefix(o, "M") {
+			continue
+		}
+		name, path, ok := strings.Cut(o[1:], "=")
+		if !ok || name == "" || path == "" {
+			return fmt.Errorf("invalid M option %q, want Mpath/file.proto=go/import/path", o)
+		}
+		mappings[name] = goImportPath(path)
+	}
+	return nil
+}
+
+// GoPackagesFromDescriptorSet reads a FileDescriptorSet (as written by protoc
+// --descriptor_set_out) and returns the Go import path of each proto file in
+// it, keyed by the proto import path. Files without a go_package option are
+// omitted.
+func GoPackagesFromDescriptorSet(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fds descpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		return nil, fmt.Errorf("parsing descriptor set %s: %v", path, err)
+	}
+	res := make(map[string]string)
+	for _, fd := range fds.GetFile() {
+		if gp := goImportPath(fd.GetOptions().GetGoPackage()); gp != "" {
+			res[fd.GetName()] = gp
+		}
+	}
+	return res, nil
+}
+
+// ResolveGoPackages returns the .proto files that generate Go code into a
+// package matching one of the patterns. A pattern is a Go import path, where
+// "..." matches any string, like in go list. The Go import path of a file
+// comes from mappings (M options or a descriptor set, keyed by proto import
+// path) or else from its go_package option. Files that only appear in
+// mappings are looked up in the include roots.
+func (ix *Index) ResolveGoPackages(patterns []string, mappings map[string]string) ([]Target, error) {
+	if err := ix.load(); err != nil {
+		return nil, err
+	}
+	matchers := make([]func(string) bool, len(patterns))
+	for i, p := range patterns {
+		matchers[i] = goPackageMatcher(p)
+	}
+	matchAny := func(importPath string) bool {
+		for _, m := range matchers {
+			if m(importPath) {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, f := range ix.files {
+		importPath := f.goPackage
+		if m, ok := mappings[f.name]; ok {
+			importPath = m
+		}
+		if importPath != "" && matchAny(importPath) {
+			add(f.path)
+		}
+	}
+	indexed := make(map[string]bool)
+	for _, f := range ix.files {
+		indexed[f.name] = true
+	}
+	for name, importPath := range mappings {
+		if indexed[name] || !matchAny(importPath) {
+			continue
+		}
+		path := ix.findFile(name)
+		if !fileExists(path) {
+			return nil, fmt.Errorf("%s (Go package %s) not found in the proto path (%s)", name, importPath, strings.Join(ix.roots, ", "))
+		}
+		add(path)
+	}
+	if len(files) == 0 {
+		return nil, ix.notFound(fmt.Errorf("no .proto files in the proto path (%s) generate Go packages matching %s", strings.Join(ix.roots, ", "), strings.Join(patterns, ", ")))
+	}
+	sort.Strings(files)
+	targets := make([]Target, len(files))
+	for i, f := range files {
+		targets[i] = Target{Filename: f}
+	}
+	return targets, nil
+}
+
+// goPackageMatcher returns a function reporting whether a Go import path
+// matches pattern, using the go list pattern syntax: "..." matches any string,
+// and a trailing "/..." also matches the path without it.
+func goPackageMatcher(pattern string) func(string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	compiled := regexp.MustCompile(`^` + re + `$`)
+	return compiled.MatchString
+}
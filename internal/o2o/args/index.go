@@ -0,0 +1,262 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package args
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/open2opaque/internal/protoparse"
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/yaml.v3"
+)
+
+// Target is a proto file, or a message in a proto file, that an argument
+// refers to.
+type Target struct {
+	// Filename is the path of the .proto file, relative to the current
+	// directory (or absolute if the include root is absolute).
+	Filename string
+	// Symbol is the fully-qualified message name, or empty if the argument
+	// refers to the whole file.
+	Symbol string
+}
+
+// Index maps proto package names and fully-qualified message names to the
+// .proto files defining them.
+type Index struct {
+	roots     []string
+	loaded    bool
+	byPackage map[string][]string // package name to file names
+	byMessage map[string]string   // fully-qualified message name to file name
+	files     []indexedFile
+	skipped   []string // files that could not be parsed
+}
+
+type indexedFile struct {
+	path      string // path of the file on disk
+	name      string // import path of the file, relative to its root
+	goPackage string // Go import path from the go_package option
+}
+
+// NewIndex returns an index of the .proto files below the include roots. The
+// files are parsed when the first package or message name is resolved, so
+// that resolving only file names is cheap.
+func NewIndex(roots []string) *Index {
+	return &Index{roots: roots}
+}
+
+func (ix *Index) load() error {
+	if ix.loaded {
+		return nil
+	}
+	ix.loaded = true
+	ix.byPackage = make(map[string][]string)
+	ix.byMessage = make(map[string]string)
+	seen := make(map[string]bool)
+	for _, root := range ix.roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(path, ".proto") {
+				return nil
+			}
+			// Files can be reachable through several (nested) roots.
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+			if err := ix.add(root, path); err != nil {
+				// Files that don't parse must not break the
+				// resolution of names defined in other files.
+				logf("skipping %s: %v", path, err)
+				ix.skipped = append(ix.skipped, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("indexing proto files in %s: %v", root, err)
+		}
+	}
+	for _, files := range ix.byPackage {
+		sort.Strings(files)
+	}
+	return nil
+}
+
+func (ix *Index) add(root, path string) error {
+	parser := protoparse.NewParserWithAccessor(func(name string) (io.ReadCloser, error) {
+		return os.Open(name)
+	})
+	fopt, err := parser.ParseFile(path, true)
+	if err != nil {
+		return err
+	}
+	ix.byPackage[fopt.Package] = append(ix.byPackage[fopt.Package], path)
+	name, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	ix.files = append(ix.files, indexedFile{
+		path:      path,
+		name:      filepath.ToSlash(name),
+		goPackage: goImportPath(fopt.Desc.GetOptions().GetGoPackage()),
+	})
+	prefix := fopt.Package
+	if prefix != "" {
+		prefix += "."
+	}
+	var addMsgs func(prefix string, msgs []*descpb.DescriptorProto)
+	addMsgs = func(prefix string, msgs []*descpb.DescriptorProto) {
+		for _, m := range msgs {
+			name := prefix + m.GetName()
+			ix.byMessage[name] = path
+			addMsgs(name+".", m.GetNestedType())
+		}
+	}
+	addMsgs(prefix, fopt.Desc.GetMessageType())
+	return nil
+}
+
+// Resolve translates arg, which is a .proto file name, a proto package name or
+// a fully-qualified message name, into targets. Kind is one of
+// "proto_filename", "proto_package", "proto_message" or "autodetect". It
+// returns the detected kind.
+func (ix *Index) Resolve(arg, kind string) (detectedKind string, _ []Target, _ error) {
+	if kind == "proto_filename" ||
+		(kind == "autodetect" && strings.HasSuffix(arg, ".proto")) {
+		return "proto_filename", []Target{{Filename: ix.findFile(arg)}}, nil
+	}
+	if err := ix.load(); err != nil {
+		return "", nil, err
+	}
+	if kind == "autodetect" || kind == "proto_package" {
+		if files, ok := ix.byPackage[arg]; ok {
+			var targets []Target
+			for _, f := range files {
+				targets = append(targets, Target{Filename: f})
+			}
+			return "proto_package", targets, nil
+		}
+	}
+	if kind == "autodetect" || kind == "proto_message" {
+		if f, ok := ix.byMessage[arg]; ok {
+			return "proto_message", []Target{{Filename: f, Symbol: arg}}, nil
+		}
+	}
+	if kind != "autodetect" && kind != "proto_package" && kind != "proto_message" {
+		return "", nil, fmt.Errorf("invalid kind %q", kind)
+	}
+	return "", nil, ix.notFound(fmt.Errorf("%q is neither a .proto file nor a proto package or message defined in the proto path (%s)", arg, strings.Join(ix.roots, ", ")))
+}
+
+// notFound adds the files that could not be parsed, which might define the
+// missing name, to err.
+func (ix *Index) notFound(err error) error {
+	if len(ix.skipped) == 0 {
+		return err
+	}
+	return fmt.Errorf("%v; %d files could not be parsed, including %s", err, len(ix.skipped), ix.skipped[0])
+}
+
+// findFile returns name if it exists, or name relative to the first include
+// root that contains it. This allows specifying files by their import path.
+func (ix *Index) findFile(name string) string {
+	if _, err := os.Stat(name); err == nil || filepath.IsAbs(name) {
+		return name
+	}
+	for _, root := range ix.roots {
+		if path := filepath.Join(root, name); fileExists(path) {
+			return path
+		}
+	}
+	return name
+}
+
+// ImportPath returns the path under which the .proto file at path is imported:
+// its path relative to the first include root that contains it.
+func (ix *Index) ImportPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range ix.roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(absRoot, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not in the proto path (%s)", path, strings.Join(ix.roots, ", "))
+}
+
+var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[args] "+format+"\n", a...) }
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// RootsFromBufYAML returns the include roots of the buf module or workspace
+// configured in the buf.yaml file at path: the module paths of a v2
+// configuration, the build roots of a v1beta1 configuration, or the
+// directories listed in a buf.work.yaml next to a v1 configuration. If none is
+// configured, the directory containing the file is the only root.
+func RootsFromBufYAML(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Version string
+		Modules []struct {
+			Path string
+		}
+		Build struct {
+			Roots []string
+		}
+		Directories []string // buf.work.yaml
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	var roots []string
+	for _, m := range cfg.Modules {
+		roots = append(roots, m.Path)
+	}
+	roots = append(roots, cfg.Build.Roots...)
+	roots = append(roots, cfg.Directories...)
+	if len(roots) == 0 && filepath.Base(path) == "buf.yaml" {
+		if work := filepath.Join(dir, "buf.work.yaml"); fileExists(work) {
+			return RootsFromBufYAML(work)
+		}
+	}
+	if len(roots) == 0 {
+		return []string{dir}, nil
+	}
+	for i, r := range roots {
+		roots[i] = filepath.Join(dir, r)
+	}
+	return roots, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package args
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"buf.yaml": "version: v2\nmodules:\n  - path: proto\n",
+		"proto/foo/a.proto": `syntax = "proto3";
+package foo.bar;
+message A {
+  message Nested {}
+}
+`,
+		"proto/foo/b.proto": `edition = "2023";
+package foo.bar;
+message B {}
+`,
+		"proto/other/c.proto": `syntax = "proto2";
+package other;
+message C {}
+`,
+		// Files that don't parse are skipped.
+		"proto/broken/d.proto": `syntax = "proto3";
+package broken;
+message D {
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roots, err := RootsFromBufYAML(filepath.Join(dir, "buf.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "proto")
+	if want := []string{root}; !cmp.Equal(roots, want) {
+		t.Fatalf("RootsFromBufYAML() = %v, want %v", roots, want)
+	}
+	ix := NewIndex(roots)
+
+	for _, tc := range []struct {
+		arg      string
+		kind     string
+		wantKind string
+		want     []Target
+	}{
+		{
+			arg:      "foo/a.proto",
+			kind:     "autodetect",
+			wantKind: "proto_filename",
+			want:     []Target{{Filename: filepath.Join(root, "foo/a.proto")}},
+		},
+		{
+			arg:      "foo.bar",
+			kind:     "autodetect",
+			wantKind: "proto_package",
+			want: []Target{
+				{Filename: filepath.Join(root, "foo/a.proto")},
+				{Filename: filepath.Join(root, "foo/b.proto")},
+			},
+		},
+		{
+			arg:      "foo.bar.A.Nested",
+			kind:     "autodetect",
+			wantKind: "proto_message",
+			want:     []Target{{Filename: filepath.Join(root, "foo/a.proto"), Symbol: "foo.bar.A.Nested"}},
+		},
+		{
+			arg:      "other.C",
+			kind:     "proto_message",
+			wantKind: "proto_message",
+			want:     []Target{{Filename: filepath.Join(root, "other/c.proto"), Symbol: "other.C"}},
+		},
+	} {
+		kind, got, err := ix.Resolve(tc.arg, tc.kind)
+		if err != nil {
+			t.Errorf("Resolve(%q, %q): %v", tc.arg, tc.kind, err)
+			continue
+		}
+		if kind != tc.wantKind {
+			t.Errorf("Resolve(%q, %q) detected kind %q, want %q", tc.arg, tc.kind, kind, tc.wantKind)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Resolve(%q, %q) returned unexpected targets (-want +got):\n%s", tc.arg, tc.kind, diff)
+		}
+	}
+
+	for _, tc := range []struct{ arg, kind string }{
+		{"foo.baz", "autodetect"},
+		{"other.C", "proto_package"},
+		{"broken.D", "autodetect"},
+	} {
+		if _, _, err := ix.Resolve(tc.arg, tc.kind); err == nil {
+			t.Errorf("Resolve(%q, %q) succeeded, want error", tc.arg, tc.kind)
+		}
+	}
+}
+
+func TestResolveGoPackages(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"foo/a.proto": `syntax = "proto3";
+package foo;
+option go_package = "example.com/foo/foopb;foopb";
+`,
+		"foo/v2/b.proto": `syntax = "proto3";
+package foo.v2;
+option go_package = "example.com/foo/foopb/v2";
+`,
+		"bar/c.proto": `syntax = "proto3";
+package bar;
+`,
+		"example.com/foobar.proto": `syntax = "proto3";
+package foobar;
+option go_package = "example.com/foobarpb";
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := NewIndex([]string{dir})
+	target := func(name string) Target { return Target{Filename: filepath.Join(dir, name)} }
+
+	for _, tc := range []struct {
+		patterns []string
+		mappings map[string]string
+		want     []Target
+	}{
+		{
+			patterns: []string{"example.com/foo/foopb"},
+			want:     []Target{target("foo/a.proto")},
+		},
+		{
+			patterns: []string{"example.com/foo/foopb/..."},
+			want:     []Target{target("foo/a.proto"), target("foo/v2/b.proto")},
+		},
+		{
+			patterns: []string{"example.com/foo..."},
+			want:     []Target{target("example.com/foobar.proto"), target("foo/a.proto"), target("foo/v2/b.proto")},
+		},
+		{
+			patterns: []string{"example.com/bar/barpb"},
+			mappings: map[string]string{"bar/c.proto": "example.com/bar/barpb"},
+			want:     []Target{target("bar/c.proto")},
+		},
+		{
+			// M options override go_package.
+			patterns: []string{"example.com/foo/foopb/..."},
+			mappings: map[string]string{"foo/v2/b.proto": "example.com/other/v2"},
+			want:     []Target{target("foo/a.proto")},
+		},
+	} {
+		got, err := ix.ResolveGoPackages(tc.patterns, tc.mappings)
+		if err != nil {
+			t.Errorf("ResolveGoPackages(%q, %v): %v", tc.patterns, tc.mappings, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("ResolveGoPackages(%q, %v) returned unexpected targets (-want +got):\n%s", tc.patterns, tc.mappings, diff)
+		}
+	}
+
+	if _, err := ix.ResolveGoPackages([]string{"example.com/none/..."}, nil); err == nil {
+		t.Errorf("ResolveGoPackages(example.com/none/...) succeeded, want error")
+	}
+}
+
+func TestParseGoOpt(t *testing.T) {
+	mappings := make(map[string]string)
+	if err := ParseGoOpt("paths=source_relative,Mfoo/a.proto=example.com/foopb;foopb", mappings); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"foo/a.proto": "example.com/foopb"}; !cmp.Equal(mappings, want) {
+		t.Errorf("ParseGoOpt() mappings = %v, want %v", mappings, want)
+	}
+	if err := ParseGoOpt("Mfoo/a.proto", mappings); err == nil {
+		t.Errorf("ParseGoOpt(Mfoo/a.proto) succeeded, want error")
+	}
+}
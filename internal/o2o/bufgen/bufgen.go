@@ -0,0 +1,207 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bufgen reads and edits the protoc-gen-go plugin configuration in
+// buf.gen.yaml files.
+//
+// buf passes the opt values of a plugin to it as protoc would pass --go_opt
+// values. The API level of the generated code is configured with the
+// protoc-gen-go options default_api_level (for all files) and
+// apilevelM<file>=<level> (for individual files, by import path). Only the
+// opt values of protoc-gen-go plugins are read and edited: buf's managed mode
+// has no setting for the API level, so the managed section is left alone, and
+// a buf module is overridden by an apilevelM option for each of its files.
+package bufgen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a parsed buf.gen.yaml file.
+type Config struct {
+	path string
+	doc  yaml.Node
+}
+
+// Load parses the buf.gen.yaml file at path. Both v1 (plugin or name "go")
+// and v2 (local "protoc-gen-go" or remote buf.build/protocolbuffers/go)
+// configurations of protoc-gen-go are recognized.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{path: path}
+	if err := yaml.Unmarshal(b, &c.doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if _, err := c.goPlugins(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GoOpts returns the options of the protoc-gen-go plugins, in the form
+// accepted by protoc's --go_opt.
+func (c *Config) GoOpts() []string {
+	plugins, _ := c.goPlugins()
+	var opts []string
+	for _, p := range plugins {
+		l, _ := stringList(mapValue(p, "opt"))
+		for _, o := range l {
+			if o != "" {
+				opts = append(opts, o)
+			}
+		}
+	}
+	return opts
+}
+
+// SetDefaultAPILevel sets the default_api_level option of the protoc-gen-go
+// plugins to level, changing the API level of all files that do not set it
+// explicitly.
+func (c *Config) SetDefaultAPILevel(level gofeaturespb.GoFeatures_APILevel) error {
+	return c.setParam("default_api_level", level.String())
+}
+
+// SetFileAPILevel sets the apilevelM option for the proto file imported as
+// name to level, overriding the default API level for this file.
+func (c *Config) SetFileAPILevel(name string, level gofeaturespb.GoFeatures_APILevel) error {
+	return c.setParam("apilevelM"+filepath.ToSlash(name), level.String())
+}
+
+// setParam sets the protoc-gen-go parameter key to value in all protoc-gen-go
+// plugins, replacing any previous value.
+func (c *Config) setParam(key, value string) error {
+	plugins, err := c.goPlugins()
+	if err != nil {
+		return err
+	}
+	if len(plugins) == 0 {
+		return fmt.Errorf("%s does not configure the protoc-gen-go plugin", c.path)
+	}
+	for _, p := range plugins {
+		opt := mapValue(p, "opt")
+		l, err := stringList(opt)
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.path, err)
+		}
+		// An opt string can contain several comma-separated parameters.
+		var params []string
+		for _, o := range l {
+			for _, param := range strings.Split(o, ",") {
+				if param != "" && !strings.HasPrefix(param, key+"=") {
+					params = append(params, param)
+				}
+			}
+		}
+		params = append(params, key+"="+value)
+
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if opt != nil && opt.Kind == yaml.ScalarNode && len(params) == 1 {
+			n = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+			n.Value = params[0]
+		} else {
+			for _, param := range params {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: param})
+			}
+		}
+		if opt == nil {
+			p.Content = append(p.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "opt"}, n)
+			continue
+		}
+		n.HeadComment, n.LineComment, n.FootComment = opt.HeadComment, opt.LineComment, opt.FootComment
+		if opt.Kind == yaml.ScalarNode && n.Kind == yaml.SequenceNode {
+			// Keep the comment of an opt string with its first parameter.
+			n.Content[0].LineComment, n.LineComment = n.LineComment, ""
+		}
+		*opt = *n
+	}
+	return nil
+}
+
+// Marshal returns the content of the (modified) buf.gen.yaml file.
+func (c *Config) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&c.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// goPlugins returns the mapping nodes of the protoc-gen-go plugins.
+func (c *Config) goPlugins() ([]*yaml.Node, error) {
+	if len(c.doc.Content) == 0 {
+		return nil, nil
+	}
+	plugins := mapValue(c.doc.Content[0], "plugins")
+	if plugins == nil {
+		return nil, nil
+	}
+	if plugins.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s: plugins is not a list", c.path)
+	}
+	var res []*yaml.Node
+	for _, p := range plugins.Content {
+		if p.Kind != yaml.MappingNode {
+			continue
+		}
+		// local can be a program or a list of program and arguments.
+		local, err := stringList(mapValue(p, "local"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.path, err)
+		}
+		if scalar(p, "plugin") == "go" || scalar(p, "name") == "go" ||
+			(len(local) > 0 && filepath.Base(local[0]) == "protoc-gen-go") ||
+			strings.HasPrefix(scalar(p, "remote"), "buf.build/protocolbuffers/go") {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// mapValue returns the value of key in the mapping node m, or nil.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalar(m *yaml.Node, key string) string {
+	if n := mapValue(m, key); n != nil && n.Kind == yaml.ScalarNode {
+		return n.Value
+	}
+	return ""
+}
+
+// stringList decodes a YAML node that is either a string or a list of strings.
+func stringList(n *yaml.Node) ([]string, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.Kind == yaml.ScalarNode {
+		return []string{n.Value}, nil
+	}
+	var l []string
+	err := n.Decode(&l)
+	return l, err
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bufgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+func TestGoOpts(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name: "v2",
+			content: `version: v2
+plugins:
+  - local: protoc-gen-go
+    out: gen
+    opt:
+      - paths=source_relative
+      - default_api_level=API_HYBRID
+  - remote: buf.build/grpc/go
+    out: gen
+    opt: paths=source_relative,require_unimplemented_servers=false
+`,
+			want: []string{"paths=source_relative", "default_api_level=API_HYBRID"},
+		},
+		{
+			name: "v2 remote",
+			content: `version: v2
+plugins:
+  - remote: buf.build/protocolbuffers/go:v1.36.1
+    out: gen
+    opt: default_api_level=API_OPAQUE
+`,
+			want: []string{"default_api_level=API_OPAQUE"},
+		},
+		{
+			name: "v1",
+			content: `version: v1
+plugins:
+  - plugin: go
+    out: gen
+    opt: Mfoo.proto=example.com/foopb
+  - plugin: go-grpc
+    out: gen
+`,
+			want: []string{"Mfoo.proto=example.com/foopb"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "buf.gen.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := cfg.GoOpts()
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("GoOpts() returned unexpected options (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetAPILevel(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		edit    func(*Config) error
+		want    string
+	}{
+		{
+			name: "default, opt list",
+			content: `version: v2
+# Generate Go code.
+plugins:
+  - local: protoc-gen-go
+    out: gen
+    opt:
+      - paths=source_relative
+      - default_api_level=API_OPEN # was the default
+`,
+			edit: func(c *Config) error { return c.SetDefaultAPILevel(gofeaturespb.GoFeatures_API_HYBRID) },
+			want: `version: v2
+# Generate Go code.
+plugins:
+  - local: protoc-gen-go
+    out: gen
+    opt:
+      - paths=source_relative
+      - default_api_level=API_HYBRID
+`,
+		},
+		{
+			name: "default, opt string",
+			content: `version: v1
+plugins:
+  - plugin: go
+    out: gen
+    opt: paths=source_relative,Mfoo.proto=example.com/foopb # see docs
+`,
+			edit: func(c *Config) error { return c.SetDefaultAPILevel(gofeaturespb.GoFeatures_API_OPAQUE) },
+			want: `version: v1
+plugins:
+  - plugin: go
+    out: gen
+    opt:
+      - paths=source_relative # see docs
+      - Mfoo.proto=example.com/foopb
+      - default_api_level=API_OPAQUE
+`,
+		},
+		{
+			name: "file, no opt",
+			content: `version: v2
+plugins:
+  - remote: buf.build/protocolbuffers/go
+    out: gen
+  - remote: buf.build/grpc/go
+    out: gen
+`,
+			edit: func(c *Config) error {
+				if err := c.SetFileAPILevel("foo/a.proto", gofeaturespb.GoFeatures_API_HYBRID); err != nil {
+					return err
+				}
+				return c.SetFileAPILevel("foo/a.proto", gofeaturespb.GoFeatures_API_OPAQUE)
+			},
+			want: `version: v2
+plugins:
+  - remote: buf.build/protocolbuffers/go
+    out: gen
+    opt:
+      - apilevelMfoo/a.proto=API_OPAQUE
+  - remote: buf.build/grpc/go
+    out: gen
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "buf.gen.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.edit(cfg); err != nil {
+				t.Fatal(err)
+			}
+			got, err := cfg.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("Marshal() returned unexpected content (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "buf.gen.yaml")
+	if err := os.WriteFile(path, []byte("version: v2\nplugins:\n  - remote: buf.build/grpc/go\n    out: gen\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetDefaultAPILevel(gofeaturespb.GoFeatures_API_HYBRID); err == nil {
+		t.Errorf("SetDefaultAPILevel() without protoc-gen-go plugin succeeded, want error")
+	}
+}
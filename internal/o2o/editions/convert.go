@@ -0,0 +1,465 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package editions
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"google.golang.org/open2opaque/internal/protoparse"
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Field numbers of the descriptor.proto messages, used in SourceCodeInfo
+// paths.
+// https://github.com/protocolbuffers/protobuf/blob/v29.1/src/google/protobuf/descriptor.proto
+const (
+	filePackageField    = 2
+	fileDependencyField = 3
+	fileMessageField    = 4
+	fileExtensionField  = 7
+	fileOptionsField    = 8
+	fileSyntaxField     = 12
+
+	messageFieldField     = 2
+	messageNestedField    = 3
+	messageExtensionField = 6
+
+	fieldNumberField  = 3
+	fieldLabelField   = 4
+	fieldOptionsField = 8
+
+	fieldOptionsPackedField = 2
+)
+
+// ErrAlreadyEditions is returned by Convert for files that already use
+// editions.
+var ErrAlreadyEditions = fmt.Errorf("file already uses editions")
+
+// edit replaces content[begin:end] with text.
+type edit struct {
+	begin, end int
+	text       string
+}
+
+// converter holds the state of converting one file.
+type converter struct {
+	path    string
+	content []byte
+	desc    *descpb.FileDescriptorProto
+	proto3  bool
+	locs    map[string]*descpb.SourceCodeInfo_Location
+	edits   []edit
+
+	// What the file contains, to add only the file-level features that make a
+	// difference.
+	hasEnums        bool
+	hasStrings      bool
+	hasExpanded     bool // proto2 repeated fields that may be packable and are not packed
+	hasImplicit     bool // proto3 singular fields without presence
+	hasJSONConflict bool
+}
+
+// Convert converts the proto2 or proto3 file at path with the given content to
+// edition 2023, adding the features that keep the behavior of the file
+// identical:
+//
+//   - proto2 files get file-level features for closed enums, expanded repeated
+//     fields, no UTF-8 validation and (only if needed) legacy JSON field name
+//     handling. Required fields get the LEGACY_REQUIRED field presence, packed
+//     fields the PACKED repeated field encoding, and groups become messages
+//     with a DELIMITED message encoding.
+//   - proto3 files get a file-level IMPLICIT field presence. Fields with the
+//     optional label get EXPLICIT field presence.
+//
+// The optional and required labels, which editions do not allow, are removed
+// and the packed option is replaced by a feature. Convert does not check the
+// result, see Verify.
+func Convert(path string, content []byte) ([]byte, error) {
+	parser := protoparse.NewParserWithAccessor(func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	fopt, err := parser.ParseFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+	c := &converter{
+		path:    path,
+		content: content,
+		desc:    fopt.Desc,
+		locs:    make(map[string]*descpb.SourceCodeInfo_Location),
+	}
+	switch fopt.Syntax {
+	case "editions":
+		return nil, ErrAlreadyEditions
+	case "proto3":
+		c.proto3 = true
+	case "", "proto2":
+	default:
+		return nil, fmt.Errorf("%s: unknown syntax %q", path, fopt.Syntax)
+	}
+	for _, loc := range fopt.SourceCodeInfo.GetLocation() {
+		key := fmt.Sprint(loc.GetPath())
+		if _, ok := c.locs[key]; !ok {
+			c.locs[key] = loc
+		}
+	}
+	if err := c.convert(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c.apply(), nil
+}
+
+func (c *converter) convert() error {
+	c.hasEnums = len(c.desc.GetEnumType()) > 0
+	for i, m := range c.desc.GetMessageType() {
+		if err := c.message(m, []int32{fileMessageField, int32(i)}); err != nil {
+			return err
+		}
+	}
+	for i, f := range c.desc.GetExtension() {
+		if err := c.field(f, []int32{fileExtensionField, int32(i)}, true); err != nil {
+			return err
+		}
+	}
+	return c.fileLevel()
+}
+
+// fileLevel replaces the syntax statement and adds the file-level features.
+func (c *converter) fileLevel() error {
+	var features []string
+	if c.proto3 {
+		if c.hasImplicit {
+			features = append(features, "field_presence = IMPLICIT")
+		}
+	} else {
+		if c.hasEnums {
+			features = append(features, "enum_type = CLOSED")
+		}
+		if c.hasExpanded {
+			features = append(features, "repeated_field_encoding = EXPANDED")
+		}
+		if c.hasStrings {
+			features = append(features, "utf8_validation = NONE")
+		}
+		if c.hasJSONConflict {
+			features = append(features, "json_format = LEGACY_BEST_EFFORT")
+		}
+	}
+
+	const edition = `edition = "2023";`
+	syntax, ok := c.locs[fmt.Sprint([]int32{fileSyntaxField})]
+	if !ok {
+		// A file without syntax statement is a proto2 file.
+		c.edits = append(c.edits, edit{0, 0, edition + "\n\n"})
+	} else {
+		begin, end, err := c.byteRange(syntax.GetSpan())
+		if err != nil {
+			return err
+		}
+		c.edits = append(c.edits, edit{begin, end, edition})
+	}
+	if len(features) == 0 {
+		return nil
+	}
+
+	// Insert the features after the last file option, or else after the
+	// package statement, the imports or the syntax statement.
+	var optionEnd, otherEnd int32 = -1, -1
+	for _, loc := range c.locs {
+		p := loc.GetPath()
+		switch {
+		case len(p) > 1 && p[0] == fileOptionsField:
+			optionEnd = max(optionEnd, endLine(loc.GetSpan()))
+		case len(p) > 0 && (p[0] == filePackageField || p[0] == fileDependencyField || p[0] == fileSyntaxField):
+			otherEnd = max(otherEnd, endLine(loc.GetSpan()))
+		}
+	}
+	var lines []string
+	for _, f := range features {
+		lines = append(lines, "option features."+f+";\n")
+	}
+	text := strings.Join(lines, "")
+	anchor := optionEnd
+	if anchor < 0 {
+		anchor = otherEnd
+		text = "\n" + text
+	}
+	if anchor < 0 {
+		c.edits = append(c.edits, edit{0, 0, text})
+		return nil
+	}
+	pos := lineEnd(c.content, c.lineOffset(int(anchor)))
+	c.edits = append(c.edits, edit{pos, pos, text})
+	return nil
+}
+
+func (c *converter) message(m *descpb.DescriptorProto, path []int32) error {
+	if len(m.GetEnumType()) > 0 {
+		c.hasEnums = true
+	}
+	jsonNames := make(map[string]bool)
+	for i, f := range m.GetField() {
+		name := strings.ToLower(jsonName(f))
+		if jsonNames[name] {
+			c.hasJSONConflict = true
+		}
+		jsonNames[name] = true
+		if err := c.field(f, append(slices.Clip(path), messageFieldField, int32(i)), false); err != nil {
+			return err
+		}
+	}
+	for i, f := range m.GetExtension() {
+		if err := c.field(f, append(slices.Clip(path), messageExtensionField, int32(i)), true); err != nil {
+			return err
+		}
+	}
+	for i, nested := range m.GetNestedType() {
+		if err := c.message(nested, append(slices.Clip(path), messageNestedField, int32(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonName returns the JSON name of a field as protoc computes it.
+func jsonName(f *descpb.FieldDescriptorProto) string {
+	if f.JsonName != nil {
+		return f.GetJsonName()
+	}
+	var b strings.Builder
+	upper := false
+	for _, r := range f.GetName() {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// mayBePackable reports whether a repeated field can be packed. Fields with
+// an unresolved type name may refer to an enum.
+func mayBePackable(f *descpb.FieldDescriptorProto) bool {
+	switch f.GetType() {
+	case descpb.FieldDescriptorProto_TYPE_STRING,
+		descpb.FieldDescriptorProto_TYPE_BYTES,
+		descpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+func (c *converter) field(f *descpb.FieldDescriptorProto, path []int32, extension bool) error {
+	if _, ok := c.locs[fmt.Sprint(path)]; !ok {
+		return nil // synthesized, e.g. a map entry field
+	}
+	if f.GetType() == descpb.FieldDescriptorProto_TYPE_STRING {
+		c.hasStrings = true
+	}
+	repeated := f.GetLabel() == descpb.FieldDescriptorProto_LABEL_REPEATED
+	var packed *bool
+	if opts := f.GetOptions(); opts != nil {
+		packed = opts.Packed
+	}
+	if !c.proto3 && repeated && mayBePackable(f) && !f.GetOptions().GetPacked() {
+		c.hasExpanded = true
+	}
+	if c.proto3 && !repeated && !f.GetProto3Optional() && f.OneofIndex == nil && !extension &&
+		f.GetType() != descpb.FieldDescriptorProto_TYPE_MESSAGE {
+		c.hasImplicit = true
+	}
+
+	var features []string
+	switch {
+	case f.GetLabel() == descpb.FieldDescriptorProto_LABEL_REQUIRED:
+		features = append(features, "features.field_presence = LEGACY_REQUIRED")
+	case c.proto3 && f.GetProto3Optional():
+		features = append(features, "features.field_presence = EXPLICIT")
+	}
+	if f.GetType() == descpb.FieldDescriptorProto_TYPE_GROUP {
+		if f.OneofIndex != nil || extension {
+			return fmt.Errorf("group %s: groups in oneofs and extensions are not supported, convert the group manually", f.GetName())
+		}
+		return c.group(f, path, features)
+	}
+
+	// Drop the optional and required labels, which editions do not allow.
+	if loc, ok := c.locs[fmt.Sprint(append(slices.Clip(path), fieldLabelField))]; ok && !repeated {
+		begin, end, err := c.byteRange(loc.GetSpan())
+		if err != nil {
+			return err
+		}
+		c.edits = append(c.edits, edit{begin, skipSpace(c.content, end), ""})
+	}
+
+	// Replace the packed option, which editions do not allow, by a feature.
+	if packed != nil {
+		loc, ok := c.locs[fmt.Sprint(append(slices.Clip(path), fieldOptionsField, fieldOptionsPackedField))]
+		if !ok {
+			return fmt.Errorf("field %s: no source location for the packed option", f.GetName())
+		}
+		begin, end, err := c.byteRange(loc.GetSpan())
+		if err != nil {
+			return err
+		}
+		encoding := "EXPANDED"
+		if *packed {
+			encoding = "PACKED"
+		}
+		c.edits = append(c.edits, edit{begin, end, "features.repeated_field_encoding = " + encoding})
+	}
+	if len(features) == 0 {
+		return nil
+	}
+	return c.addFieldOptions(path, strings.Join(features, ", "))
+}
+
+// addFieldOptions adds options to the option list of a field, creating the
+// list if the field has none.
+func (c *converter) addFieldOptions(path []int32, options string) error {
+	loc, ok := c.locs[fmt.Sprint(append(slices.Clip(path), fieldNumberField))]
+	if !ok {
+		return fmt.Errorf("no source location for field number at %v", path)
+	}
+	_, end, err := c.byteRange(loc.GetSpan())
+	if err != nil {
+		return err
+	}
+	if next := skipSpace(c.content, end); next < len(c.content) && c.content[next] == '[' {
+		c.edits = append(c.edits, edit{next + 1, next + 1, options + ", "})
+		return nil
+	}
+	c.edits = append(c.edits, edit{end, end, " [" + options + "]"})
+	return nil
+}
+
+// group converts a group into a message and a field with DELIMITED message
+// encoding after it:
+//
+//	optional group Foo = 1 [opts] { ... }
+//
+// becomes
+//
+//	message Foo { ... }
+//	Foo foo = 1 [features.message_encoding = DELIMITED, opts];
+func (c *converter) group(f *descpb.FieldDescriptorProto, path []int32, features []string) error {
+	loc := c.locs[fmt.Sprint(path)]
+	begin, end, err := c.byteRange(loc.GetSpan())
+	if err != nil {
+		return err
+	}
+	numLoc, ok := c.locs[fmt.Sprint(append(slices.Clip(path), fieldNumberField))]
+	if !ok {
+		return fmt.Errorf("group %s: no source location for the field number", f.GetName())
+	}
+	_, numEnd, err := c.byteRange(numLoc.GetSpan())
+	if err != nil {
+		return err
+	}
+	brace := bytes.IndexByte(c.content[numEnd:end], '{')
+	if brace < 0 {
+		return fmt.Errorf("group %s: body not found", f.GetName())
+	}
+	brace += numEnd
+	options := strings.TrimSpace(string(c.content[numEnd:brace]))
+	options = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(options, "["), "]"))
+
+	features = append(features, "features.message_encoding = DELIMITED")
+	if options != "" {
+		features = append(features, options)
+	}
+	typeName := f.GetTypeName()
+	if i := strings.LastIndexByte(typeName, '.'); i >= 0 {
+		typeName = typeName[i+1:]
+	}
+	label := ""
+	if f.GetLabel() == descpb.FieldDescriptorProto_LABEL_REPEATED {
+		label = "repeated "
+	}
+	indent := leadingSpace(c.content, begin)
+	c.edits = append(c.edits,
+		edit{begin, brace, "message " + typeName + " "},
+		edit{end, end, fmt.Sprintf("\n%s%s%s %s = %d [%s];", indent, label, typeName, f.GetName(), f.GetNumber(), strings.Join(features, ", "))},
+	)
+	return nil
+}
+
+// apply returns the content with all edits applied.
+func (c *converter) apply() []byte {
+	edits := slices.Clone(c.edits)
+	slices.SortStableFunc(edits, func(a, b edit) int {
+		if a.begin != b.begin {
+			return b.begin - a.begin
+		}
+		return b.end - a.end
+	})
+	out := slices.Clone(c.content)
+	for _, e := range edits {
+		out = slices.Concat(out[:e.begin], []byte(e.text), out[e.end:])
+	}
+	return out
+}
+
+func (c *converter) byteRange(span []int32) (int, int, error) {
+	return protoparse.SpanToTextRange(span).ToByteRange(c.content)
+}
+
+// lineOffset returns the byte offset of the beginning of line (0-based).
+func (c *converter) lineOffset(line int) int {
+	off := 0
+	for ; line > 0; line-- {
+		i := bytes.IndexByte(c.content[off:], '\n')
+		if i < 0 {
+			return len(c.content)
+		}
+		off += i + 1
+	}
+	return off
+}
+
+func endLine(span []int32) int32 {
+	if len(span) == 4 {
+		return span[2]
+	}
+	return span[0]
+}
+
+// lineEnd returns the offset after the newline that ends the line containing
+// off.
+func lineEnd(content []byte, off int) int {
+	i := bytes.IndexByte(content[off:], '\n')
+	if i < 0 {
+		return len(content)
+	}
+	return off + i + 1
+}
+
+// skipSpace returns the offset of the first non-space byte at or after off.
+func skipSpace(content []byte, off int) int {
+	for off < len(content) && (content[off] == ' ' || content[off] == '\t') {
+		off++
+	}
+	return off
+}
+
+// leadingSpace returns the indentation of the line containing off.
+func leadingSpace(content []byte, off int) string {
+	start := bytes.LastIndexByte(content[:off], '\n') + 1
+	end := start
+	for end < len(content) && (content[end] == ' ' || content[end] == '\t') {
+		end++
+	}
+	return string(content[start:end])
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package editions implements the editions open2opaque subcommand, which
+// converts proto2 and proto3 files to edition 2023 without changing their
+// behavior.
+package editions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flag"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+	"google.golang.org/open2opaque/internal/o2o/protofiles"
+)
+
+// Cmd implements the editions subcommand of the open2opaque tool.
+type Cmd struct {
+	protoPaths []string
+	excludes   []string
+	dryRun     bool
+	journalDir string
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "editions" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "Convert proto2 and proto3 files to edition 2023."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque editions [-proto_path=<dir>] [-exclude=<pattern>] [-dry_run] <path/file.proto|dir|glob> [...]
+
+The editions subcommand converts proto2 and proto3 files to edition 2023 while
+keeping their behavior identical. Replacing only the syntax line with an
+edition line changes the behavior of a file: for example, proto3 fields get
+explicit presence and proto2 enums become open. Instead, the editions
+subcommand adds the field_presence, repeated_field_encoding, enum_type,
+utf8_validation, message_encoding and json_format features that keep the
+proto2 or proto3 behavior, at file level or at field level as needed. Groups
+become messages with a DELIMITED message encoding.
+
+Each converted file is compiled together with the original (imports are looked
+up in the -proto_path include roots) to check that both produce the same
+descriptors. No file is written unless all files convert successfully. Files
+that already use editions are left unchanged.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.Func("proto_path", "include root in which imports are looked up (can be repeated, or a list separated by '"+string(os.PathListSeparator)+"'); default is the current directory", func(s string) error {
+		cmd.protoPaths = append(cmd.protoPaths, filepath.SplitList(s)...)
+		return nil
+	})
+	f.Func("exclude", "glob pattern of .proto files or directories to leave unchanged, e.g. 'third_party/**' (can be repeated)", func(s string) error {
+		cmd.excludes = append(cmd.excludes, s)
+		return nil
+	})
+	f.BoolVar(&cmd.dryRun, "dry_run", false, "do not write any proto files, print a unified diff of the changes per file instead")
+	f.StringVar(&cmd.journalDir, "journal_dir", "", "directory in which the original content of all written files is journaled, so that the run can be reverted with 'open2opaque undo'; empty means the default directory in the user cache directory")
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.editions(ctx, f); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in editions.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[editions] "+format+"\n", a...) }
+
+func (cmd *Cmd) editions(ctx context.Context, f *flag.FlagSet) error {
+	if f.NArg() == 0 {
+		return fmt.Errorf("missing inputs, pass proto file name(s), directories or glob patterns as non-flag arguments")
+	}
+	paths, err := protofiles.Find(f.Args(), cmd.excludes)
+	if err != nil {
+		return err
+	}
+	roots := cmd.protoPaths
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	type result struct {
+		path          string
+		orig, content []byte
+	}
+	var results []result
+	var errs []string
+	for _, path := range paths {
+		orig, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		converted, err := Convert(path, orig)
+		if errors.Is(err, ErrAlreadyEditions) {
+			logf("Skipping %s, which already uses editions", path)
+			continue
+		}
+		if err == nil {
+			err = Verify(roots, path, orig, converted)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		results = append(results, result{path, orig, converted})
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("aborting without writing any proto files, %d files could not be converted:\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+
+	if cmd.dryRun {
+		for _, r := range results {
+			diff, err := fix.UnifiedDiff(r.path, r.orig, r.content)
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(diff)
+		}
+		return nil
+	}
+	if len(results) == 0 {
+		logf("No changes")
+		return nil
+	}
+
+	root, err := journal.ResolveDir(cmd.journalDir)
+	if err != nil {
+		return err
+	}
+	jnl, err := journal.New(root, "editions")
+	if err != nil {
+		return fmt.Errorf("can't start the undo journal: %v", err)
+	}
+	var files []journal.File
+	for _, r := range results {
+		files = append(files, journal.File{Name: r.path, Data: r.content, Perm: 0644})
+	}
+	werr := jnl.WriteFiles(files)
+	if err := jnl.Close(); err != nil {
+		return fmt.Errorf("can't finish the undo journal: %v", err)
+	}
+	if werr != nil {
+		if len(jnl.Paths()) > 0 {
+			return fmt.Errorf("error while writing proto files (to revert the files that remain modified, use: open2opaque undo %s): %v", jnl.ID(), werr)
+		}
+		return fmt.Errorf("error while writing proto files, no files were changed: %v", werr)
+	}
+	logf("Converted %d files to edition 2023. To revert the changes of this run, use: open2opaque undo %s", len(results), jnl.ID())
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package editions
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvert(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "proto2",
+			in: `syntax = "proto2";
+
+package p2;
+
+option go_package = "example.com/p2";
+
+enum Color {
+  RED = 1;
+}
+
+message M {
+  required int32 id = 1;
+  optional string name = 2 [default = "x"];
+  repeated int32 plain = 3;
+  repeated int32 dense = 4 [packed = true];
+  optional Color color = 5;
+  optional group Item = 6 {
+    optional int32 v = 1;
+  }
+  repeated group Entry = 7 [deprecated = true] {
+    required string k = 1;
+  }
+  map<string, M> children = 8;
+  oneof o {
+    int64 a = 9;
+  }
+  extensions 100 to 200;
+}
+
+extend M {
+  optional int32 ext = 100;
+}
+`,
+			want: `edition = "2023";
+
+package p2;
+
+option go_package = "example.com/p2";
+option features.enum_type = CLOSED;
+option features.repeated_field_encoding = EXPANDED;
+option features.utf8_validation = NONE;
+
+enum Color {
+  RED = 1;
+}
+
+message M {
+  int32 id = 1 [features.field_presence = LEGACY_REQUIRED];
+  string name = 2 [default = "x"];
+  repeated int32 plain = 3;
+  repeated int32 dense = 4 [features.repeated_field_encoding = PACKED];
+  Color color = 5;
+  message Item {
+    int32 v = 1;
+  }
+  Item item = 6 [features.message_encoding = DELIMITED];
+  message Entry {
+    string k = 1 [features.field_presence = LEGACY_REQUIRED];
+  }
+  repeated Entry entry = 7 [features.message_encoding = DELIMITED, deprecated = true];
+  map<string, M> children = 8;
+  oneof o {
+    int64 a = 9;
+  }
+  extensions 100 to 200;
+}
+
+extend M {
+  int32 ext = 100;
+}
+`,
+		},
+		{
+			name: "proto2 JSON name conflict",
+			in: `syntax = "proto2";
+package p2;
+message M {
+  optional int32 foo_bar = 1;
+  optional int32 fooBar = 2;
+}
+`,
+			want: `edition = "2023";
+package p2;
+
+option features.json_format = LEGACY_BEST_EFFORT;
+message M {
+  int32 foo_bar = 1;
+  int32 fooBar = 2;
+}
+`,
+		},
+		{
+			name: "proto3",
+			in: `syntax = "proto3";
+
+package p3;
+
+import "google/protobuf/duration.proto";
+
+message N {
+  int32 a = 1;
+  optional int32 b = 2;
+  repeated int32 c = 3 [packed = false];
+  google.protobuf.Duration d = 4;
+  string s = 5;
+  oneof o { string x = 6; }
+}
+`,
+			want: `edition = "2023";
+
+package p3;
+
+import "google/protobuf/duration.proto";
+
+option features.field_presence = IMPLICIT;
+
+message N {
+  int32 a = 1;
+  int32 b = 2 [features.field_presence = EXPLICIT];
+  repeated int32 c = 3 [features.repeated_field_encoding = EXPANDED];
+  google.protobuf.Duration d = 4;
+  string s = 5;
+  oneof o { string x = 6; }
+}
+`,
+		},
+		{
+			name: "proto3 without implicit presence",
+			in: `syntax = "proto3";
+package p3;
+message N {
+  optional int32 b = 1;
+}
+`,
+			want: `edition = "2023";
+package p3;
+message N {
+  int32 b = 1 [features.field_presence = EXPLICIT];
+}
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "x.proto")
+			got, err := Convert(path, []byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("Convert() returned unexpected content (-want +got):\n%s", diff)
+			}
+			if err := Verify([]string{dir}, path, []byte(tc.in), got); err != nil {
+				t.Errorf("Verify(): %v", err)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	if _, err := Convert("x.proto", []byte("edition = \"2023\";\npackage p;\n")); !errors.Is(err, ErrAlreadyEditions) {
+		t.Errorf("Convert(edition file) = %v, want ErrAlreadyEditions", err)
+	}
+	in := `syntax = "proto2";
+message M {
+  oneof o {
+    group G = 1 {}
+  }
+}
+`
+	if _, err := Convert("x.proto", []byte(in)); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Convert(group in oneof) = %v, want unsupported error", err)
+	}
+}
+
+func TestVerifyDetectsDifferences(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.proto")
+	orig := `syntax = "proto2";
+enum E { A = 0; }
+message M {
+  optional string s = 1;
+  optional E e = 2;
+}
+`
+	// Only replacing the syntax line opens the enum and enables UTF-8
+	// validation.
+	naive := strings.Replace(orig, `syntax = "proto2";`, `edition = "2023";`, 1)
+	naive = strings.ReplaceAll(naive, "optional ", "")
+	if err := os.WriteFile(path, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Verify([]string{dir}, path, []byte(orig), []byte(naive))
+	if err == nil {
+		t.Fatal("Verify() succeeded, want error")
+	}
+	for _, want := range []string{"enum E: closed true != false", "field M.s: UTF-8 validation false != true"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Verify() = %v, want it to mention %q", err, want)
+		}
+	}
+}
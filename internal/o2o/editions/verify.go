@@ -0,0 +1,254 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package editions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+// Verify checks that the converted content of the proto file at path produces
+// the same descriptors as the original content: both are compiled, with
+// imports looked up in the include roots, and all messages, fields, enums and
+// extensions must have the same names, numbers, types, cardinality, presence,
+// packing, encoding, JSON names, default values, enum closedness and UTF-8
+// validation.
+func Verify(roots []string, path string, orig, converted []byte) error {
+	name, err := importName(roots, path)
+	if err != nil {
+		return err
+	}
+	origFD, err := link(roots, path, name, orig)
+	if err != nil {
+		return fmt.Errorf("compiling %s: %v", path, err)
+	}
+	convFD, err := link(roots, path, name, converted)
+	if err != nil {
+		return fmt.Errorf("compiling converted %s: %v", path, err)
+	}
+	var diffs []string
+	compareFiles(origFD, convFD, func(format string, a ...any) {
+		diffs = append(diffs, fmt.Sprintf(format, a...))
+	})
+	if len(diffs) > 0 {
+		return fmt.Errorf("converted %s differs from the original:\n\t%s", path, strings.Join(diffs, "\n\t"))
+	}
+	return nil
+}
+
+// importName returns the name under which path is imported, relative to the
+// first include root that contains it.
+func importName(roots []string, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(absRoot, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not in the proto path (%s), set -proto_path", path, strings.Join(roots, ", "))
+}
+
+// link compiles the file imported as name with the given content, reading its
+// imports from disk.
+func link(roots []string, path, name string, content []byte) (protoreflect.FileDescriptor, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	p := protoparse.Parser{
+		ImportPaths: roots,
+		Accessor: func(filename string) (io.ReadCloser, error) {
+			if a, err := filepath.Abs(filename); err == nil && a == abs {
+				return io.NopCloser(bytes.NewReader(content)), nil
+			}
+			return os.Open(filename)
+		},
+		LookupImportProto: func(name string) (*descpb.FileDescriptorProto, error) {
+			// go_features.proto is not one of the standard imports that the
+			// parser knows, but files using the Go API flag import it.
+			if name == gofeaturespb.File_google_protobuf_go_features_proto.Path() {
+				return protodesc.ToFileDescriptorProto(gofeaturespb.File_google_protobuf_go_features_proto), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	fds, err := p.ParseFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, errors.New("no file descriptor")
+	}
+	return fds[0].UnwrapFile(), nil
+}
+
+type reportFunc func(format string, a ...any)
+
+func compareFiles(x, y protoreflect.FileDescriptor, report reportFunc) {
+	if x.Package() != y.Package() {
+		report("package %s != %s", x.Package(), y.Package())
+	}
+	compareMessages(x.Messages(), y.Messages(), report)
+	compareEnums(x.Enums(), y.Enums(), report)
+	compareFields(x.Extensions(), y.Extensions(), report)
+}
+
+func compareMessages(x, y protoreflect.MessageDescriptors, report reportFunc) {
+	if x.Len() != y.Len() {
+		report("%d messages != %d messages", x.Len(), y.Len())
+		return
+	}
+	for i := 0; i < x.Len(); i++ {
+		xm, ym := x.Get(i), y.Get(i)
+		if xm.FullName() != ym.FullName() {
+			report("message %s != %s", xm.FullName(), ym.FullName())
+			continue
+		}
+		if xm.IsMapEntry() != ym.IsMapEntry() {
+			report("message %s: map entry %v != %v", xm.FullName(), xm.IsMapEntry(), ym.IsMapEntry())
+		}
+		compareFields(xm.Fields(), ym.Fields(), report)
+		compareFields(xm.Extensions(), ym.Extensions(), report)
+		compareMessages(xm.Messages(), ym.Messages(), report)
+		compareEnums(xm.Enums(), ym.Enums(), report)
+	}
+}
+
+func compareEnums(x, y protoreflect.EnumDescriptors, report reportFunc) {
+	if x.Len() != y.Len() {
+		report("%d enums != %d enums", x.Len(), y.Len())
+		return
+	}
+	for i := 0; i < x.Len(); i++ {
+		xe, ye := x.Get(i), y.Get(i)
+		if xe.FullName() != ye.FullName() {
+			report("enum %s != %s", xe.FullName(), ye.FullName())
+			continue
+		}
+		if xe.IsClosed() != ye.IsClosed() {
+			report("enum %s: closed %v != %v", xe.FullName(), xe.IsClosed(), ye.IsClosed())
+		}
+		if xe.Values().Len() != ye.Values().Len() {
+			report("enum %s: %d values != %d values", xe.FullName(), xe.Values().Len(), ye.Values().Len())
+		}
+	}
+}
+
+// fieldList is implemented by protoreflect.FieldDescriptors and
+// protoreflect.ExtensionDescriptors.
+type fieldList interface {
+	Len() int
+	Get(i int) protoreflect.FieldDescriptor
+}
+
+func compareFields(x, y fieldList, report reportFunc) {
+	if x.Len() != y.Len() {
+		report("%d fields != %d fields", x.Len(), y.Len())
+		return
+	}
+	for i := 0; i < x.Len(); i++ {
+		xf, yf := x.Get(i), y.Get(i)
+		if xf.FullName() != yf.FullName() {
+			report("field %s != %s", xf.FullName(), yf.FullName())
+			continue
+		}
+		for _, prop := range []struct {
+			name string
+			x, y any
+		}{
+			{"number", xf.Number(), yf.Number()},
+			{"kind", xf.Kind(), yf.Kind()},
+			{"cardinality", xf.Cardinality(), yf.Cardinality()},
+			{"presence", xf.HasPresence(), yf.HasPresence()},
+			{"packed", xf.IsPacked(), yf.IsPacked()},
+			{"JSON name", xf.JSONName(), yf.JSONName()},
+			{"default", xf.Default().Interface(), yf.Default().Interface()},
+			{"oneof", oneofName(xf), oneofName(yf)},
+			{"message type", messageName(xf), messageName(yf)},
+			{"enum type", enumName(xf), enumName(yf)},
+			{"UTF-8 validation", validatesUTF8(xf), validatesUTF8(yf)},
+		} {
+			if fmt.Sprint(prop.x) != fmt.Sprint(prop.y) {
+				report("field %s: %s %v != %v", xf.FullName(), prop.name, prop.x, prop.y)
+			}
+		}
+	}
+}
+
+// oneofName returns the name of the oneof containing the field, ignoring
+// synthetic oneofs of proto3 optional fields.
+func oneofName(fd protoreflect.FieldDescriptor) protoreflect.FullName {
+	if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+		return od.FullName()
+	}
+	return ""
+}
+
+func messageName(fd protoreflect.FieldDescriptor) protoreflect.FullName {
+	if md := fd.Message(); md != nil {
+		return md.FullName()
+	}
+	return ""
+}
+
+func enumName(fd protoreflect.FieldDescriptor) protoreflect.FullName {
+	if ed := fd.Enum(); ed != nil {
+		return ed.FullName()
+	}
+	return ""
+}
+
+// validatesUTF8 reports whether parsing a string field validates UTF-8. The
+// protoreflect API does not expose this, so the utf8_validation feature is
+// resolved here.
+func validatesUTF8(fd protoreflect.FieldDescriptor) bool {
+	if fd.Kind() != protoreflect.StringKind {
+		return false
+	}
+	file := fd.ParentFile()
+	switch file.Syntax() {
+	case protoreflect.Proto2:
+		return false
+	case protoreflect.Proto3:
+		return true
+	}
+	var sets []*descpb.FeatureSet
+	sets = append(sets, fd.Options().(*descpb.FieldOptions).GetFeatures())
+	if od := fd.ContainingOneof(); od != nil {
+		sets = append(sets, od.Options().(*descpb.OneofOptions).GetFeatures())
+	}
+	for p := fd.Parent(); p != nil; p = p.Parent() {
+		switch opts := p.Options().(type) {
+		case *descpb.MessageOptions:
+			sets = append(sets, opts.GetFeatures())
+		case *descpb.FileOptions:
+			sets = append(sets, opts.GetFeatures())
+		}
+	}
+	for _, fs := range sets {
+		if fs != nil && fs.Utf8Validation != nil {
+			return fs.GetUtf8Validation() == descpb.FeatureSet_VERIFY
+		}
+	}
+	return true // the edition 2023 default
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package inventory implements the inventory open2opaque subcommand, which
+// lists the generated message types that Go packages can refer to, with their
+// API level and how the packages use them.
+package inventory
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"flag"
+	"github.com/google/subcommands"
+	statspb "google.golang.org/open2opaque/internal/dashboard"
+	"google.golang.org/open2opaque/internal/o2o/usage"
+)
+
+// Values of the -filter flag.
+const (
+	FilterAll = "all"
+	// FilterOpenUsed selects the Open API types that analyzed packages use.
+	FilterOpenUsed = "open_used"
+	// FilterHybridReady selects the Hybrid API types that no analyzed package
+	// needs open struct access to.
+	FilterHybridReady = "hybrid_ready"
+)
+
+// Cmd implements the inventory subcommand of the open2opaque tool.
+type Cmd struct {
+	format       string
+	filter       string
+	buildConfigs string
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "inventory" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "List the generated message types with their API level and uses."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque inventory [-format=<table|json>] [-filter=<all|open_used|hybrid_ready>] <Go package pattern> [...]
+
+The inventory subcommand analyzes the Go packages (typically all packages of
+the repository, e.g. ./...) and lists every message type generated by
+protoc-gen-go in the packages and the packages that they (transitively)
+import. For each type, it reports the Go import path, the proto full name, the
+API level (OPEN, HYBRID or OPAQUE) and the number of analyzed packages that use
+the type. Open uses are uses that need open struct access, which stop working
+with the Opaque API: direct or internal field accesses, shallow copies,
+embedding and reflection.
+
+The -filter flag restricts the list:
+
+  open_used     Open API types that analyzed packages still use.
+  hybrid_ready  Hybrid API types without open uses, which can be switched to
+                the Opaque API.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.format, "format", "table", "output format, valid values: table, json")
+	f.StringVar(&cmd.filter, "filter", FilterAll, "types to list, valid values: all, open_used, hybrid_ready")
+	f.StringVar(&cmd.buildConfigs, "build_configs", "", "build configurations in which packages are loaded, see rewrite -build_configs")
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.inventory(ctx, f); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in inventory.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[inventory] "+format+"\n", a...) }
+
+func (cmd *Cmd) inventory(ctx context.Context, f *flag.FlagSet) error {
+	if cmd.format != "table" && cmd.format != "json" {
+		return fmt.Errorf("invalid -format value %q, valid values: table, json", cmd.format)
+	}
+	switch cmd.filter {
+	case FilterAll, FilterOpenUsed, FilterHybridReady:
+	default:
+		return fmt.Errorf("invalid -filter value %q, valid values: %s, %s, %s", cmd.filter, FilterAll, FilterOpenUsed, FilterHybridReady)
+	}
+	if f.NArg() == 0 {
+		return fmt.Errorf("missing Go package patterns, e.g. ./...")
+	}
+	res, err := usage.Analyze(ctx, f.Args(), cmd.buildConfigs, logf)
+	if err != nil {
+		return err
+	}
+	types := Filter(New(res.Messages, res.Entries), cmd.filter)
+	if cmd.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(types)
+	} else {
+		err = WriteTable(os.Stdout, types)
+	}
+	if err != nil {
+		return err
+	}
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d packages could not be analyzed, the inventory misses their uses:\n\t%s", len(res.Failed), strings.Join(res.Failed, "\n\t"))
+	}
+	return nil
+}
+
+// Type is a generated message type in the inventory.
+type Type struct {
+	// GoPackage is the Go import path of the generated package.
+	GoPackage string `json:"go_package"`
+	// GoName is the name of the Go type.
+	GoName string `json:"go_name"`
+	// FullName is the proto full name of the message. Empty if unknown.
+	FullName string `json:"full_name,omitempty"`
+	// ProtoFile is the .proto file of the message. Empty if unknown.
+	ProtoFile string `json:"proto_file,omitempty"`
+	// API is OPEN, HYBRID or OPAQUE.
+	API string `json:"api"`
+	// Packages is the number of analyzed packages that use the type.
+	Packages int `json:"packages"`
+	// OpenUses is the number of uses that need open struct access.
+	OpenUses int `json:"open_uses"`
+}
+
+// New returns the inventory of the message types, sorted by Go package and
+// name, with the uses of the stats entries. Uses in generated files and uses of
+// types that are not among messages are ignored.
+func New(messages []usage.Message, entries []*statspb.Entry) []*Type {
+	byType := make(map[string]*Type)
+	var types []*Type
+	for _, m := range messages {
+		t := &Type{
+			GoPackage: m.GoPackage,
+			GoName:    m.Name,
+			FullName:  m.FullName,
+			ProtoFile: m.ProtoFile,
+			API:       m.API.String(),
+		}
+		byType[m.GoType()] = t
+		types = append(types, t)
+	}
+	users := make(map[*Type]map[string]bool)
+	for _, e := range entries {
+		if e.GetLocation().GetIsGeneratedFile() {
+			continue
+		}
+		t, ok := byType[usage.TypeName(e.GetType().GetLongName())]
+		if !ok {
+			continue
+		}
+		if users[t] == nil {
+			users[t] = make(map[string]bool)
+		}
+		users[t][e.GetLocation().GetPackage()] = true
+		if usage.BlockingUses[e.GetUse().GetType()] {
+			t.OpenUses++
+		}
+	}
+	for t, pkgs := range users {
+		t.Packages = len(pkgs)
+	}
+	slices.SortFunc(types, func(a, b *Type) int {
+		return cmp.Or(strings.Compare(a.GoPackage, b.GoPackage), strings.Compare(a.GoName, b.GoName))
+	})
+	return types
+}
+
+// Filter returns the types selected by filter, one of the Filter constants.
+func Filter(types []*Type, filter string) []*Type {
+	var selected []*Type
+	for _, t := range types {
+		switch filter {
+		case FilterOpenUsed:
+			if t.API != "OPEN" || t.Packages == 0 {
+				continue
+			}
+		case FilterHybridReady:
+			if t.API != "HYBRID" || t.OpenUses > 0 {
+				continue
+			}
+		}
+		selected = append(selected, t)
+	}
+	return selected
+}
+
+// WriteTable writes the types as a human-readable table.
+func WriteTable(w io.Writer, types []*Type) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "GO TYPE\tPROTO MESSAGE\tAPI\tPACKAGES\tOPEN USES")
+	for _, t := range types {
+		fullName := t.FullName
+		if fullName == "" {
+			fullName = "?"
+		}
+		fmt.Fprintf(tw, "%s.%s\t%s\t%s\t%d\t%d\n", t.GoPackage, t.GoName, fullName, t.API, t.Packages, t.OpenUses)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d message types\n", len(types))
+	return nil
+}
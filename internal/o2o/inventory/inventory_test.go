@@ -0,0 +1,81 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inventory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	statspb "google.golang.org/open2opaque/internal/dashboard"
+	"google.golang.org/open2opaque/internal/o2o/usage"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+)
+
+func TestInventory(t *testing.T) {
+	entry := func(pkg, typ string, use statspb.Use_Type, generated bool) *statspb.Entry {
+		return &statspb.Entry{
+			Location: &statspb.Location{Package: pkg, IsGeneratedFile: generated},
+			Type:     &statspb.Type{LongName: typ},
+			Use:      &statspb.Use{Type: use},
+		}
+	}
+	msg := func(pkg, name, fullName string, api protodetecttypes.MessageAPI) usage.Message {
+		return usage.Message{
+			GoPackage:        pkg,
+			GeneratedMessage: protodetecttypes.GeneratedMessage{Name: name, API: api, FullName: fullName},
+		}
+	}
+	messages := []usage.Message{
+		msg("example.com/bpb", "B", "b.B", protodetecttypes.HybridAPI),
+		msg("example.com/apb", "Unused", "a.Unused", protodetecttypes.OpenAPI),
+		msg("example.com/apb", "A", "a.A", protodetecttypes.OpenAPI),
+		msg("example.com/bpb", "B_Nested", "b.B.Nested", protodetecttypes.HybridAPI),
+		msg("example.com/cpb", "C", "", protodetecttypes.OpaqueAPI),
+	}
+	entries := []*statspb.Entry{
+		entry("example.com/x", "*example.com/apb.A", statspb.Use_METHOD_CALL, false),
+		entry("example.com/y", "*example.com/apb.A", statspb.Use_DIRECT_FIELD_ACCESS, false),
+		entry("example.com/y", "[]*example.com/apb.A", statspb.Use_DIRECT_FIELD_ACCESS, false),
+		entry("example.com/bpb", "*example.com/bpb.B", statspb.Use_DIRECT_FIELD_ACCESS, true),
+		entry("example.com/x", "*example.com/bpb.B", statspb.Use_CONSTRUCTOR, false),
+		entry("example.com/x", "*example.com/bpb.B_Nested", statspb.Use_SHALLOW_COPY, false),
+		entry("example.com/x", "*example.com/dpb.D", statspb.Use_DIRECT_FIELD_ACCESS, false),
+	}
+	types := New(messages, entries)
+
+	var b strings.Builder
+	if err := WriteTable(&b, types); err != nil {
+		t.Fatal(err)
+	}
+	want := `GO TYPE                   PROTO MESSAGE  API     PACKAGES  OPEN USES
+example.com/apb.A         a.A            OPEN    2         2
+example.com/apb.Unused    a.Unused       OPEN    0         0
+example.com/bpb.B         b.B            HYBRID  1         0
+example.com/bpb.B_Nested  b.B.Nested     HYBRID  1         1
+example.com/cpb.C         ?              OPAQUE  0         0
+
+5 message types
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("WriteTable() diff (-want +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		filter string
+		want   []string
+	}{
+		{FilterOpenUsed, []string{"A"}},
+		{FilterHybridReady, []string{"B"}},
+	} {
+		var got []string
+		for _, t := range Filter(types, tc.filter) {
+			got = append(got, t.GoName)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Filter(%s) diff (-want +got):\n%s", tc.filter, diff)
+		}
+	}
+}
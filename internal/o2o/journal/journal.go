@@ -0,0 +1,524 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package journal writes files atomically and records their original content
+// so that the changes of an open2opaque run can be undone later.
+package journal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestName is the name of the file in a run directory that describes the
+// run and all files it touched.
+const manifestName = "manifest.json"
+
+// Entry describes a single file that was written during a run.
+type Entry struct {
+	// Path is the absolute path of the file.
+	Path string `json:"path"`
+	// Existed is false if the file was created by the run.
+	Existed bool `json:"existed"`
+	// Mode is the permission of the original file.
+	Mode os.FileMode `json:"mode"`
+	// Blob is the name of the file in the run directory that holds the
+	// original content. Empty if Existed is false.
+	Blob string `json:"blob,omitempty"`
+	// OriginalSHA256 is the hex-encoded SHA-256 checksum of the original
+	// content.
+	OriginalSHA256 string `json:"original_sha256,omitempty"`
+	// WrittenSHA256 is the hex-encoded SHA-256 checksum of the content at the
+	// end of the run. Undo refuses to restore files that don't match it.
+	WrittenSHA256 string `json:"written_sha256"`
+}
+
+// Run describes a journaled run of the open2opaque tool.
+type Run struct {
+	// ID identifies the run, e.g. on the command-line of the undo subcommand.
+	ID string `json:"id"`
+	// Command is the subcommand that created the run, e.g. "rewrite".
+	Command string `json:"command"`
+	// Created is the time the run started.
+	Created time.Time `json:"created"`
+	// Undone is set once the run has been undone.
+	Undone bool `json:"undone,omitempty"`
+	// Entries lists all files written by the run, in the order they were
+	// first written.
+	Entries []*Entry `json:"entries"`
+}
+
+// Journal records the original content of all files written through it. It is
+// safe for concurrent use.
+type Journal struct {
+	dir string // run directory
+
+	mu     sync.Mutex
+	run    Run
+	byPath map[string]*Entry
+}
+
+// DefaultDir returns the directory in which journals are stored unless the
+// user specifies a different one.
+func DefaultDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "open2opaque", "journal"), nil
+}
+
+// ResolveDir returns dir, or DefaultDir if dir is empty.
+func ResolveDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	return DefaultDir()
+}
+
+// New starts a journal for a new run of the specified subcommand in a fresh
+// run directory below root.
+func New(root, command string) (*Journal, error) {
+	now := time.Now()
+	id := now.UTC().Format("20060102-150405.000000") + "-" + strconv.Itoa(os.Getpid())
+	dir := filepath.Join(root, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir: dir,
+		run: Run{
+			ID:      id,
+			Command: command,
+			Created: now,
+		},
+		byPath: make(map[string]*Entry),
+	}
+	if err := j.saveLocked(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// ID returns the identifier of the run.
+func (j *Journal) ID() string {
+	return j.run.ID
+}
+
+// Original returns the content that the named file had before it was first
+// written in this run, or false if the run created the file.
+func (j *Journal) Original(name string) ([]byte, bool, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, false, err
+	}
+	j.mu.Lock()
+	e, ok := j.byPath[path]
+	j.mu.Unlock()
+	if !ok {
+		return nil, false, fmt.Errorf("%s was not written in run %s", path, j.run.ID)
+	}
+	if !e.Existed {
+		return nil, false, nil
+	}
+	b, err := os.ReadFile(filepath.Join(j.dir, e.Blob))
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Paths returns the absolute paths of all files written so far, sorted.
+func (j *Journal) Paths() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	paths := make([]string, 0, len(j.run.Entries))
+	for _, e := range j.run.Entries {
+		paths = append(paths, e.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WriteFile records the original content of the named file (the first time the
+// file is written in this run) and then atomically replaces the file with data.
+func (j *Journal) WriteFile(name string, data []byte, perm os.FileMode) error {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	if err := j.record(path); err != nil {
+		return fmt.Errorf("journaling %s: %v", name, err)
+	}
+	if err := WriteFileAtomic(path, data, perm); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.byPath[path].WrittenSHA256 = checksum(data)
+	return j.saveLocked()
+}
+
+// rename is os.Rename, replaced in tests to inject failures.
+var rename = os.Rename
+
+// File is a file to write with WriteFiles.
+type File struct {
+	Name string
+	Data []byte
+	// Perm is used if the file does not exist yet.
+	Perm os.FileMode
+}
+
+// WriteFiles writes all files or none of them: it first records the original
+// content of the files and stages the new content to temporary files, and
+// only if that succeeds for every file renames the temporary files into place.
+// If a rename fails, the files renamed so far are restored to their original
+// content and the journal forgets about them. The returned error says which
+// files, if any, could not be restored; these stay in the journal so that the
+// run can be undone.
+func (j *Journal) WriteFiles(files []File) error {
+	type staged struct {
+		path, tmp string
+		orig      []byte // nil if the file did not exist
+		perm      os.FileMode
+	}
+	j.mu.Lock()
+	numEntries := len(j.run.Entries)
+	j.mu.Unlock()
+	var stagedFiles []staged
+	removeTemps := func() {
+		for _, s := range stagedFiles {
+			os.Remove(s.tmp)
+		}
+	}
+	cleanup := func() {
+		removeTemps()
+		j.forget(numEntries)
+	}
+
+	for _, f := range files {
+		path, err := filepath.Abs(f.Name)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		s := staged{path: path, perm: f.Perm}
+		if fi, err := os.Stat(path); err == nil {
+			s.perm = fi.Mode().Perm()
+			if s.orig, err = os.ReadFile(path); err != nil {
+				cleanup()
+				return err
+			}
+		}
+		if err := j.record(path); err != nil {
+			cleanup()
+			return fmt.Errorf("journaling %s: %v", f.Name, err)
+		}
+		if s.tmp, err = stage(path, f.Data, f.Perm); err != nil {
+			cleanup()
+			return fmt.Errorf("staging %s: %v", f.Name, err)
+		}
+		stagedFiles = append(stagedFiles, s)
+	}
+
+	for i, s := range stagedFiles {
+		err := rename(s.tmp, s.path)
+		if err == nil {
+			continue
+		}
+		// Roll back the files renamed so far.
+		var notRestored []string
+		for _, r := range stagedFiles[:i] {
+			var rerr error
+			if r.orig == nil {
+				rerr = os.Remove(r.path)
+			} else {
+				rerr = WriteFileAtomic(r.path, r.orig, r.perm)
+			}
+			if rerr != nil {
+				notRestored = append(notRestored, r.path)
+			}
+		}
+		stagedFiles = stagedFiles[i:]
+		if len(notRestored) > 0 {
+			removeTemps()
+			return fmt.Errorf("writing %s: %v; rolling back failed, these files are modified: %s", s.path, err, strings.Join(notRestored, ", "))
+		}
+		cleanup()
+		return fmt.Errorf("writing %s: %v; rolled back all files", s.path, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, s := range stagedFiles {
+		j.byPath[s.path].WrittenSHA256 = checksum(files[i].Data)
+	}
+	return j.saveLocked()
+}
+
+// forget removes the entries recorded after the first n entries from the
+// journal.
+func (j *Journal) forget(n int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.run.Entries[n:] {
+		delete(j.byPath, e.Path)
+		if e.Blob != "" {
+			os.Remove(filepath.Join(j.dir, e.Blob))
+		}
+	}
+	j.run.Entries = j.run.Entries[:n]
+	j.saveLocked()
+}
+
+// record saves the original content of path unless it was already recorded.
+func (j *Journal) record(path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.byPath[path]; ok {
+		return nil
+	}
+	e := &Entry{Path: path}
+	fi, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		e.Existed = true
+		e.Mode = fi.Mode().Perm()
+		e.Blob = strconv.Itoa(len(j.run.Entries)) + ".orig"
+		e.OriginalSHA256 = checksum(b)
+		if err := WriteFileAtomic(filepath.Join(j.dir, e.Blob), b, 0644); err != nil {
+			return err
+		}
+	}
+	j.run.Entries = append(j.run.Entries, e)
+	j.byPath[path] = e
+	return j.saveLocked()
+}
+
+// Close records the current content of all journaled files, which allows
+// post-processing steps (e.g. goimports) to run after the files were written
+// through the journal. If no files were written, the run directory is removed.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.run.Entries) == 0 {
+		return os.RemoveAll(j.dir)
+	}
+	for _, e := range j.run.Entries {
+		b, err := os.ReadFile(e.Path)
+		if err != nil {
+			return err
+		}
+		e.WrittenSHA256 = checksum(b)
+	}
+	return j.saveLocked()
+}
+
+func (j *Journal) saveLocked() error {
+	return saveRun(j.dir, &j.run)
+}
+
+func saveRun(dir string, run *Run) error {
+	b, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, manifestName), b, 0644)
+}
+
+func loadRun(dir string) (*Run, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	run := &Run{}
+	if err := json.Unmarshal(b, run); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", filepath.Join(dir, manifestName), err)
+	}
+	return run, nil
+}
+
+// List returns all runs journaled below root, oldest first.
+func List(root string) ([]*Run, error) {
+	dirents, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	for _, de := range dirents {
+		if !de.IsDir() {
+			continue
+		}
+		run, err := loadRun(filepath.Join(root, de.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // not a run directory
+		}
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Created.Before(runs[j].Created)
+	})
+	return runs, nil
+}
+
+// Latest returns the most recent run below root that has not been undone yet.
+func Latest(root string) (*Run, error) {
+	runs, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if !runs[i].Undone {
+			return runs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no run to undo in %s", root)
+}
+
+// ModifiedError is returned by Undo if files were changed after the run wrote
+// them.
+type ModifiedError struct {
+	Paths []string
+}
+
+func (e *ModifiedError) Error() string {
+	return fmt.Sprintf("%d file(s) were modified after the run, not undoing anything:\n\t%s", len(e.Paths), strings.Join(e.Paths, "\n\t"))
+}
+
+// Undo restores all files written by the run with the given ID to their
+// original content and removes files that the run created. It first checks
+// that none of the files was modified since the run; if any was, Undo returns a
+// *ModifiedError and changes nothing. Undo returns the restored paths.
+func Undo(root, id string) ([]string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid run ID %q", id)
+	}
+	dir := filepath.Join(root, id)
+	run, err := loadRun(dir)
+	if err != nil {
+		return nil, err
+	}
+	if run.Undone {
+		return nil, fmt.Errorf("run %s was already undone", id)
+	}
+
+	var modified []string
+	for _, e := range run.Entries {
+		b, err := os.ReadFile(e.Path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				modified = append(modified, e.Path)
+				continue
+			}
+			return nil, err
+		}
+		if checksum(b) != e.WrittenSHA256 {
+			modified = append(modified, e.Path)
+		}
+	}
+	if len(modified) > 0 {
+		return nil, &ModifiedError{Paths: modified}
+	}
+
+	var restored []string
+	for _, e := range run.Entries {
+		if !e.Existed {
+			if err := os.Remove(e.Path); err != nil {
+				return restored, err
+			}
+			restored = append(restored, e.Path)
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Blob))
+		if err != nil {
+			return restored, err
+		}
+		if got := checksum(b); got != e.OriginalSHA256 {
+			return restored, fmt.Errorf("journal blob %s for %s is corrupt: checksum %s, want %s", e.Blob, e.Path, got, e.OriginalSHA256)
+		}
+		if err := WriteFileAtomic(e.Path, b, e.Mode); err != nil {
+			return restored, err
+		}
+		restored = append(restored, e.Path)
+	}
+	run.Undone = true
+	return restored, saveRun(dir, run)
+}
+
+// WriteFileAtomic writes data to a temporary file in the directory of name and
+// renames it to name, so that readers observe either the old or the new
+// content, never a partially written file. If name exists, its permissions are
+// preserved; otherwise perm is used.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := stage(name, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// stage writes data to a temporary file in the directory of name, with the
+// permissions of name if it exists or else perm, and returns the temporary
+// file name.
+func stage(name string, data []byte, perm os.FileMode) (_ string, err error) {
+	if fi, err := os.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		return "", err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
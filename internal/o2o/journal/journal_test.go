@@ -0,0 +1,233 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUndoRestoresOriginals(t *testing.T) {
+	root := t.TempDir()
+	work := t.TempDir()
+	existing := filepath.Join(work, "existing.go")
+	created := filepath.Join(work, "created.go")
+	if err := os.WriteFile(existing, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := New(root, "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"green", "yellow"} {
+		if err := j.WriteFile(existing, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.WriteFile(created, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readFile(t, existing), "yellow"; got != want {
+		t.Errorf("after WriteFile: content = %q, want %q", got, want)
+	}
+	fi, err := os.Stat(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Errorf("after WriteFile: mode = %v, want %v (preserved)", got, want)
+	}
+
+	latest, err := Latest(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != j.ID() {
+		t.Errorf("Latest() = %q, want %q", latest.ID, j.ID())
+	}
+
+	restored, err := Undo(root, j.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{existing, created}, restored); diff != "" {
+		t.Errorf("Undo() returned unexpected paths (-want +got):\n%s", diff)
+	}
+	if got, want := readFile(t, existing), "original"; got != want {
+		t.Errorf("after Undo: content = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(created); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("after Undo: os.Stat(%s) = %v, want not exist", created, err)
+	}
+
+	if _, err := Undo(root, j.ID()); err == nil {
+		t.Errorf("second Undo() succeeded, want error")
+	}
+	if _, err := Latest(root); err == nil {
+		t.Errorf("Latest() succeeded after the only run was undone, want error")
+	}
+}
+
+func TestUndoRefusesModifiedFiles(t *testing.T) {
+	root := t.TempDir()
+	work := t.TempDir()
+	a := filepath.Join(work, "a.proto")
+	b := filepath.Join(work, "b.proto")
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j, err := New(root, "setapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{a, b} {
+		if err := j.WriteFile(path, []byte("written"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("edited by the user"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Undo(root, j.ID())
+	var merr *ModifiedError
+	if !errors.As(err, &merr) {
+		t.Fatalf("Undo() = %v, want a *ModifiedError", err)
+	}
+	if diff := cmp.Diff([]string{b}, merr.Paths); diff != "" {
+		t.Errorf("ModifiedError.Paths unexpected (-want +got):\n%s", diff)
+	}
+	// Nothing must have been restored.
+	if got, want := readFile(t, a), "written"; got != want {
+		t.Errorf("after failed Undo: content = %q, want %q", got, want)
+	}
+}
+
+func TestUndoRejectsInvalidIDs(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "journal")
+	for _, id := range []string{"", ".", "..", "../x", "a/b", `a\b`} {
+		if _, err := Undo(root, id); err == nil || !strings.Contains(err.Error(), "invalid run ID") {
+			t.Errorf("Undo(%q) = %v, want an invalid run ID error", id, err)
+		}
+	}
+}
+
+func TestCloseRemovesEmptyRun(t *testing.T) {
+	root := t.TempDir()
+	j, err := New(root, "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("List() = %d runs, want 0", len(runs))
+	}
+}
+
+func TestWriteFilesRollsBack(t *testing.T) {
+	root := t.TempDir()
+	work := t.TempDir()
+	a := filepath.Join(work, "a.proto")
+	b := filepath.Join(work, "b.proto")
+	c := filepath.Join(work, "c.proto") // does not exist yet
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []File{
+		{Name: a, Data: []byte("new a"), Perm: 0644},
+		{Name: c, Data: []byte("new c"), Perm: 0644},
+		{Name: b, Data: []byte("new b"), Perm: 0644},
+	}
+
+	// Fail renaming the last file, after a and c were written.
+	defer func(orig func(string, string) error) { rename = orig }(rename)
+	rename = func(oldpath, newpath string) error {
+		if newpath == b {
+			return errors.New("injected failure")
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	j, err := New(root, "setapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.WriteFiles(files); err == nil {
+		t.Fatal("WriteFiles succeeded, want error")
+	}
+	for _, path := range []string{a, b} {
+		if got, want := readFile(t, path), "original"; got != want {
+			t.Errorf("after failed WriteFiles: %s content = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(c); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("after failed WriteFiles: %s exists, want it removed", c)
+	}
+	if got := j.Paths(); len(got) != 0 {
+		t.Errorf("after failed WriteFiles: journal has paths %v, want none", got)
+	}
+	entries, err := os.ReadDir(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("after failed WriteFiles: %d files in %s, want 2 (temporary files left behind?)", len(entries), work)
+	}
+
+	// Without failure, all files are written and journaled.
+	rename = os.Rename
+	if err := j.WriteFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if got, want := readFile(t, f.Name), string(f.Data); got != want {
+			t.Errorf("after WriteFiles: %s content = %q, want %q", f.Name, got, want)
+		}
+	}
+	if diff := cmp.Diff([]string{a, b, c}, j.Paths()); diff != "" {
+		t.Errorf("journaled paths differ (-want +got):\n%s", diff)
+	}
+	if _, err := Undo(root, j.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, b), "original"; got != want {
+		t.Errorf("after Undo: content = %q, want %q", got, want)
+	}
+}
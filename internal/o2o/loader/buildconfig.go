@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loader
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BuildConfig is a build configuration in which packages are loaded. Files
+// excluded by build constraints in one configuration can be loaded in
+// another.
+type BuildConfig struct {
+	GOOS   string   // empty means the default
+	GOARCH string   // empty means the default
+	Tags   []string // build tags
+	Env    []string // additional environment variables (KEY=VALUE)
+}
+
+// String returns the configuration in the syntax accepted by
+// ParseBuildConfigs.
+func (c *BuildConfig) String() string {
+	if c == nil {
+		return "default"
+	}
+	var parts []string
+	if c.GOOS != "" || c.GOARCH != "" {
+		parts = append(parts, c.GOOS+"/"+c.GOARCH)
+	}
+	parts = append(parts, c.Tags...)
+	parts = append(parts, c.Env...)
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseBuildConfigs parses a semicolon-separated list of build configurations.
+// Each configuration is a comma-separated list of items: GOOS/GOARCH (either
+// part may be empty), KEY=VALUE environment variables and build tags. For
+// example:
+//
+//	linux/amd64;windows/amd64;linux/amd64,integration;CGO_ENABLED=0
+func ParseBuildConfigs(s string) ([]*BuildConfig, error) {
+	var configs []*BuildConfig
+	for _, cs := range strings.Split(s, ";") {
+		cs = strings.TrimSpace(cs)
+		if cs == "" {
+			continue
+		}
+		c := &BuildConfig{}
+		for _, item := range strings.Split(cs, ",") {
+			item = strings.TrimSpace(item)
+			switch {
+			case item == "":
+			case strings.Contains(item, "="):
+				c.Env = append(c.Env, item)
+			case strings.Contains(item, "/"):
+				if c.GOOS != "" || c.GOARCH != "" {
+					return nil, fmt.Errorf("build configuration %q: more than one GOOS/GOARCH", cs)
+				}
+				c.GOOS, c.GOARCH, _ = strings.Cut(item, "/")
+			default:
+				c.Tags = append(c.Tags, item)
+			}
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// environ returns the environment in which the go command runs for c.
+func (c *BuildConfig) environ() []string {
+	if c == nil {
+		return nil // inherit
+	}
+	env := os.Environ()
+	if c.GOOS != "" {
+		env = append(env, "GOOS="+c.GOOS)
+	}
+	if c.GOARCH != "" {
+		env = append(env, "GOARCH="+c.GOARCH)
+	}
+	return append(env, c.Env...)
+}
+
+func (c *BuildConfig) buildFlags() []string {
+	if c == nil || len(c.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(c.Tags, ",")}
+}
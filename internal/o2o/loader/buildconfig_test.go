@@ -0,0 +1,38 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loader_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+)
+
+func TestParseBuildConfigs(t *testing.T) {
+	got, err := loader.ParseBuildConfigs("linux/amd64; windows/ ;linux/amd64,integration,CGO_ENABLED=0;;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*loader.BuildConfig{
+		{GOOS: "linux", GOARCH: "amd64"},
+		{GOOS: "windows"},
+		{GOOS: "linux", GOARCH: "amd64", Tags: []string{"integration"}, Env: []string{"CGO_ENABLED=0"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseBuildConfigs() returned unexpected configs (-want +got):\n%s", diff)
+	}
+	var names []string
+	for _, c := range got {
+		names = append(names, c.String())
+	}
+	if want := []string{"linux/amd64", "windows/", "linux/amd64,integration,CGO_ENABLED=0"}; !cmp.Equal(names, want) {
+		t.Errorf("String() = %q, want %q", names, want)
+	}
+
+	if _, err := loader.ParseBuildConfigs("linux/amd64,darwin/arm64"); err == nil {
+		t.Errorf("ParseBuildConfigs() with two GOOS/GOARCH items succeeded, want error")
+	}
+}
@@ -20,6 +20,10 @@ type Package struct {
 	Fileset  *token.FileSet // For translating between positions and locations in files.
 	TypeInfo *types.Info    // Type information for the package (e.g. object identity, identifier uses/declarations, expression types).
 	TypePkg  *types.Package // Describes the package (e.g. import objects, package scope).
+
+	// IgnoredFiles are the files of the package that were excluded by build
+	// constraints in the configuration in which it was loaded.
+	IgnoredFiles []string
 }
 
 func (p Package) String() string {
@@ -64,6 +68,17 @@ type Target struct {
 	// concept of test only code, only Blaze does.
 	Testonly bool
 
+	// Dir is the directory in which the package is loaded, typically the root
+	// of the module that contains it. Empty means the loader's directory.
+	Dir string
+
+	// Module is the path of the module that contains the package, if known.
+	Module string
+
+	// Build is the build configuration in which the package is loaded. Nil
+	// means the default configuration of the go command.
+	Build *BuildConfig
+
 	LibrarySrcs map[string]bool
 }
 
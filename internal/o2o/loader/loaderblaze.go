@@ -38,6 +38,31 @@ func failBatch(targets []*Target, res chan LoadResult, err error) {
 
 // LoadPackage loads a batch of Go packages.
 func (l *BlazeLoader) LoadPackages(ctx context.Context, targets []*Target, res chan LoadResult) {
+	// Targets from different modules of a workspace need to be loaded in
+	// their respective module directory, and targets in different build
+	// configurations need to be loaded separately.
+	type group struct {
+		dir   string
+		build *BuildConfig
+	}
+	var groups []group
+	byGroup := make(map[group][]*Target)
+	for _, t := range targets {
+		g := group{dir: t.Dir, build: t.Build}
+		if g.dir == "" {
+			g.dir = l.dir
+		}
+		if _, ok := byGroup[g]; !ok {
+			groups = append(groups, g)
+		}
+		byGroup[g] = append(byGroup[g], t)
+	}
+	for _, g := range groups {
+		l.loadPackages(ctx, g.dir, g.build, byGroup[g], res)
+	}
+}
+
+func (l *BlazeLoader) loadPackages(ctx context.Context, dir string, build *BuildConfig, targets []*Target, res chan LoadResult) {
 	targetByID := make(map[string]*Target)
 	patterns := make([]string, len(targets))
 	for idx, t := range targets {
@@ -46,9 +71,12 @@ func (l *BlazeLoader) LoadPackages(ctx context.Context, targets []*Target, res c
 	}
 
 	cfg := &packages.Config{
-		Dir:     l.dir,
-		Context: ctx,
-		Mode: packages.NeedCompiledGoFiles |
+		Dir:        dir,
+		Context:    ctx,
+		Env:        build.environ(),
+		BuildFlags: build.buildFlags(),
+		Mode: packages.NeedFiles |
+			packages.NeedCompiledGoFiles |
 			packages.NeedSyntax |
 			packages.NeedTypes |
 			packages.NeedTypesInfo,
@@ -83,12 +111,13 @@ LoadedPackage:
 	for _, pkg := range pkgs {
 		t := targetByID[pkg.ID]
 		if t == nil {
-			t = &Target{ID: pkg.ID}
+			t = &Target{ID: pkg.ID, Dir: dir, Module: targets[0].Module, Build: build}
 		}
 		result := &Package{
-			Fileset:  pkg.Fset,
-			TypeInfo: pkg.TypesInfo,
-			TypePkg:  pkg.Types,
+			Fileset:      pkg.Fset,
+			TypeInfo:     pkg.TypesInfo,
+			TypePkg:      pkg.Types,
+			IgnoredFiles: pkg.IgnoredFiles,
 		}
 		for idx, absPath := range pkg.CompiledGoFiles {
 			b, err := os.ReadFile(absPath)
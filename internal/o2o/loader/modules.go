@@ -0,0 +1,273 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Module is a Go module that can be edited, i.e. the main module or one of the
+// modules of a go.work workspace.
+type Module struct {
+	Path string // module path
+	Dir  string // absolute directory containing the go.mod file
+}
+
+// Workspace describes the modules in which packages are resolved and files can
+// be written.
+type Workspace struct {
+	// Modules are the editable modules, sorted by directory.
+	Modules []*Module
+	// Dir is the directory in which patterns are interpreted.
+	Dir string
+
+	modCache string
+}
+
+// LoadWorkspace determines the editable modules for dir: all modules of the
+// go.work workspace if there is one, otherwise the main module. Outside of
+// any module, the workspace consists of dir only.
+func LoadWorkspace(ctx context.Context, dir string) (*Workspace, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	ws := &Workspace{Dir: abs}
+	if out, err := goCmd(ctx, abs, "env", "GOMODCACHE"); err == nil {
+		ws.modCache = strings.TrimSpace(out)
+	}
+
+	out, err := goCmd(ctx, abs, "list", "-m", "-json")
+	if err != nil {
+		// Not in a module (e.g. GOPATH mode or GO111MODULE=off): treat dir as
+		// the only editable location.
+		ws.Modules = []*Module{{Dir: abs}}
+		return ws, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var m struct {
+			Path string
+			Dir  string
+			Main bool
+		}
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't parse 'go list -m -json' output: %v", err)
+		}
+		if !m.Main || m.Dir == "" {
+			continue
+		}
+		ws.Modules = append(ws.Modules, &Module{Path: m.Path, Dir: filepath.Clean(m.Dir)})
+	}
+	if len(ws.Modules) == 0 {
+		return nil, fmt.Errorf("no main module found in %s", abs)
+	}
+	sort.Slice(ws.Modules, func(i, j int) bool { return ws.Modules[i].Dir < ws.Modules[j].Dir })
+	return ws, nil
+}
+
+func goCmd(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out), nil
+}
+
+// within reports whether path is dir or below dir.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ModuleFor returns the editable module containing the file or directory
+// path, or nil if there is none. The module is determined by the closest
+// go.mod file enclosing path: a module nested in the directory of an editable
+// module (e.g. one that is only reachable through a replace directive) is not
+// editable unless it is part of the workspace itself.
+func (w *Workspace) ModuleFor(path string) *Module {
+	var found *Module
+	for _, m := range w.Modules {
+		if within(path, m.Dir) && (found == nil || len(m.Dir) > len(found.Dir)) {
+			found = m
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	for dir := path; dir != found.Dir && within(dir, found.Dir); dir = filepath.Dir(dir) {
+		if fi, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !fi.IsDir() {
+			return nil
+		}
+	}
+	return found
+}
+
+// CheckWritable returns an error if path must not be written because it is not
+// part of an editable module: files in the module cache, in vendor
+// directories and in modules that are only reachable through replace
+// directives.
+func (w *Workspace) CheckWritable(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if w.modCache != "" && within(abs, w.modCache) {
+		return fmt.Errorf("%s is in the module cache", path)
+	}
+	m := w.ModuleFor(abs)
+	if m == nil {
+		return fmt.Errorf("%s is not in an editable module (replaced modules are read-only, add them to go.work to edit them)", path)
+	}
+	rel, _ := filepath.Rel(m.Dir, abs)
+	for _, elem := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if elem == "vendor" {
+			return fmt.Errorf("%s is in a vendor directory", path)
+		}
+	}
+	return nil
+}
+
+// Targets resolves package patterns to targets. Patterns are resolved in the
+// module they refer to, so that patterns can span all modules of the
+// workspace: a directory pattern like ./... matches packages in all
+// modules below the directory, and an import path pattern is resolved in the
+// module whose path it starts with.
+func (w *Workspace) Targets(ctx context.Context, patterns []string) ([]*Target, error) {
+	byModule := make(map[*Module][]string)
+	for _, p := range patterns {
+		for m, mp := range w.split(p) {
+			byModule[m] = append(byModule[m], mp)
+		}
+	}
+
+	var targets []*Target
+	seen := make(map[string]bool)
+	var errs []error
+	for _, m := range w.Modules {
+		mpatterns := byModule[m]
+		if len(mpatterns) == 0 {
+			continue
+		}
+		cfg := &packages.Config{
+			Context: ctx,
+			Dir:     m.Dir,
+			Mode:    packages.NeedName,
+		}
+		loaded, err := packages.Load(cfg, mpatterns...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("module %s: %v", m.name(), err))
+			continue
+		}
+		for _, l := range loaded {
+			if seen[l.ID] {
+				continue
+			}
+			seen[l.ID] = true
+			targets = append(targets, &Target{
+				ID:     l.ID,
+				Dir:    m.Dir,
+				Module: m.Path,
+			})
+		}
+	}
+	return targets, errors.Join(errs...)
+}
+
+func (m *Module) name() string {
+	if m.Path == "" {
+		return m.Dir
+	}
+	return m.Path
+}
+
+// split maps a pattern to the modules in which it needs to be resolved, along
+// with the pattern to use in each module.
+func (w *Workspace) split(pattern string) map[*Module]string {
+	res := make(map[*Module]string)
+	recursive := strings.HasSuffix(pattern, "/...") || pattern == "..."
+	if pattern == "." || pattern == ".." || pattern == "..." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") || filepath.IsAbs(pattern) {
+		root := strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+		if root == "" {
+			root = "."
+		}
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(w.Dir, root)
+		}
+		if m := w.ModuleFor(root); m != nil {
+			rel, _ := filepath.Rel(m.Dir, root)
+			mp := "./" + filepath.ToSlash(rel)
+			if recursive {
+				mp += "/..."
+			}
+			res[m] = mp
+		}
+		if recursive {
+			// The go command does not descend into nested modules, so
+			// resolve the pattern separately in each of them.
+			for _, m := range w.Modules {
+				if m.Dir != root && within(m.Dir, root) {
+					res[m] = "./..."
+				}
+			}
+		}
+		if len(res) == 0 {
+			res[w.Modules[0]] = pattern
+		}
+		return res
+	}
+
+	// Import path pattern.
+	prefix := strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+	var owner *Module
+	for _, m := range w.Modules {
+		if m.Path == "" {
+			continue
+		}
+		if prefix == m.Path || strings.HasPrefix(prefix, m.Path+"/") {
+			if owner == nil || len(m.Path) > len(owner.Path) {
+				owner = m
+			}
+		}
+	}
+	if owner != nil {
+		res[owner] = pattern
+	}
+	if recursive {
+		for _, m := range w.Modules {
+			if m != owner && m.Path != "" && (prefix == "" || strings.HasPrefix(m.Path, prefix+"/")) {
+				res[m] = m.Path + "/..."
+			}
+		}
+	}
+	if len(res) == 0 {
+		// Standard library or a dependency: resolve in the module of the
+		// working directory.
+		m := w.ModuleFor(w.Dir)
+		if m == nil {
+			m = w.Modules[0]
+		}
+		res[m] = pattern
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loader_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	// The workspace mode rejects -mod=mod, which some environments set.
+	t.Setenv("GOFLAGS", "")
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{
+		"go.work":           "go 1.22\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod":          "module example.com/a\n\ngo 1.22\n\nrequire example.com/x v0.0.0\n\nreplace example.com/x => ./third_party/x\n",
+		"a/a.go":            "package a\n",
+		"a/sub/sub.go":      "package sub\n",
+		"b/go.mod":          "module example.com/b\n\ngo 1.22\n",
+		"b/b.go":            "package b\n",
+		"b/vendor/v/v.go":   "package v\n",
+		"other/replaced.go": "package replaced\n",
+		// A module inside of a workspace module that is only used through
+		// a replace directive.
+		"a/third_party/x/go.mod": "module example.com/x\n\ngo 1.22\n",
+		"a/third_party/x/x.go":   "package x\n",
+	})
+	ctx := context.Background()
+	ws, err := loader.LoadWorkspace(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mods []string
+	for _, m := range ws.Modules {
+		mods = append(mods, m.Path)
+	}
+	if want := []string{"example.com/a", "example.com/b"}; !cmp.Equal(mods, want) {
+		t.Fatalf("LoadWorkspace() modules = %v, want %v", mods, want)
+	}
+
+	type target struct{ ID, Dir, Module string }
+	for _, tc := range []struct {
+		patterns []string
+		want     []target
+	}{
+		{
+			patterns: []string{"./..."},
+			want: []target{
+				{"example.com/a", filepath.Join(root, "a"), "example.com/a"},
+				{"example.com/a/sub", filepath.Join(root, "a"), "example.com/a"},
+				{"example.com/b", filepath.Join(root, "b"), "example.com/b"},
+			},
+		},
+		{
+			patterns: []string{"./a/sub", "example.com/b/..."},
+			want: []target{
+				{"example.com/a/sub", filepath.Join(root, "a"), "example.com/a"},
+				{"example.com/b", filepath.Join(root, "b"), "example.com/b"},
+			},
+		},
+	} {
+		targets, err := ws.Targets(ctx, tc.patterns)
+		if err != nil {
+			t.Fatalf("Targets(%v): %v", tc.patterns, err)
+		}
+		var got []target
+		for _, tt := range targets {
+			got = append(got, target{tt.ID, tt.Dir, tt.Module})
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Targets(%v) returned unexpected targets (-want +got):\n%s", tc.patterns, diff)
+		}
+	}
+
+	for _, tc := range []struct {
+		path     string
+		writable bool
+	}{
+		{"a/a.go", true},
+		{"b/b.go", true},
+		{"b/vendor/v/v.go", false},
+		{"other/replaced.go", false},
+		{"a/third_party/x/x.go", false},
+		{"a/third_party/x", false},
+	} {
+		err := ws.CheckWritable(filepath.Join(root, tc.path))
+		if got := err == nil; got != tc.writable {
+			t.Errorf("CheckWritable(%q) = %v, want writable: %v", tc.path, err, tc.writable)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package migrate implements the migrate open2opaque subcommand, which drives
+// all phases of a migration to the Opaque API: setting the Hybrid API,
+// rewriting, setting the Opaque API, and checking the build in between.
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"flag"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/rewrite"
+	"google.golang.org/open2opaque/internal/o2o/setapi"
+)
+
+const defaultStateFile = "open2opaque-migrate.json"
+
+// Cmd implements the migrate subcommand of the open2opaque tool.
+type Cmd struct {
+	stateFile   string
+	goPackages  string
+	levels      string
+	setapiFlags []string
+	journalDir  string
+	reviewed    bool
+	reset       bool
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "migrate" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "Migrate proto files and Go packages to the Opaque API, phase by phase."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque migrate -regenerate=<generator> [-packages=<patterns>] [-levels=<levels>] <proto targets>
+       open2opaque migrate [-reviewed]
+
+The migrate subcommand drives a migration to the Opaque API through these
+phases:
+
+  hybrid        setapi -api=HYBRID for the proto targets, regenerating the Go
+                code (see setapi -regenerate)
+  check_hybrid  go build and go vet of the Go packages
+  rewrite       rewrite of the Go packages (-levels)
+  review        manual work: resolve the DO NOT SUBMIT markers left by the
+                rewrite and review red rewrites; the Go packages must build
+  opaque        setapi -api=OPAQUE for the proto targets, regenerating the Go
+                code
+  check_opaque  go build and go vet of the Go packages
+
+The proto targets are setapi inputs: .proto files, directories, globs, proto
+packages or messages. The progress is recorded in the state file (-state_file).
+Whenever a phase fails or needs manual work, migrate stops with instructions;
+run open2opaque migrate again (without arguments) to resume at that phase.
+When the rewrite used the red level, resume with -reviewed after reviewing its
+changes. The configuration is stored in the state file when the migration
+starts; use -reset to start over.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.stateFile, "state_file", defaultStateFile, "file in which the progress of the migration is recorded")
+	f.StringVar(&cmd.goPackages, "packages", "./...", "comma-separated list of Go package patterns to rewrite, build and vet")
+	f.StringVar(&cmd.levels, "levels", "red", "rewrite levels, see rewrite -levels")
+	for _, name := range []string{"regenerate", "proto_path", "buf_yaml", "buf_gen_yaml", "go_opt", "exclude"} {
+		f.Func(name, "passed to setapi, see setapi -"+name+" (can be repeated)", func(s string) error {
+			cmd.setapiFlags = append(cmd.setapiFlags, "-"+name+"="+s)
+			return nil
+		})
+	}
+	f.StringVar(&cmd.journalDir, "journal_dir", "", "passed to setapi and rewrite, see rewrite -journal_dir")
+	f.BoolVar(&cmd.reviewed, "reviewed", false, "confirm that the changes of red rewrites were reviewed")
+	f.BoolVar(&cmd.reset, "reset", false, "discard the state file and start a new migration")
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.migrate(ctx, f.Args()); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in migrate.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[migrate] "+format+"\n", a...) }
+
+func (cmd *Cmd) migrate(ctx context.Context, targets []string) error {
+	st, err := loadState(cmd.stateFile)
+	if err != nil {
+		return err
+	}
+	if st != nil && cmd.reset {
+		st = nil
+	}
+	switch {
+	case st == nil && len(targets) == 0:
+		return fmt.Errorf("missing proto targets, pass proto file name(s), directories, globs, package(s) or message(s) as non-flag arguments to start a migration")
+	case st == nil:
+		if !slices.ContainsFunc(cmd.setapiFlags, func(s string) bool { return strings.HasPrefix(s, "-regenerate=") }) {
+			return fmt.Errorf("missing -regenerate: migrate needs to regenerate the Go code after changing the API level")
+		}
+		st = &State{
+			ProtoTargets: targets,
+			GoPackages:   strings.Split(cmd.goPackages, ","),
+			Levels:       cmd.levels,
+			SetapiFlags:  cmd.setapiFlags,
+			JournalDir:   cmd.journalDir,
+			Phase:        PhaseHybrid,
+		}
+		logf("Starting the migration of %s (state file %s)", strings.Join(targets, " "), cmd.stateFile)
+	case len(targets) > 0 && !slices.Equal(targets, st.ProtoTargets):
+		return fmt.Errorf("the migration of %s is in progress (state file %s); run open2opaque migrate without arguments to resume it, or pass -reset to start over", strings.Join(st.ProtoTargets, " "), cmd.stateFile)
+	default:
+		logf("Resuming the migration of %s at phase %s", strings.Join(st.ProtoTargets, " "), st.Phase)
+	}
+	if cmd.reviewed {
+		if st.Phase != PhaseReview {
+			return fmt.Errorf("-reviewed confirms the review of the rewritten code, but the migration is in phase %s, not %s", st.Phase, PhaseReview)
+		}
+		st.Reviewed = true
+	}
+	if err := st.save(cmd.stateFile); err != nil {
+		return err
+	}
+
+	for st.Phase != PhaseDone {
+		p := st.Phase
+		logf("Phase %s", p)
+		err := cmd.runPhase(ctx, st, p)
+		st.record(p, err)
+		if err == nil {
+			st.Phase = p.next()
+		}
+		if serr := st.save(cmd.stateFile); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return fmt.Errorf("migration stopped in phase %s: %v\n\nThen run %s to resume.", p, err, cmd.resumeCommand())
+		}
+	}
+	logf("Migration complete: %s use the Opaque API and the Go packages build.", strings.Join(st.ProtoTargets, " "))
+	return nil
+}
+
+// resumeCommand returns the command line that resumes the migration.
+func (cmd *Cmd) resumeCommand() string {
+	if cmd.stateFile != defaultStateFile {
+		return "open2opaque migrate -state_file=" + cmd.stateFile
+	}
+	return "open2opaque migrate"
+}
+
+// runPhase runs phase p. The returned error explains what the user needs to do
+// before resuming.
+func (cmd *Cmd) runPhase(ctx context.Context, st *State, p Phase) error {
+	switch p {
+	case PhaseHybrid:
+		if err := st.setapi(ctx, "HYBRID"); err != nil {
+			return fmt.Errorf("%v\n\nFix the problem reported above.", err)
+		}
+	case PhaseCheckHybrid:
+		if err := checkBuild(ctx, st.GoPackages); err != nil {
+			return fmt.Errorf("%v\n\nThe Go packages must build before they can be rewritten: fix the build errors reported above.", err)
+		}
+	case PhaseRewrite:
+		// A review confirms the changes of an earlier rewrite, not of this one.
+		st.Reviewed = false
+		args := []string{"-levels=" + st.Levels, "-http=localhost:0"}
+		if st.JournalDir != "" {
+			args = append(args, "-journal_dir="+st.JournalDir)
+		}
+		start := time.Now()
+		err := runSubcommand(ctx, rewrite.Command(), append(args, st.GoPackages...)...)
+		// A failed rewrite may have written files, too.
+		if jerr := st.addRewrittenFiles(start); jerr != nil {
+			return fmt.Errorf("can't determine the rewritten files from the journal: %v", jerr)
+		}
+		if err != nil {
+			return fmt.Errorf("%v\n\nFix the packages that could not be rewritten (see above).", err)
+		}
+	case PhaseReview:
+		markers, err := findMarkers(st.RewrittenFiles)
+		if err != nil {
+			return err
+		}
+		if len(markers) > 0 {
+			return fmt.Errorf("%d DO NOT SUBMIT markers need manual work:\n\t%s\n\nMigrate the marked code by hand and remove the markers.", len(markers), strings.Join(markers, "\n\t"))
+		}
+		if slices.Contains(strings.Split(st.Levels, ","), "red") && !st.Reviewed {
+			return fmt.Errorf("the rewrite used the red level, whose rewrites can change the behavior of the code\n\nReview the changes of the rewrite (e.g. with git diff), then pass -reviewed.")
+		}
+		if err := checkBuild(ctx, st.GoPackages); err != nil {
+			return fmt.Errorf("%v\n\nFix the build errors reported above.", err)
+		}
+	case PhaseOpaque:
+		if err := st.setapi(ctx, "OPAQUE"); err != nil {
+			return fmt.Errorf("%v\n\nFix the problem reported above.", err)
+		}
+	case PhaseCheckOpaque:
+		if err := checkBuild(ctx, st.GoPackages); err != nil {
+			return fmt.Errorf("%v\n\nThe code still uses the Open API where the build fails: migrate it by hand (or with open2opaque rewrite).", err)
+		}
+	default:
+		return fmt.Errorf("BUG: unhandled phase %q", p)
+	}
+	return nil
+}
+
+// setapi sets the API level of the proto targets.
+func (st *State) setapi(ctx context.Context, api string) error {
+	args := append([]string{"-api=" + api}, st.SetapiFlags...)
+	if st.JournalDir != "" {
+		args = append(args, "-journal_dir="+st.JournalDir)
+	}
+	return runSubcommand(ctx, setapi.Command(), append(args, st.ProtoTargets...)...)
+}
+
+// runSubcommand runs an open2opaque subcommand in-process.
+var runSubcommand = func(ctx context.Context, c subcommands.Command, args ...string) error {
+	f := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	c.SetFlags(f)
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	logf("Running open2opaque %s %s", c.Name(), strings.Join(args, " "))
+	if status := c.Execute(ctx, f); status != subcommands.ExitSuccess {
+		return fmt.Errorf("open2opaque %s failed", c.Name())
+	}
+	return nil
+}
+
+// checkBuild runs go build and go vet for the Go packages.
+func checkBuild(ctx context.Context, pkgs []string) error {
+	for _, sub := range []string{"build", "vet"} {
+		if err := goCommand(ctx, append([]string{sub}, pkgs...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goCommand runs the go tool.
+var goCommand = func(ctx context.Context, args ...string) error {
+	logf("Running go %s", strings.Join(args, " "))
+	c := exec.CommandContext(ctx, "go", args...)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("go %s failed: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// findMarkers returns the markers (as file:line: text) that rewrite left in
+// the files it wrote. Files that were removed since are skipped.
+var findMarkers = func(files []string) ([]string, error) {
+	var markers []string
+	for _, fname := range files {
+		m, err := fileMarkers(fname)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		markers = append(markers, m...)
+	}
+	return markers, nil
+}
+
+// markerRe matches the markers that rewrite adds: "// DO NOT SUBMIT: <reason>"
+// comments above the code, and "/* DO_NOT_SUBMIT: missing rewrite for <what>
+// */" comments next to it. Other DO NOT SUBMIT comments are not rewrite's.
+var markerRe = regexp.MustCompile(`// DO NOT SUBMIT: |/\* DO_NOT_SUBMIT: missing rewrite for `)
+
+// fileMarkers returns the markers of rewrite in the file.
+func fileMarkers(fname string) ([]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var markers []string
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if text := s.Text(); markerRe.MatchString(text) {
+			markers = append(markers, fmt.Sprintf("%s:%d: %s", fname, line, strings.TrimSpace(text)))
+		}
+	}
+	return markers, s.Err()
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+)
+
+func TestMigrateResumes(t *testing.T) {
+	var calls []string
+	var markers []string
+	failBuild := false
+	origRunSubcommand, origGoCommand, origFindMarkers := runSubcommand, goCommand, findMarkers
+	defer func() {
+		runSubcommand, goCommand, findMarkers = origRunSubcommand, origGoCommand, origFindMarkers
+	}()
+	runSubcommand = func(_ context.Context, c subcommands.Command, args ...string) error {
+		calls = append(calls, c.Name()+" "+strings.Join(args, " "))
+		return nil
+	}
+	goCommand = func(_ context.Context, args ...string) error {
+		calls = append(calls, "go "+strings.Join(args, " "))
+		if failBuild && args[0] == "build" {
+			return errors.New("go build failed")
+		}
+		return nil
+	}
+	findMarkers = func([]string) ([]string, error) {
+		return markers, nil
+	}
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+	journalDir := filepath.Join(dir, "journal")
+	newCmd := func(reviewed bool) *Cmd {
+		return &Cmd{
+			stateFile:   stateFile,
+			goPackages:  "./foo/...",
+			levels:      "red",
+			setapiFlags: []string{"-regenerate=buf"},
+			journalDir:  journalDir,
+			reviewed:    reviewed,
+		}
+	}
+	run := func(cmd *Cmd, targets ...string) (Phase, error) {
+		t.Helper()
+		calls = nil
+		err := cmd.migrate(context.Background(), targets)
+		st, lerr := loadState(stateFile)
+		if lerr != nil {
+			t.Fatal(lerr)
+		}
+		return st.Phase, err
+	}
+
+	// The build fails with the Hybrid API.
+	failBuild = true
+	phase, err := run(newCmd(false), "foo/")
+	if err == nil || phase != PhaseCheckHybrid {
+		t.Fatalf("migrate() = %v in phase %s, want error in phase %s", err, phase, PhaseCheckHybrid)
+	}
+	want := []string{
+		"setapi -api=HYBRID -regenerate=buf -journal_dir=" + journalDir + " foo/",
+		"go build ./foo/...",
+	}
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Errorf("calls diff (-want +got):\n%s", diff)
+	}
+
+	// Other targets can't be passed while the migration is in progress.
+	if _, err := run(newCmd(false), "bar/"); err == nil {
+		t.Errorf("migrate(bar/) succeeded during the migration of foo/, want error")
+	}
+
+	// -reviewed is only accepted in the review phase.
+	if _, err := run(newCmd(true)); err == nil || !strings.Contains(err.Error(), "-reviewed") {
+		t.Errorf("migrate(-reviewed) in phase %s = %v, want error mentioning -reviewed", PhaseCheckHybrid, err)
+	}
+
+	// After fixing the build, the rewrite leaves markers.
+	failBuild = false
+	markers = []string{"foo/x.go:3: // DO NOT SUBMIT: ..."}
+	phase, err = run(newCmd(false))
+	if err == nil || phase != PhaseReview || !strings.Contains(err.Error(), markers[0]) {
+		t.Fatalf("migrate() = %v in phase %s, want error listing the markers in phase %s", err, phase, PhaseReview)
+	}
+	want = []string{
+		"go build ./foo/...",
+		"go vet ./foo/...",
+		"rewrite -levels=red -http=localhost:0 -journal_dir=" + journalDir + " ./foo/...",
+	}
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Errorf("calls diff (-want +got):\n%s", diff)
+	}
+
+	// The red rewrites need to be reviewed.
+	markers = nil
+	phase, err = run(newCmd(false))
+	if err == nil || phase != PhaseReview || !strings.Contains(err.Error(), "-reviewed") {
+		t.Fatalf("migrate() = %v in phase %s, want error asking for a review in phase %s", err, phase, PhaseReview)
+	}
+
+	phase, err = run(newCmd(true))
+	if err != nil || phase != PhaseDone {
+		t.Fatalf("migrate(-reviewed) = %v in phase %s, want success in phase %s", err, phase, PhaseDone)
+	}
+	want = []string{
+		"go build ./foo/...",
+		"go vet ./foo/...",
+		"setapi -api=OPAQUE -regenerate=buf -journal_dir=" + journalDir + " foo/",
+		"go build ./foo/...",
+		"go vet ./foo/...",
+	}
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Errorf("calls diff (-want +got):\n%s", diff)
+	}
+
+	st, err := loadState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(st.History), 9; got != want {
+		t.Errorf("len(History) = %d, want %d", got, want)
+	}
+}
+
+func TestRewriteResetsReview(t *testing.T) {
+	origRunSubcommand := runSubcommand
+	defer func() { runSubcommand = origRunSubcommand }()
+	runSubcommand = func(context.Context, subcommands.Command, ...string) error {
+		return errors.New("rewrite failed")
+	}
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+	st := &State{ProtoTargets: []string{"foo/"}, GoPackages: []string{"./foo/..."}, Levels: "red", JournalDir: filepath.Join(dir, "journal"), Phase: PhaseRewrite, Reviewed: true}
+	if err := st.save(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &Cmd{stateFile: stateFile}
+	if err := cmd.migrate(context.Background(), nil); err == nil {
+		t.Fatalf("migrate() succeeded, want the rewrite error")
+	}
+	st, err := loadState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Reviewed {
+		t.Errorf("Reviewed = true after running the rewrite phase again, want false")
+	}
+}
+
+func TestMigrateRequiresRegenerate(t *testing.T) {
+	cmd := &Cmd{stateFile: filepath.Join(t.TempDir(), "state.json"), goPackages: "./...", levels: "yellow"}
+	if err := cmd.migrate(context.Background(), []string{"foo/"}); err == nil || !strings.Contains(err.Error(), "-regenerate") {
+		t.Errorf("migrate() without -regenerate = %v, want error mentioning -regenerate", err)
+	}
+	if _, err := os.Stat(cmd.stateFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state file was written for a failed start (stat: %v)", err)
+	}
+}
+
+func TestFileMarkers(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "x.go")
+	src := "package x\n\n// DO NOT SUBMIT: fix callers\nvar _ = 1 /* DO_NOT_SUBMIT: missing rewrite for X */\n// DO NOT SUBMIT until the launch\n"
+	if err := os.WriteFile(fname, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileMarkers(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fname + ":3: // DO NOT SUBMIT: fix callers",
+		fname + ":4: var _ = 1 /* DO_NOT_SUBMIT: missing rewrite for X */",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fileMarkers() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestReviewScansRewrittenFiles(t *testing.T) {
+	origRunSubcommand, origGoCommand := runSubcommand, goCommand
+	defer func() { runSubcommand, goCommand = origRunSubcommand, origGoCommand }()
+	dir := t.TempDir()
+	journalDir := filepath.Join(dir, "journal")
+	// A file with a build tag, which is rewritten, and a file with a marker
+	// that was there before the migration, which is not.
+	tagged := filepath.Join(dir, "tagged.go")
+	untouched := filepath.Join(dir, "untouched.go")
+	if err := os.WriteFile(untouched, []byte("package x\n\n// DO NOT SUBMIT: unrelated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runSubcommand = func(_ context.Context, c subcommands.Command, args ...string) error {
+		if c.Name() != "rewrite" {
+			return nil
+		}
+		j, err := journal.New(journalDir, "rewrite")
+		if err != nil {
+			return err
+		}
+		if err := j.WriteFile(tagged, []byte("//go:build integration\n\npackage x\n\n// DO NOT SUBMIT: shallow copy\n"), 0644); err != nil {
+			return err
+		}
+		return j.Close()
+	}
+	goCommand = func(context.Context, ...string) error { return nil }
+
+	stateFile := filepath.Join(dir, "state.json")
+	st := &State{ProtoTargets: []string{"foo/"}, GoPackages: []string{"./..."}, Levels: "yellow", JournalDir: journalDir, Phase: PhaseRewrite}
+	if err := st.save(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &Cmd{stateFile: stateFile}
+	err := cmd.migrate(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), tagged+":5:") {
+		t.Fatalf("migrate() = %v, want error listing the marker in %s", err, tagged)
+	}
+	if strings.Contains(err.Error(), untouched) {
+		t.Errorf("migrate() = %v, want no marker of %s, which was not rewritten", err, untouched)
+	}
+	if st, err = loadState(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{tagged}; !cmp.Equal(st.RewrittenFiles, want) {
+		t.Errorf("RewrittenFiles = %v, want %v", st.RewrittenFiles, want)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"google.golang.org/open2opaque/internal/o2o/journal"
+)
+
+// Phase is a step of a migration.
+type Phase string
+
+// The phases of a migration, in order.
+const (
+	// PhaseHybrid sets the proto targets to the Hybrid API and regenerates
+	// their Go code.
+	PhaseHybrid Phase = "hybrid"
+	// PhaseCheckHybrid builds and vets the Go packages with the Hybrid API.
+	PhaseCheckHybrid Phase = "check_hybrid"
+	// PhaseRewrite rewrites the Go packages to the Opaque API.
+	PhaseRewrite Phase = "rewrite"
+	// PhaseReview waits for manual work: DO NOT SUBMIT markers left by the
+	// rewrite must be resolved and red rewrites must be reviewed.
+	PhaseReview Phase = "review"
+	// PhaseOpaque sets the proto targets to the Opaque API and regenerates
+	// their Go code.
+	PhaseOpaque Phase = "opaque"
+	// PhaseCheckOpaque builds and vets the Go packages with the Opaque API.
+	PhaseCheckOpaque Phase = "check_opaque"
+	// PhaseDone means that the migration is complete.
+	PhaseDone Phase = "done"
+)
+
+var phases = []Phase{PhaseHybrid, PhaseCheckHybrid, PhaseRewrite, PhaseReview, PhaseOpaque, PhaseCheckOpaque, PhaseDone}
+
+// next returns the phase following p.
+func (p Phase) next() Phase {
+	i := slices.Index(phases, p)
+	if i < 0 || i == len(phases)-1 {
+		return PhaseDone
+	}
+	return phases[i+1]
+}
+
+// State is the progress of a migration, which is persisted in the state file
+// so that open2opaque migrate can resume after failures and manual steps.
+type State struct {
+	// ProtoTargets are the inputs of setapi: .proto files, directories, globs,
+	// proto packages or messages.
+	ProtoTargets []string `json:"proto_targets"`
+	// GoPackages are the Go package patterns that are rewritten and checked.
+	GoPackages []string `json:"go_packages"`
+	// Levels are the rewrite levels, as passed to rewrite -levels.
+	Levels string `json:"levels"`
+	// SetapiFlags are passed to every setapi run, e.g. -regenerate.
+	SetapiFlags []string `json:"setapi_flags,omitempty"`
+	// JournalDir is passed to setapi and rewrite as -journal_dir.
+	JournalDir string `json:"journal_dir,omitempty"`
+	// Phase is the next phase to run.
+	Phase Phase `json:"phase"`
+	// Reviewed is set once the user confirmed the review of the red rewrites.
+	Reviewed bool `json:"reviewed,omitempty"`
+	// RewrittenFiles are the absolute paths of the files written by the
+	// rewrite phase, as journaled. The review phase checks them for markers.
+	RewrittenFiles []string `json:"rewritten_files,omitempty"`
+	// History records the outcome of every phase run.
+	History []*Event `json:"history,omitempty"`
+}
+
+// Event is the outcome of running a phase.
+type Event struct {
+	Phase Phase     `json:"phase"`
+	Time  time.Time `json:"time"`
+	// Error is empty if the phase completed.
+	Error string `json:"error,omitempty"`
+}
+
+// loadState reads the state file, returning nil if it does not exist.
+func loadState(path string) (*State, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("parsing the state file %s: %v", path, err)
+	}
+	if !slices.Contains(phases, st.Phase) {
+		return nil, fmt.Errorf("state file %s: unknown phase %q", path, st.Phase)
+	}
+	return st, nil
+}
+
+// save writes the state file.
+func (st *State) save(path string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return journal.WriteFileAtomic(path, append(b, '\n'), 0644)
+}
+
+// addRewrittenFiles adds the files written by the rewrite runs that were
+// journaled below the journal directory since start.
+func (st *State) addRewrittenFiles(start time.Time) error {
+	root, err := journal.ResolveDir(st.JournalDir)
+	if err != nil {
+		return err
+	}
+	runs, err := journal.List(root)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if run.Command != "rewrite" || run.Undone || run.Created.Before(start) {
+			continue
+		}
+		for _, e := range run.Entries {
+			if !slices.Contains(st.RewrittenFiles, e.Path) {
+				st.RewrittenFiles = append(st.RewrittenFiles, e.Path)
+			}
+		}
+	}
+	slices.Sort(st.RewrittenFiles)
+	return nil
+}
+
+// record appends the outcome of running phase p to the history.
+func (st *State) record(p Phase, err error) {
+	ev := &Event{Phase: p, Time: time.Now()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	st.History = append(st.History, ev)
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package protofiles finds .proto files given as command-line arguments.
+package protofiles
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IsPattern reports whether arg is a directory or a glob pattern, as opposed
+// to a file name or a proto name.
+func IsPattern(arg string) bool {
+	if strings.ContainsAny(arg, "*?[") {
+		return true
+	}
+	fi, err := os.Stat(arg)
+	return err == nil && fi.IsDir()
+}
+
+// Find returns the .proto files named by paths, which are .proto files,
+// directories or glob patterns, minus the files matching any of the exclude
+// patterns. Directories are searched recursively, skipping hidden
+// directories. In patterns, * and ? match within a path element and **
+// matches any number of path elements; an exclude pattern that matches a
+// directory excludes everything below it. The result is sorted and contains
+// each file once.
+func Find(paths, excludes []string) ([]string, error) {
+	excludeRes, err := compile(excludes)
+	if err != nil {
+		return nil, err
+	}
+	excluded := func(path string) bool { return matchAny(excludeRes, path) }
+
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) {
+		if !seen[path] && !excluded(path) {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	walk := func(root string, match func(string) bool) error {
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != root && (strings.HasPrefix(d.Name(), ".") || excluded(p)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".proto") && match(p) {
+				add(p)
+			}
+			return nil
+		})
+	}
+
+	for _, path := range paths {
+		if strings.ContainsAny(path, "*?[") {
+			re, err := globToRegexp(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", path, err)
+			}
+			n := len(files)
+			match := func(p string) bool { return re.MatchString(filepath.ToSlash(p)) }
+			if err := walk(globRoot(path), match); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if len(files) == n {
+				return nil, fmt.Errorf("pattern %q matches no .proto files", path)
+			}
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			if !strings.HasSuffix(path, ".proto") {
+				return nil, fmt.Errorf("%s is neither a .proto file nor a directory", path)
+			}
+			add(filepath.Clean(path))
+			continue
+		}
+		if err := walk(path, func(string) bool { return true }); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Excluded reports whether path matches any of the exclude patterns.
+func Excluded(path string, excludes []string) (bool, error) {
+	res, err := compile(excludes)
+	if err != nil {
+		return false, err
+	}
+	return matchAny(res, path), nil
+}
+
+func compile(excludes []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, e := range excludes {
+		re, err := globToRegexp(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", e, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchAny(res []*regexp.Regexp, path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, re := range res {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRoot returns the directory part of pattern before the first element
+// that contains a meta character.
+func globRoot(pattern string) string {
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	var root []string
+	for _, e := range elems {
+		if strings.ContainsAny(e, "*?[") {
+			break
+		}
+		root = append(root, e)
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	if len(root) == 1 && root[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(root, "/"))
+}
+
+// globToRegexp translates a glob pattern to a regular expression matching
+// cleaned, slash-separated paths. A pattern also matches everything below the
+// paths it matches.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = filepath.ToSlash(pattern)
+	if pattern != "./" {
+		pattern = strings.TrimPrefix(pattern, "./")
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(?:/.*)?$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protofiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a/a.proto",
+		"a/b/b.proto",
+		"a/b/c/c.proto",
+		"a/notes.txt",
+		"third_party/x/x.proto",
+		".git/ignored.proto",
+		"z.proto",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tc := range []struct {
+		paths    []string
+		excludes []string
+		want     []string
+	}{
+		{
+			paths: []string{"."},
+			want:  []string{"a/a.proto", "a/b/b.proto", "a/b/c/c.proto", "third_party/x/x.proto", "z.proto"},
+		},
+		{
+			paths:    []string{"."},
+			excludes: []string{"third_party/**", "a/b"},
+			want:     []string{"a/a.proto", "z.proto"},
+		},
+		{
+			paths: []string{"a/*.proto", "z.proto", "./z.proto"},
+			want:  []string{"a/a.proto", "z.proto"},
+		},
+		{
+			paths:    []string{"**/c/*.proto", "a/**/b.proto"},
+			excludes: []string{"**/x"},
+			want:     []string{"a/b/b.proto", "a/b/c/c.proto"},
+		},
+	} {
+		got, err := Find(tc.paths, tc.excludes)
+		if err != nil {
+			t.Errorf("Find(%q, %q): %v", tc.paths, tc.excludes, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Find(%q, %q) returned unexpected files (-want +got):\n%s", tc.paths, tc.excludes, diff)
+		}
+	}
+
+	for _, paths := range [][]string{{"missing/*.proto"}, {"a/notes.txt"}, {"nonexistent.proto"}} {
+		if _, err := Find(paths, nil); err == nil {
+			t.Errorf("Find(%q) succeeded, want error", paths)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package readiness implements the readiness open2opaque subcommand, which
+// reports the .proto files that can be switched to the Opaque API because no
+// Go code needs open struct access to their messages anymore.
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flag"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/usage"
+)
+
+// Cmd implements the readiness subcommand of the open2opaque tool.
+type Cmd struct {
+	maxSites     int
+	buildConfigs string
+	protoPaths   []string
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "readiness" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "Report which .proto files are ready for the Opaque API."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque readiness [-max_sites=<n>] [-proto_path=<dir>] <Go package pattern> [...]
+
+The readiness subcommand analyzes the Go packages (typically all packages of
+the repository, e.g. ./...) and reports for each .proto file whose messages the
+packages use whether any Go code still needs open struct access to them: direct
+or internal field accesses, shallow copies, embedding and reflection. A .proto
+file can be switched from the Hybrid to the Opaque API once none of its
+messages has such blocking uses. For the other files, the blocking call sites
+are listed. The report ends with the setapi invocation that switches the ready
+files to the Opaque API.
+
+The .proto file of a message is taken from the header of its generated .pb.go
+file; the file name is relative to the include root that protoc was invoked
+with. Pass that root as -proto_path, so that the printed setapi invocation
+finds the files. Only .proto files whose Go packages are generated in an editable module
+of the workspace are reported; dependencies, e.g. the well-known types, are
+left out.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&cmd.maxSites, "max_sites", 10, "maximum number of blocking call sites listed per message; negative means all")
+	f.StringVar(&cmd.buildConfigs, "build_configs", "", "build configurations in which packages are loaded, see rewrite -build_configs")
+	f.Func("proto_path", "include root relative to which the .proto file names of the generated code are interpreted, passed on to the printed setapi invocation (can be repeated, or a list separated by '"+string(os.PathListSeparator)+"'); default is the current directory", func(s string) error {
+		cmd.protoPaths = append(cmd.protoPaths, filepath.SplitList(s)...)
+		return nil
+	})
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.readiness(ctx, f); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in readiness.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[readiness] "+format+"\n", a...) }
+
+func (cmd *Cmd) readiness(ctx context.Context, f *flag.FlagSet) error {
+	if f.NArg() == 0 {
+		return fmt.Errorf("missing Go package patterns, e.g. ./...")
+	}
+	res, err := usage.Analyze(ctx, f.Args(), cmd.buildConfigs, logf)
+	if err != nil {
+		return err
+	}
+	messages := make([]Message, 0, len(res.Messages))
+	for _, m := range res.Messages {
+		messages = append(messages, Message{
+			GoType:    m.GoType(),
+			ProtoFile: m.ProtoFile,
+			API:       m.API,
+			Editable:  m.Editable,
+		})
+	}
+	roots := cmd.protoPaths
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	r := NewReport(messages, res.Entries)
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		r.ProtoPaths = append(r.ProtoPaths, abs)
+	}
+	r.Write(os.Stdout, cmd.maxSites)
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d packages could not be analyzed, the report misses their uses:\n\t%s", len(res.Failed), strings.Join(res.Failed, "\n\t"))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readiness
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	statspb "google.golang.org/open2opaque/internal/dashboard"
+	"google.golang.org/open2opaque/internal/o2o/usage"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+)
+
+// Message is a message type generated by protoc-gen-go.
+type Message struct {
+	// GoType is the qualified Go type name, e.g. example.com/foopb.M.
+	GoType string
+	// ProtoFile is the .proto file from which the type was generated, as
+	// recorded in the generated code. Empty if unknown.
+	ProtoFile string
+	// API is the API level of the generated type.
+	API protodetecttypes.MessageAPI
+	// Editable reports whether the generated package is in an editable module
+	// of the workspace. Messages of dependencies are not reported.
+	Editable bool
+}
+
+// goPackage returns the Go import path of the message type.
+func (m *Message) goPackage() string {
+	pkg, _ := usage.SplitGoType(m.GoType)
+	return pkg
+}
+
+// Site is a blocking use of a message.
+type Site struct {
+	Use statspb.Use_Type
+	// Position is file:line:column of the use.
+	Position string
+}
+
+// MessageReport lists the blocking uses of a message.
+type MessageReport struct {
+	Message
+	Blockers []Site
+}
+
+// FileReport lists the messages generated from a .proto file.
+type FileReport struct {
+	// Name is the .proto file or, if unknown, a description of the Go package
+	// containing the messages.
+	Name     string
+	Known    bool // whether Name is a .proto file
+	Messages []*MessageReport
+}
+
+// blockers returns the number of blocking uses of the messages in the file.
+func (f *FileReport) blockers() int {
+	n := 0
+	for _, m := range f.Messages {
+		n += len(m.Blockers)
+	}
+	return n
+}
+
+// Report is the readiness of .proto files for the Opaque API.
+type Report struct {
+	// Ready are the files without blocking uses, some of whose messages do
+	// not use the Opaque API yet.
+	Ready []*FileReport
+	// Blocked are the files with blocking uses.
+	Blocked []*FileReport
+	// Opaque are the files whose messages all use the Opaque API.
+	Opaque []*FileReport
+	// ProtoPaths are the include roots relative to which the .proto file
+	// names are interpreted. They are passed on to setapi.
+	ProtoPaths []string
+}
+
+// NewReport combines the usage entries of the stats with the known message
+// types. Only editable messages are reported: the .proto files of dependencies
+// can't be changed. Entries of types that are not among messages are
+// attributed to messages of unknown .proto files; entries in generated files
+// are ignored.
+func NewReport(messages []Message, entries []*statspb.Entry) *Report {
+	byType := make(map[string]*MessageReport)
+	external := make(map[string]bool)
+	for _, m := range messages {
+		if !m.Editable {
+			external[m.GoType] = true
+			continue
+		}
+		byType[m.GoType] = &MessageReport{Message: m}
+	}
+	for _, e := range entries {
+		if !usage.BlockingUses[e.GetUse().GetType()] || e.GetLocation().GetIsGeneratedFile() {
+			continue
+		}
+		name := usage.TypeName(e.GetType().GetLongName())
+		if name == "" || external[name] {
+			continue
+		}
+		m, ok := byType[name]
+		if !ok {
+			m = &MessageReport{Message: Message{GoType: name}}
+			byType[name] = m
+		}
+		m.Blockers = append(m.Blockers, Site{
+			Use:      e.GetUse().GetType(),
+			Position: position(e.GetLocation()),
+		})
+	}
+
+	byFile := make(map[string]*FileReport)
+	for _, m := range byType {
+		name, known := m.ProtoFile, m.ProtoFile != ""
+		if !known {
+			name = "Go package " + m.goPackage() + " (unknown .proto file)"
+		}
+		f, ok := byFile[name]
+		if !ok {
+			f = &FileReport{Name: name, Known: known}
+			byFile[name] = f
+		}
+		f.Messages = append(f.Messages, m)
+	}
+
+	r := &Report{}
+	for _, name := range slices.Sorted(maps.Keys(byFile)) {
+		f := byFile[name]
+		slices.SortFunc(f.Messages, func(a, b *MessageReport) int { return strings.Compare(a.GoType, b.GoType) })
+		switch {
+		case f.blockers() > 0:
+			r.Blocked = append(r.Blocked, f)
+		case !slices.ContainsFunc(f.Messages, func(m *MessageReport) bool { return m.API != protodetecttypes.OpaqueAPI }):
+			r.Opaque = append(r.Opaque, f)
+		default:
+			r.Ready = append(r.Ready, f)
+		}
+	}
+	return r
+}
+
+// Write prints the report, listing up to maxSites blocking uses per message
+// (all if maxSites is negative).
+func (r *Report) Write(w io.Writer, maxSites int) {
+	if len(r.Opaque) > 0 {
+		fmt.Fprintf(w, "%d .proto files already use the Opaque API.\n\n", len(r.Opaque))
+	}
+	fmt.Fprintf(w, "%d .proto files are ready for the Opaque API (no Go code needs open struct access to their messages):\n", len(r.Ready))
+	for _, f := range r.Ready {
+		fmt.Fprintf(w, "\t%s (%d messages)\n", f.Name, len(f.Messages))
+	}
+	fmt.Fprintf(w, "\n%d .proto files are blocked by Go code that needs open struct access:\n", len(r.Blocked))
+	for _, f := range r.Blocked {
+		fmt.Fprintf(w, "\t%s: %d blocking uses\n", f.Name, f.blockers())
+		for _, m := range f.Messages {
+			if len(m.Blockers) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\t\t%s: %s\n", m.GoType, countUses(m.Blockers))
+			for i, s := range m.Blockers {
+				if maxSites >= 0 && i == maxSites {
+					fmt.Fprintf(w, "\t\t\t... and %d more\n", len(m.Blockers)-i)
+					break
+				}
+				fmt.Fprintf(w, "\t\t\t%s (%s)\n", s.Position, s.Use)
+			}
+		}
+	}
+	if cmd := r.SetapiCommand(); cmd != "" {
+		fmt.Fprintf(w, "\nTo set the ready .proto files to the Opaque API, use:\n\t%s\n", cmd)
+	}
+}
+
+// SetapiCommand returns the setapi invocation that sets the ready .proto files
+// to the Opaque API, or "" if no known .proto file is ready. The files are
+// looked up in r.ProtoPaths, so that the command works in any directory.
+func (r *Report) SetapiCommand() string {
+	var files []string
+	for _, f := range r.Ready {
+		if f.Known {
+			files = append(files, f.Name)
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	args := []string{"open2opaque", "setapi", "-api=OPAQUE"}
+	for _, root := range r.ProtoPaths {
+		args = append(args, "-proto_path="+root)
+	}
+	return strings.Join(append(args, files...), " ")
+}
+
+// countUses summarizes sites by use, e.g. "2 DIRECT_FIELD_ACCESS, 1 SHALLOW_COPY".
+func countUses(sites []Site) string {
+	counts := make(map[statspb.Use_Type]int)
+	for _, s := range sites {
+		counts[s.Use]++
+	}
+	var parts []string
+	for _, use := range slices.Sorted(maps.Keys(counts)) {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[use], use))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func position(loc *statspb.Location) string {
+	return fmt.Sprintf("%s:%d:%d", loc.GetFile(), loc.GetStart().GetLine(), loc.GetStart().GetColumn())
+}
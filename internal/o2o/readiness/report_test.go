@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readiness
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	statspb "google.golang.org/open2opaque/internal/dashboard"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+)
+
+func TestReport(t *testing.T) {
+	entry := func(typ string, use statspb.Use_Type, line int64, generated bool) *statspb.Entry {
+		return &statspb.Entry{
+			Location: &statspb.Location{
+				Package:         "example.com/app",
+				File:            "app/app.go",
+				IsGeneratedFile: generated,
+				Start:           &statspb.Position{Line: line, Column: 2},
+			},
+			Type: &statspb.Type{LongName: typ},
+			Use:  &statspb.Use{Type: use},
+		}
+	}
+	messages := []Message{
+		{GoType: "example.com/apb.A", ProtoFile: "a.proto", API: protodetecttypes.HybridAPI, Editable: true},
+		{GoType: "example.com/apb.A_Nested", ProtoFile: "a.proto", API: protodetecttypes.HybridAPI, Editable: true},
+		{GoType: "example.com/bpb.B", ProtoFile: "b.proto", API: protodetecttypes.HybridAPI, Editable: true},
+		{GoType: "example.com/bpb.B2", ProtoFile: "b.proto", API: protodetecttypes.OpaqueAPI, Editable: true},
+		{GoType: "example.com/cpb.C", ProtoFile: "c.proto", API: protodetecttypes.OpaqueAPI, Editable: true},
+		// Dependencies are not reported, whether they are used or not.
+		{GoType: "google.golang.org/protobuf/types/known/emptypb.Empty", ProtoFile: "google/protobuf/empty.proto", API: protodetecttypes.OpenAPI},
+		{GoType: "example.com/dep/epb.E", ProtoFile: "dep/e.proto", API: protodetecttypes.HybridAPI},
+	}
+	entries := []*statspb.Entry{
+		// Uses that don't need the open struct API.
+		entry("*example.com/apb.A", statspb.Use_METHOD_CALL, 1, false),
+		entry("*example.com/apb.A_Nested", statspb.Use_CONSTRUCTOR, 2, false),
+		// Generated code accesses fields directly.
+		entry("*example.com/apb.A", statspb.Use_DIRECT_FIELD_ACCESS, 3, true),
+
+		entry("*example.com/bpb.B", statspb.Use_DIRECT_FIELD_ACCESS, 4, false),
+		entry("example.com/bpb.B", statspb.Use_SHALLOW_COPY, 5, false),
+		entry("[]*example.com/bpb.B", statspb.Use_DIRECT_FIELD_ACCESS, 6, false),
+		entry("*example.com/dpb.D", statspb.Use_REFLECT_CALL, 7, false),
+		entry("struct{x int}", statspb.Use_EMBEDDING, 8, false),
+		entry("*example.com/dep/epb.E", statspb.Use_DIRECT_FIELD_ACCESS, 9, false),
+	}
+	r := NewReport(messages, entries)
+	r.ProtoPaths = []string{"/src/proto"}
+
+	var b strings.Builder
+	r.Write(&b, 2)
+	want := `1 .proto files already use the Opaque API.
+
+1 .proto files are ready for the Opaque API (no Go code needs open struct access to their messages):
+	a.proto (2 messages)
+
+2 .proto files are blocked by Go code that needs open struct access:
+	Go package example.com/dpb (unknown .proto file): 1 blocking uses
+		example.com/dpb.D: 1 REFLECT_CALL
+			app/app.go:7:2 (REFLECT_CALL)
+	b.proto: 3 blocking uses
+		example.com/bpb.B: 2 DIRECT_FIELD_ACCESS, 1 SHALLOW_COPY
+			app/app.go:4:2 (DIRECT_FIELD_ACCESS)
+			app/app.go:5:2 (SHALLOW_COPY)
+			... and 1 more
+
+To set the ready .proto files to the Opaque API, use:
+	open2opaque setapi -api=OPAQUE -proto_path=/src/proto a.proto
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("Write() diff (-want +got):\n%s", diff)
+	}
+}
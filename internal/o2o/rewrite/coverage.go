@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"bufio"
+	"fmt"
+	"go/build/constraint"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/open2opaque/internal/o2o/loader"
+)
+
+// coverage tracks which files were loaded in at least one build configuration
+// and which were excluded by build constraints.
+type coverage struct {
+	mu      sync.Mutex
+	loaded  map[string]bool
+	ignored map[string]bool
+}
+
+func (c *coverage) add(pkg *loader.Package) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded == nil {
+		c.loaded = make(map[string]bool)
+		c.ignored = make(map[string]bool)
+	}
+	for _, f := range pkg.Files {
+		c.loaded[f.Path] = true
+	}
+	for _, fname := range pkg.IgnoredFiles {
+		if strings.HasSuffix(fname, ".go") && !ignoreTagged(fname) {
+			c.ignored[fname] = true
+		}
+	}
+}
+
+// ignoreTagged reports whether the build constraint of the file requires the
+// "ignore" tag (e.g. //go:build ignore), which by convention excludes it from
+// all builds.
+func ignoreTagged(fname string) bool {
+	f, err := os.Open(fname)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "//") {
+			// Build constraints must precede the package clause.
+			return false
+		}
+		if !constraint.IsGoBuild(line) && !constraint.IsPlusBuild(line) {
+			continue
+		}
+		if expr, err := constraint.Parse(line); err == nil && requiresTag(expr, "ignore") {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresTag reports whether expr can only be satisfied if tag is set.
+func requiresTag(expr constraint.Expr, tag string) bool {
+	switch e := expr.(type) {
+	case *constraint.TagExpr:
+		return e.Tag == tag
+	case *constraint.AndExpr:
+		return requiresTag(e.X, tag) || requiresTag(e.Y, tag)
+	case *constraint.OrExpr:
+		return requiresTag(e.X, tag) && requiresTag(e.Y, tag)
+	}
+	return false
+}
+
+// uncovered returns the files that were excluded in all build configurations.
+func (c *coverage) uncovered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []string
+	for fname := range c.ignored {
+		if !c.loaded[fname] {
+			res = append(res, fname)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// targetName returns the name of t for progress and error reporting.
+func targetName(t *loader.Target) string {
+	if t.Build == nil {
+		return t.ID
+	}
+	return fmt.Sprintf("%s [%s]", t.ID, t.Build)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+)
+
+func TestCoverageSkipsIgnoreTaggedFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":          "package a\n",
+		"windows.go":    "//go:build windows\n\npackage a\n",
+		"gen.go":        "// Copyright\n\n//go:build ignore\n\npackage main\n",
+		"legacy.go":     "// +build ignore\n\npackage main\n",
+		"ignore_or.go":  "//go:build ignore || linux\n\npackage a\n",
+		"ignore_and.go": "//go:build ignore && linux\n\npackage a\n",
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for name, content := range files {
+		if err := os.WriteFile(path(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cov coverage
+	cov.add(&loader.Package{
+		Files: []*loader.File{{Path: path("a.go")}},
+		IgnoredFiles: []string{
+			path("windows.go"),
+			path("gen.go"),
+			path("legacy.go"),
+			path("ignore_or.go"),
+			path("ignore_and.go"),
+		},
+	})
+	want := []string{path("ignore_or.go"), path("windows.go")}
+	if diff := cmp.Diff(want, cov.uncovered()); diff != "" {
+		t.Errorf("uncovered() returned unexpected files (-want +got):\n%s", diff)
+	}
+}
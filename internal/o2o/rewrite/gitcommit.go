@@ -0,0 +1,258 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+)
+
+// Values of the -git_commit flag.
+const (
+	gitCommitLevel   = "level"
+	gitCommitPackage = "package"
+)
+
+// pendingFile is a rewritten file at one level. In -git_commit mode, files are
+// not written while packages are processed, but collected and then written
+// and committed in groups once all packages have been processed.
+type pendingFile struct {
+	pkg      string
+	path     string
+	level    fix.Level
+	code     string
+	rewrites []string
+	unsafe   map[string]int // unsafe rewrites introduced at this level, by reason
+}
+
+// pendingFiles collects pendingFile entries from concurrently processed
+// packages.
+type pendingFiles struct {
+	mu    sync.Mutex
+	files []pendingFile
+}
+
+func (p *pendingFiles) add(f pendingFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files = append(p.files, f)
+}
+
+// unsafeCounts returns the number of unsafe rewrites in f by reason name.
+func unsafeCounts(f *fix.FixedFile) map[string]int {
+	counts := make(map[string]int)
+	for reason, n := range f.RedFixes {
+		if n > 0 {
+			counts[fmt.Sprint(reason)] = n
+		}
+	}
+	return counts
+}
+
+// unsafeDelta returns the counts in cur that exceed the counts in prev.
+func unsafeDelta(prev, cur map[string]int) map[string]int {
+	delta := make(map[string]int)
+	for reason, n := range cur {
+		if d := n - prev[reason]; d > 0 {
+			delta[reason] = d
+		}
+	}
+	return delta
+}
+
+// commitGroup is a set of files that are committed together.
+type commitGroup struct {
+	title    string
+	files    map[string]string // path to code
+	rewrites map[string]bool
+	unsafe   map[string]int
+}
+
+func newCommitGroup(title string) *commitGroup {
+	return &commitGroup{
+		title:    title,
+		files:    make(map[string]string),
+		rewrites: make(map[string]bool),
+		unsafe:   make(map[string]int),
+	}
+}
+
+func (g *commitGroup) add(f pendingFile) {
+	g.files[f.path] = f.code
+	for _, r := range f.rewrites {
+		g.rewrites[r] = true
+	}
+	for reason, n := range f.unsafe {
+		g.unsafe[reason] += n
+	}
+}
+
+func (g *commitGroup) paths() []string {
+	var paths []string
+	for path := range g.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// message returns the commit message for the group.
+func (g *commitGroup) message() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "open2opaque: %s\n\n", g.title)
+	b.WriteString("Rewrites applied:\n")
+	if len(g.rewrites) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	for _, r := range keys(g.rewrites) {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+	b.WriteString("\nUnsafe rewrites by reason:\n")
+	if len(g.unsafe) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	var reasons []string
+	for reason := range g.unsafe {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "  %s: %d\n", reason, g.unsafe[reason])
+	}
+	fmt.Fprintf(&b, "\nFiles: %d\n", len(g.files))
+	return b.String()
+}
+
+// levelOrder maps levels to their position in the rewrite order.
+var levelOrder = map[fix.Level]int{
+	fix.Green:  1,
+	fix.Yellow: 2,
+	fix.Red:    3,
+}
+
+// groupCommits partitions the pending files into commit groups according to
+// the -git_commit mode.
+//
+// In level mode, there is one commit per level, containing only the files
+// that the level changed compared to the preceding level. The commits are
+// ordered green, yellow, red.
+//
+// In package mode, there is one commit per package, containing the final
+// content of each file. The commits are ordered by package name.
+func groupCommits(mode string, pending []pendingFile) ([]*commitGroup, error) {
+	sorted := make([]pendingFile, len(pending))
+	copy(sorted, pending)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return levelOrder[sorted[i].level] < levelOrder[sorted[j].level]
+	})
+
+	var groups []*commitGroup
+	byName := make(map[string]*commitGroup)
+	group := func(name, title string) *commitGroup {
+		if g, ok := byName[name]; ok {
+			return g
+		}
+		g := newCommitGroup(title)
+		byName[name] = g
+		groups = append(groups, g)
+		return g
+	}
+
+	switch mode {
+	case gitCommitLevel:
+		last := make(map[string]string) // path to code at the preceding level
+		for _, f := range sorted {
+			if code, ok := last[f.path]; ok && code == f.code {
+				continue // not changed by this level
+			}
+			last[f.path] = f.code
+			group(string(f.level), fmt.Sprintf("%s rewrites", f.level)).add(f)
+		}
+
+	case gitCommitPackage:
+		for _, f := range sorted {
+			group(f.pkg, fmt.Sprintf("rewrite %s", f.pkg)).add(f)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].title < groups[j].title
+		})
+
+	default:
+		return nil, fmt.Errorf("invalid value %q for --git_commit, valid values are %q and %q", mode, gitCommitLevel, gitCommitPackage)
+	}
+	return groups, nil
+}
+
+func git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out), nil
+}
+
+// checkCommittable verifies that the current directory is in a git working
+// tree and that none of the paths has uncommitted changes, which would
+// otherwise end up in the generated commits.
+func checkCommittable(ctx context.Context, paths []string) error {
+	if _, err := git(ctx, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return fmt.Errorf("--git_commit requires a git working tree: %v", err)
+	}
+	status, err := git(ctx, append([]string{"status", "--porcelain", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+	if status = strings.TrimSpace(status); status != "" {
+		return fmt.Errorf("--git_commit: files to be rewritten have uncommitted changes, commit or stash them first:\n%s", status)
+	}
+	return nil
+}
+
+// commitGroups writes the files of each group (through the journal), runs the
+// build fixers on them and commits them. It returns the paths of all written
+// files.
+func commitGroups(ctx context.Context, groups []*commitGroup, jnl *journal.Journal) ([]string, error) {
+	all := make(map[string]bool)
+	for _, g := range groups {
+		for path := range g.files {
+			all[path] = true
+		}
+	}
+	if err := checkCommittable(ctx, keys(all)); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		paths := g.paths()
+		for _, path := range paths {
+			if err := jnl.WriteFile(path, []byte(g.files[path]), 0644); err != nil {
+				return nil, err
+			}
+		}
+		if err := fixBuilds("", paths); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't fix builds: %v\n", err)
+		}
+		if _, err := git(ctx, append([]string{"add", "--"}, paths...)...); err != nil {
+			return nil, err
+		}
+		if _, err := git(ctx, append([]string{"commit", "--quiet", "-m", g.message(), "--"}, paths...)...); err != nil {
+			return nil, err
+		}
+		fmt.Printf("\tcommitted %d files: %s\n", len(paths), g.title)
+	}
+	return keys(all), nil
+}
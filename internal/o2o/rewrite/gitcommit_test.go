@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/fix"
+)
+
+func TestGroupCommits(t *testing.T) {
+	pending := []pendingFile{
+		{pkg: "example.com/b", path: "b.go", level: fix.Red, code: "b-red", rewrites: []string{"assignPre"}, unsafe: map[string]int{"PointerAlias": 2}},
+		{pkg: "example.com/a", path: "a.go", level: fix.Green, code: "a-green", rewrites: []string{"getPost"}},
+		{pkg: "example.com/a", path: "a.go", level: fix.Yellow, code: "a-green"},
+		{pkg: "example.com/b", path: "b.go", level: fix.Green, code: "b-green", rewrites: []string{"hasPre"}},
+		{pkg: "example.com/a", path: "a.go", level: fix.Red, code: "a-red", rewrites: []string{"buildPost"}, unsafe: map[string]int{"PointerAlias": 1}},
+	}
+
+	type group struct {
+		Title    string
+		Files    map[string]string
+		Rewrites []string
+		Unsafe   map[string]int
+	}
+	summarize := func(groups []*commitGroup) []group {
+		var out []group
+		for _, g := range groups {
+			out = append(out, group{
+				Title:    g.title,
+				Files:    g.files,
+				Rewrites: keys(g.rewrites),
+				Unsafe:   g.unsafe,
+			})
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		mode string
+		want []group
+	}{
+		{
+			mode: gitCommitLevel,
+			want: []group{
+				{
+					Title:    "green rewrites",
+					Files:    map[string]string{"a.go": "a-green", "b.go": "b-green"},
+					Rewrites: []string{"getPost", "hasPre"},
+					Unsafe:   map[string]int{},
+				},
+				{
+					Title:    "red rewrites",
+					Files:    map[string]string{"a.go": "a-red", "b.go": "b-red"},
+					Rewrites: []string{"assignPre", "buildPost"},
+					Unsafe:   map[string]int{"PointerAlias": 3},
+				},
+			},
+		},
+		{
+			mode: gitCommitPackage,
+			want: []group{
+				{
+					Title:    "rewrite example.com/a",
+					Files:    map[string]string{"a.go": "a-red"},
+					Rewrites: []string{"buildPost", "getPost"},
+					Unsafe:   map[string]int{"PointerAlias": 1},
+				},
+				{
+					Title:    "rewrite example.com/b",
+					Files:    map[string]string{"b.go": "b-red"},
+					Rewrites: []string{"assignPre", "hasPre"},
+					Unsafe:   map[string]int{"PointerAlias": 2},
+				},
+			},
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			groups, err := groupCommits(tc.mode, pending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, summarize(groups)); diff != "" {
+				t.Errorf("groupCommits(%q) returned unexpected groups (-want +got):\n%s", tc.mode, diff)
+			}
+		})
+	}
+
+	if _, err := groupCommits("everything", pending); err == nil {
+		t.Errorf("groupCommits(%q) succeeded, want error", "everything")
+	}
+}
+
+func TestCommitMessage(t *testing.T) {
+	g := newCommitGroup("red rewrites")
+	g.add(pendingFile{path: "a.go", rewrites: []string{"assignPre"}, unsafe: map[string]int{"EvalOrderChange": 1, "PointerAlias": 2}})
+	want := `open2opaque: red rewrites
+
+Rewrites applied:
+  assignPre
+
+Unsafe rewrites by reason:
+  EvalOrderChange: 1
+  PointerAlias: 2
+
+Files: 1
+`
+	if got := g.message(); got != want {
+		t.Errorf("message() = %q, want %q", got, want)
+	}
+	if !strings.Contains(newCommitGroup("green rewrites").message(), "(none)") {
+		t.Errorf("message() of an empty group does not mention (none)")
+	}
+}
@@ -9,10 +9,12 @@ package rewrite
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -22,12 +24,13 @@ import (
 	log "github.com/golang/glog"
 	"github.com/google/subcommands"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/tools/go/packages"
 	"google.golang.org/open2opaque/internal/fix"
 	"google.golang.org/open2opaque/internal/ignore"
 	"google.golang.org/open2opaque/internal/o2o/errutil"
+	"google.golang.org/open2opaque/internal/o2o/journal"
 	"google.golang.org/open2opaque/internal/o2o/loader"
 	"google.golang.org/open2opaque/internal/o2o/profile"
+	"google.golang.org/open2opaque/internal/o2o/shard"
 	"google.golang.org/open2opaque/internal/o2o/syncset"
 	"google.golang.org/open2opaque/internal/o2o/wd"
 	"google.golang.org/protobuf/proto"
@@ -51,6 +54,16 @@ type Cmd struct {
 	dryRun                bool
 	showWork              bool
 	useBuilders           string
+	journalDir            string
+	gitCommit             string
+	shardMaxFiles         int
+	shardBy               string
+	shardOutput           string
+	shardDir              string
+	shardBranchPrefix     string
+	codeOwners            string
+	buildConfigs          string
+	valueSignatures       string
 }
 
 func (cmd *Cmd) levels() []string {
@@ -149,6 +162,51 @@ func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
 		"use_builders",
 		useBuildersDefault,
 		"Determines where struct initialization rewrites will use builders instead of setters. Valid values are "+useBuildersValues+"."+useBuildersHelp)
+
+	f.StringVar(&cmd.buildConfigs,
+		"build_configs",
+		"",
+		"Semicolon-separated list of build configurations in which packages are loaded, so that files behind build constraints are rewritten, too. Each configuration is a comma-separated list of GOOS/GOARCH, build tags and KEY=VALUE environment variables, for example 'linux/amd64;windows/amd64;linux/amd64,integration'. Each file is rewritten once, in the first configuration that includes it. Empty means the default configuration of the go command.")
+
+	f.StringVar(&cmd.valueSignatures,
+		"value_signatures",
+		valueSignaturesPackage,
+		"Scope in which the red level changes message-valued function parameters and results to pointers. Valid values are 'package' (unexported functions of each package) and 'loaded' (also exported functions of internal packages that only rewritten packages can import, updating callers in all rewritten packages; this loads every package twice).")
+
+	f.StringVar(&cmd.journalDir,
+		"journal_dir",
+		"",
+		"Directory in which the original content of all written files is journaled, so that the run can be reverted with 'open2opaque undo'. Empty means the default directory in the user cache directory.")
+
+	f.StringVar(&cmd.gitCommit,
+		"git_commit",
+		"",
+		"If non-empty, commit the rewritten files to the local git repository instead of leaving them in the working tree. Valid values are 'level' (one commit per rewrite level: green, yellow, red) and 'package' (one commit per package). Commit messages list the applied rewrites and the number of unsafe rewrites by reason.")
+
+	f.IntVar(&cmd.shardMaxFiles,
+		"shard_max_files",
+		0,
+		"If positive, split the written files into shards of at most this many files for separate review. Packages are never split across shards, and shards are ordered such that each one builds after the preceding ones have been submitted. Zero disables sharding.")
+	f.StringVar(&cmd.shardBy,
+		"shard_by",
+		string(shard.ByDir),
+		"How to group files into shards. Valid values are 'dir' (neighboring directories) and 'owner' (never mix owners from the CODEOWNERS file).")
+	f.StringVar(&cmd.shardOutput,
+		"shard_output",
+		shardOutputPatch,
+		"How to emit shards. Valid values are 'patch' (one patch file per shard in -shard_dir) and 'branch' (one git branch per shard, named -shard_branch_prefix followed by the shard number).")
+	f.StringVar(&cmd.shardDir,
+		"shard_dir",
+		"open2opaque-shards",
+		"Directory into which -shard_output=patch writes the patches.")
+	f.StringVar(&cmd.shardBranchPrefix,
+		"shard_branch_prefix",
+		"open2opaque/shard-",
+		"Prefix of the branches created by -shard_output=branch.")
+	f.StringVar(&cmd.codeOwners,
+		"codeowners",
+		"",
+		"CODEOWNERS file used to determine the owners of shards. Empty means the first of CODEOWNERS, .github/CODEOWNERS, docs/CODEOWNERS and .gitlab/CODEOWNERS in the repository root.")
 }
 
 // Execute implements subcommand.Command.
@@ -299,26 +357,38 @@ func (cmd *Cmd) RewriteTargets(ctx context.Context, targets []string) error {
 		return fmt.Errorf("BUG: unhandled targetsKind %q", targetsKind)
 	}
 
-	packagesToTargets := func(pkgs []string) ([]*loader.Target, error) {
-		fmt.Printf("Resolving Go package names...\n")
-		cfg := &packages.Config{
-			Context: ctx,
-		}
-		loaded, err := packages.Load(cfg, pkgs...)
-		if err != nil {
-			return nil, err
-		}
-		targets := make([]*loader.Target, len(loaded))
-		for idx, l := range loaded {
-			targets[idx] = &loader.Target{ID: l.ID}
+	fmt.Printf("Resolving Go package names...\n")
+	ws, err := loader.LoadWorkspace(ctx, ".")
+	if err != nil {
+		return fmt.Errorf("can't determine the Go modules: %v", err)
+	}
+	if len(ws.Modules) > 1 {
+		fmt.Printf("Workspace with %d modules:\n", len(ws.Modules))
+		for _, m := range ws.Modules {
+			fmt.Printf("  %s (%s)\n", m.Path, m.Dir)
 		}
-		return targets, nil
 	}
-
-	targetsToRewrite, err := packagesToTargets(pkgs)
+	targetsToRewrite, err := ws.Targets(ctx, pkgs)
 	if err != nil {
 		return fmt.Errorf("can't read the package list: %v", err)
 	}
+	buildConfigs, err := loader.ParseBuildConfigs(cmd.buildConfigs)
+	if err != nil {
+		return err
+	}
+	if len(buildConfigs) > 0 {
+		// Load every package in every configuration, one configuration after
+		// the other.
+		var expanded []*loader.Target
+		for _, bc := range buildConfigs {
+			for _, t := range targetsToRewrite {
+				t := *t
+				t.Build = bc
+				expanded = append(expanded, &t)
+			}
+		}
+		targetsToRewrite = expanded
+	}
 
 	var builderUseType fix.BuilderUseType
 	switch cmd.useBuilders {
@@ -332,6 +402,12 @@ func (cmd *Cmd) RewriteTargets(ctx context.Context, targets []string) error {
 		return fmt.Errorf("invalid value for --use_builders flag. Valid values are 'tests', 'everywhere', 'everywhere-except-promising' and 'nowhere'")
 	}
 
+	switch cmd.valueSignatures {
+	case valueSignaturesPackage, valueSignaturesLoaded:
+	default:
+		return fmt.Errorf("invalid value for --value_signatures flag. Valid values are 'package' and 'loaded'")
+	}
+
 	cfg := &config{
 		targets:              targetsToRewrite,
 		typesToUpdate:        typesToUpdate,
@@ -345,6 +421,18 @@ func (cmd *Cmd) RewriteTargets(ctx context.Context, targets []string) error {
 		dryRun:               cmd.dryRun,
 		showWork:             cmd.showWork,
 		useBuilder:           builderUseType,
+		journalDir:           cmd.journalDir,
+		gitCommit:            cmd.gitCommit,
+		valueSignatures:      cmd.valueSignatures,
+		workspace:            ws,
+		shard: shardConfig{
+			maxFiles:     cmd.shardMaxFiles,
+			by:           shard.Grouping(cmd.shardBy),
+			output:       cmd.shardOutput,
+			dir:          cmd.shardDir,
+			branchPrefix: cmd.shardBranchPrefix,
+			codeOwners:   cmd.codeOwners,
+		},
 	}
 
 	if err := rewrite(ctx, cfg); err != nil {
@@ -407,6 +495,24 @@ type config struct {
 	showWork bool
 
 	useBuilder fix.BuilderUseType
+
+	// Directory in which the run is journaled (see package journal).
+	journalDir string
+
+	// If non-empty, the mode in which rewritten files are committed to git
+	// (gitCommitLevel or gitCommitPackage).
+	gitCommit string
+
+	// The scope of message-valued signature rewrites (valueSignaturesPackage
+	// or valueSignaturesLoaded).
+	valueSignatures string
+
+	// The editable modules. Files outside of them are never written.
+	workspace *loader.Workspace
+
+	// How written files are split into shards. Sharding is disabled if
+	// shard.maxFiles is zero.
+	shard shardConfig
 }
 
 func (c *config) createLoader(ctx context.Context, dir string) (_ loader.Loader, cl int64, _ error) {
@@ -448,6 +554,44 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 	}
 	defer l.Close(ctx)
 
+	if cfg.gitCommit != "" {
+		// Validate the mode before doing any work.
+		if _, err := groupCommits(cfg.gitCommit, nil); err != nil {
+			return err
+		}
+	}
+	if err := cfg.shard.validate(); err != nil {
+		return err
+	}
+	if cfg.shard.maxFiles > 0 && cfg.gitCommit != "" {
+		return fmt.Errorf("--shard_max_files and --git_commit are mutually exclusive")
+	}
+
+	var jnl *journal.Journal
+	if !cfg.dryRun {
+		root, err := journal.ResolveDir(cfg.journalDir)
+		if err != nil {
+			return err
+		}
+		if jnl, err = journal.New(root, "rewrite"); err != nil {
+			return fmt.Errorf("can't start the undo journal: %v", err)
+		}
+	}
+
+	var pending *pendingFiles
+	if cfg.gitCommit != "" {
+		pending = &pendingFiles{}
+	}
+	var sharded *shardFiles
+	if cfg.shard.maxFiles > 0 && !cfg.dryRun {
+		sharded = &shardFiles{}
+	}
+
+	var valueSignatures *fix.ValueSignatures
+	if cfg.valueSignatures == valueSignaturesLoaded && slices.Contains(cfg.levels, fix.Red) {
+		valueSignatures = loadValueSignatures(ctx, l, cfg.workspace, cfg.targets)
+	}
+
 	start := time.Now()
 	resc := make(chan fixResult)
 
@@ -456,12 +600,19 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 		outputFilterRe:       cfg.outputFilterRe,
 		ignoreOutputFilterRe: cfg.ignoreOutputFilterRe,
 		dryRun:               cfg.dryRun,
+		journal:              jnl,
+		pending:              pending,
+		sharded:              sharded,
+		workspace:            cfg.workspace,
+		coverage:             &coverage{},
 		configuredPkg: fix.ConfiguredPackage{
-			ProcessedFiles: syncset.New(), // avoid processing files multiple times
-			ShowWork:       cfg.showWork,
-			TypesToUpdate:  cfg.typesToUpdate,
-			Levels:         cfg.levels,
-			UseBuilders:    cfg.useBuilder,
+			ProcessedFiles:  syncset.New(), // avoid processing files multiple times
+			ShowWork:        cfg.showWork,
+			RecordRewrites:  pending != nil,
+			TypesToUpdate:   cfg.typesToUpdate,
+			Levels:          cfg.levels,
+			UseBuilders:     cfg.useBuilder,
+			ValueSignatures: valueSignatures,
 		},
 	}
 
@@ -483,6 +634,7 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 	fmt.Printf("Loading packages (in batches of up to %d)...\n", cfg.parallelJobs)
 
 	writtenByPath := make(map[string]bool)
+	failedByModule := make(map[string][]string)
 	var total, fail int
 	for res := range resc {
 		profile.Add(res.ctx, "main/gotresp")
@@ -490,6 +642,7 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 		total++
 		if res.err != nil {
 			fail++
+			failedByModule[res.module] = append(failedByModule[res.module], res.ruleName)
 		}
 
 		for p := range res.written {
@@ -517,23 +670,66 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 
 	_ = loaderCL // Used in Google-internal code.
 
+	if pending != nil && len(pending.files) > 0 {
+		groups, err := groupCommits(cfg.gitCommit, pending.files)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nCommitting rewritten files in %d commits:\n", len(groups))
+		committed, err := commitGroups(ctx, groups, jnl)
+		for _, p := range committed {
+			writtenByPath[p] = true
+		}
+		if err != nil {
+			if cerr := jnl.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "can't finish the undo journal: %v\n", cerr)
+			}
+			return err
+		}
+	}
+
 	writtenFiles := make([]string, 0, len(writtenByPath))
 	for fname := range writtenByPath {
 		writtenFiles = append(writtenFiles, fname)
 	}
 	sort.Strings(writtenFiles)
 
+	if uncovered := pkgCfg.coverage.uncovered(); len(uncovered) > 0 {
+		fmt.Printf("\nWARNING: %d files are excluded by build constraints in all build configurations and were not rewritten (see --build_configs):\n", len(uncovered))
+		for _, fname := range uncovered {
+			fmt.Printf("\t%s\n", fname)
+		}
+	}
+
 	successful := total - fail
 	fmt.Printf("\nProcessed %d packages:\n", total)
 	fmt.Printf("\tsuccessfully analyzed: %d\n", successful)
 	fmt.Printf("\tfailed to load/rewrite: %d\n", fail)
+	if len(cfg.workspace.Modules) > 1 {
+		for _, mod := range slices.Sorted(maps.Keys(failedByModule)) {
+			fmt.Printf("\t\tin module %s: %d (%s)\n", mod, len(failedByModule[mod]), strings.Join(failedByModule[mod], ", "))
+		}
+	}
 	fmt.Printf("\t.go files rewritten: %d\n", len(writtenFiles))
-	if len(writtenFiles) > 0 {
+	if len(writtenFiles) > 0 && pending == nil {
 		fmt.Println("\nYou should see the modified files.")
 		if err := fixBuilds("", writtenFiles); err != nil {
 			fmt.Fprintf(os.Stderr, "Can't fix builds: %v\n", err)
 		}
 	}
+	if jnl != nil {
+		if err := jnl.Close(); err != nil {
+			return fmt.Errorf("can't finish the undo journal: %v", err)
+		}
+		if len(writtenFiles) > 0 {
+			fmt.Printf("\nTo revert the changes of this run, use: open2opaque undo %s\n", jnl.ID())
+		}
+	}
+	if sharded != nil {
+		if err := shardWrittenFiles(ctx, cfg.shard, sharded, jnl); err != nil {
+			return err
+		}
+	}
 	fmt.Println()
 	if fail > 0 {
 		return fmt.Errorf(rewriteFailedFmt, fail)
@@ -542,6 +738,48 @@ func rewrite(ctx context.Context, cfg *config) (err error) {
 	return nil
 }
 
+const (
+	valueSignaturesPackage = "package"
+	valueSignaturesLoaded  = "loaded"
+)
+
+// loadValueSignatures loads all targets to determine which message-valued
+// function signatures can be changed to pointers without breaking callers.
+// The packages that can import an internal package are resolved in ws.
+func loadValueSignatures(ctx context.Context, l loader.Loader, ws *loader.Workspace, targets []*loader.Target) *fix.ValueSignatures {
+	fmt.Printf("Analyzing function signatures of %d packages...\n", len(targets))
+	var rewritten []string
+	for _, t := range targets {
+		rewritten = append(rewritten, t.ID)
+	}
+	vs := fix.NewValueSignatures(rewritten, func(parent string) ([]string, error) {
+		importers, err := ws.Targets(ctx, []string{parent + "/..."})
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, t := range importers {
+			paths = append(paths, t.ID)
+		}
+		return paths, nil
+	})
+	results := make(chan loader.LoadResult, len(targets))
+	go func() {
+		l.LoadPackages(ctx, targets, results)
+		close(results)
+	}()
+	for res := range results {
+		if res.Err != nil {
+			// The package fails again (and is reported) when it is
+			// rewritten. Its functions are not declared in the set, so
+			// their signatures are left alone.
+			continue
+		}
+		vs.Add(res.Package)
+	}
+	return vs
+}
+
 const rewriteFailedFmt = `%d packages could not be rewritten
 
 Frequent mistakes include:
@@ -557,7 +795,8 @@ Frequent mistakes include:
 - Incorrectly migrating .proto files to edition 2023.
   It is not sufficient to only replace the syntax line.
   To preserve your current behavior (proto2 or proto3)
-  when migrating to edition 2023, follow this document:
+  when migrating to edition 2023, use open2opaque editions
+  or follow this document:
   https://protobuf.dev/editions/features/#preserving
 `
 
@@ -591,6 +830,7 @@ var fixBuilds = func(dir string, files []string) error {
 
 type fixResult struct {
 	ruleName string
+	module   string
 	err      error
 	stats    []*statspb.Entry
 	ctx      context.Context
@@ -603,6 +843,11 @@ type packageConfig struct {
 	outputFilterRe       *regexp.Regexp
 	ignoreOutputFilterRe *regexp.Regexp
 	dryRun               bool
+	journal              *journal.Journal
+	pending              *pendingFiles // non-nil in -git_commit mode
+	sharded              *shardFiles   // non-nil if sharding is enabled
+	workspace            *loader.Workspace
+	coverage             *coverage
 	configuredPkg        fix.ConfiguredPackage
 }
 
@@ -621,13 +866,15 @@ func fixPackageBatch(ctx context.Context, cfg packageConfig, targets []*loader.T
 			ctx := profile.NewContext(ctx)
 			if err := res.Err; err != nil {
 				resc <- fixResult{
-					ruleName: res.Target.ID,
+					ruleName: targetName(res.Target),
+					module:   res.Target.Module,
 					err:      err,
 					ctx:      ctx,
 				}
 				return
 			}
 			profile.Add(ctx, "main/scheduled")
+			cfg.coverage.add(res.Package)
 
 			cfg := cfg // copy so that we can safely modify
 			cfg.configuredPkg.Testonly = res.Target.Testonly
@@ -636,7 +883,8 @@ func fixPackageBatch(ctx context.Context, cfg packageConfig, targets []*loader.T
 			stats, drifted, written, err := fixPackage(ctx, cfg)
 			profile.Add(ctx, "main/fixed")
 			resc <- fixResult{
-				ruleName: res.Target.ID,
+				ruleName: targetName(res.Target),
+				module:   res.Target.Module,
 				err:      err,
 				stats:    stats,
 				ctx:      ctx,
@@ -665,9 +913,14 @@ func fixPackage(ctx context.Context, cfg packageConfig) (stats []*statspb.Entry,
 	profile.Add(ctx, "fix/fixed")
 
 	written = make(map[string]bool)
+	refused := make(map[string]error)
+	prevUnsafe := make(map[string]map[string]int)
 	for _, lvl := range cfg.configuredPkg.Levels {
 		for _, f := range fixed[lvl] {
 			fname := f.Path
+			unsafe := unsafeCounts(f)
+			unsafeAtLevel := unsafeDelta(prevUnsafe[f.Path], unsafe)
+			prevUnsafe[f.Path] = unsafe
 			if !f.Modified {
 				log.InfoContextf(ctx, "Skipping writing [NOT MODIFIED] %s %s to %s: not modified", lvl, f.Path, fname)
 				continue
@@ -688,17 +941,44 @@ func fixPackage(ctx context.Context, cfg packageConfig) (stats []*statspb.Entry,
 				log.InfoContextf(ctx, "Skipping writing [DRY RUN] %s %s to %s", lvl, f.Path, fname)
 				continue
 			}
+			if err := cfg.workspace.CheckWritable(fname); err != nil {
+				log.InfoContextf(ctx, "Refusing to write [READ ONLY] %s %s to %s: %v", lvl, f.Path, fname, err)
+				refused[fname] = err
+				continue
+			}
 			if f.Drifted {
 				drifted = append(drifted, f.Path)
 			}
+			if cfg.pending != nil {
+				log.InfoContextf(ctx, "Deferring writing [GIT COMMIT] %s %s to %s", lvl, f.Path, fname)
+				cfg.pending.add(pendingFile{
+					pkg:      cfg.configuredPkg.Pkg.TypePkg.Path(),
+					path:     fname,
+					level:    lvl,
+					code:     f.Code,
+					rewrites: f.Rewrites,
+					unsafe:   unsafeAtLevel,
+				})
+				continue
+			}
 			log.InfoContextf(ctx, "Writing %s %s to %s", lvl, f.Path, fname)
-			if err := os.WriteFile(fname, []byte(f.Code), 0644); err != nil {
+			if err := cfg.journal.WriteFile(fname, []byte(f.Code), 0644); err != nil {
 				return nil, nil, nil, err
 			}
+			if cfg.sharded != nil {
+				cfg.sharded.add(cfg, f)
+			}
 			written[fname] = true
 		}
 	}
 	profile.Add(ctx, "fix/wrotefiles")
+	if len(refused) > 0 {
+		var msgs []string
+		for _, fname := range slices.Sorted(maps.Keys(refused)) {
+			msgs = append(msgs, refused[fname].Error())
+		}
+		return nil, drifted, written, fmt.Errorf("refusing to write files outside of editable modules:\n\t%s", strings.Join(msgs, "\n\t"))
+	}
 
 	stats = fixed.AllStats()
 	profile.Add(ctx, "fix/donestats")
@@ -0,0 +1,145 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+	"google.golang.org/open2opaque/internal/o2o/shard"
+)
+
+// Values of the -shard_output flag.
+const (
+	shardOutputPatch  = "patch"
+	shardOutputBranch = "branch"
+)
+
+// shardConfig configures how written files are split into shards.
+type shardConfig struct {
+	maxFiles     int
+	by           shard.Grouping
+	output       string
+	dir          string
+	branchPrefix string
+	codeOwners   string
+}
+
+func (c *shardConfig) validate() error {
+	if c.maxFiles < 0 {
+		return fmt.Errorf("invalid value %d for --shard_max_files, must not be negative", c.maxFiles)
+	}
+	if c.by != shard.ByDir && c.by != shard.ByOwner {
+		return fmt.Errorf("invalid value %q for --shard_by, valid values are %q and %q", c.by, shard.ByDir, shard.ByOwner)
+	}
+	if c.output != shardOutputPatch && c.output != shardOutputBranch {
+		return fmt.Errorf("invalid value %q for --shard_output, valid values are %q and %q", c.output, shardOutputPatch, shardOutputBranch)
+	}
+	return nil
+}
+
+// shardFiles collects the files written for concurrently processed packages.
+type shardFiles struct {
+	mu    sync.Mutex
+	files map[string]*shard.File // by path
+}
+
+// add records that f, a file of the package being fixed, was written. A file
+// written at several levels is recorded with the red count of the last level.
+func (s *shardFiles) add(cfg packageConfig, f *fix.FixedFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]*shard.File)
+	}
+	pkg := cfg.configuredPkg.Pkg.TypePkg
+	var imports []string
+	for _, imp := range pkg.Imports() {
+		imports = append(imports, imp.Path())
+	}
+	red := 0
+	for _, n := range f.RedFixes {
+		red += n
+	}
+	s.files[f.Path] = &shard.File{
+		Path:     f.Path,
+		Pkg:      pkg.Path(),
+		Imports:  imports,
+		RedCount: red,
+	}
+}
+
+// shardWrittenFiles partitions the written files into shards, prints the shard
+// list and emits the shards as patches or branches. The shards contain the
+// changes relative to the original content recorded in jnl, so that other
+// changes in the working tree are left out.
+func shardWrittenFiles(ctx context.Context, cfg shardConfig, collected *shardFiles, jnl *journal.Journal) error {
+	if len(collected.files) == 0 {
+		return nil
+	}
+	root, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("sharding requires a git working tree: %v", err)
+	}
+	root = strings.TrimSpace(root)
+
+	var owners *shard.CodeOwners
+	if cfg.codeOwners != "" {
+		owners, err = shard.LoadCodeOwners(cfg.codeOwners, root)
+	} else {
+		owners, err = shard.FindCodeOwners(root)
+	}
+	if err != nil {
+		return fmt.Errorf("can't load CODEOWNERS: %v", err)
+	}
+	if owners == nil && cfg.by == shard.ByOwner {
+		return fmt.Errorf("--shard_by=%s requires a CODEOWNERS file, but none was found in %s", shard.ByOwner, root)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	var files []*shard.File
+	for _, f := range collected.files {
+		f.Owners = owners.For(f.Path)
+		// Prefer short paths in shard keys, patches and messages.
+		if rel, err := filepath.Rel(wd, f.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			f.Path = rel
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	shards, err := shard.Partition(files, shard.Config{MaxFiles: cfg.maxFiles, By: cfg.by})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nSplit %d files into %d shards (submit them in this order):\n", len(files), len(shards))
+	shard.WriteSummary(os.Stdout, shards)
+
+	switch cfg.output {
+	case shardOutputPatch:
+		names, err := shard.WritePatches(ctx, shards, cfg.dir, jnl.Original)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nWrote %d patches to %s\n", len(names), cfg.dir)
+	case shardOutputBranch:
+		names, err := shard.CreateBranches(ctx, shards, cfg.branchPrefix, jnl.Original)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nCreated %d branches: %s\n", len(names), strings.Join(names, ", "))
+	}
+	return nil
+}
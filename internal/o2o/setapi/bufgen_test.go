@@ -0,0 +1,78 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package setapi
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runSetapi(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := Command()
+	f := flag.NewFlagSet("setapi", flag.ContinueOnError)
+	cmd.SetFlags(f)
+	if err := f.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.setapi(context.Background(), f); err != nil {
+		t.Fatalf("setapi %s: %v", strings.Join(args, " "), err)
+	}
+}
+
+// TestSetBufGenYAMLRoundTrip checks that the apilevelM options that
+// -set_buf_gen_yaml writes for a module are the default API level of its files
+// in later runs.
+func TestSetBufGenYAMLRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "proto")
+	path := filepath.Join(root, "x", "a.proto")
+	bufGen := filepath.Join(dir, "buf.gen.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const content = `edition = "2023";
+package x;
+import "google/protobuf/go_features.proto";
+option features.(pb.go).api_level = API_HYBRID;
+message A {}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const bufGenContent = `version: v2
+plugins:
+  - local: protoc-gen-go
+    out: gen
+`
+	if err := os.WriteFile(bufGen, []byte(bufGenContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	common := []string{"-journal_dir=" + filepath.Join(dir, "journal"), "-proto_path=" + root, "-buf_gen_yaml=" + bufGen, "-api=HYBRID"}
+
+	runSetapi(t, append(common, "-set_buf_gen_yaml", root)...)
+	b, err := os.ReadFile(bufGen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "apilevelMx/a.proto=API_HYBRID"; !strings.Contains(string(b), want) {
+		t.Fatalf("-set_buf_gen_yaml wrote:\n%s\nwant an option %s", b, want)
+	}
+
+	// HYBRID is now the default of the file, so its explicit flag is
+	// redundant.
+	runSetapi(t, append(common, path)...)
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "api_level") {
+		t.Errorf("setapi did not remove the redundant API flag:\n%s", b)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package setapi
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+// builtinFormatter is the -protofmt value that selects the built-in formatter
+// (see formatTouched).
+const builtinFormatter = "builtin"
+
+var (
+	importRe = regexp.MustCompile(`^\s*import\s+(?:(?:public|weak)\s+)?"([^"]*)"\s*;`)
+	// optionWithTrailerRe matches an option statement followed by more code on
+	// the same line, as produced by inserting an option into a message whose
+	// body is on the same line as its name.
+	optionWithTrailerRe = regexp.MustCompile(`^(\s*option\s[^;]*;)\s*(\S.*)$`)
+)
+
+// formatTouched formats the lines of modified that are not present in orig,
+// i.e. the lines that setapi inserted or changed, and leaves all other lines
+// byte-for-byte identical:
+//
+//   - An option statement that is followed by more code on the same line is
+//     moved onto its own line.
+//   - Option and import statements get the indentation of the surrounding
+//     statements at the same nesting level.
+//   - An inserted import is moved to its sorted position in the import block.
+func formatTouched(orig, modified []byte) []byte {
+	origLines := strings.Split(string(orig), "\n")
+	lines := strings.Split(string(modified), "\n")
+	touched := make([]bool, len(lines))
+	idx := 0
+	// Equal lines always follow the added (or deleted) lines of a chunk.
+	for _, c := range diff.DiffChunks(origLines, lines) {
+		for range c.Added {
+			touched[idx] = true
+			idx++
+		}
+		idx += len(c.Equal)
+	}
+	if !slices.Contains(touched, true) {
+		return modified
+	}
+
+	// Split options followed by more code.
+	var splitLines []string
+	var splitTouched, trailer []bool
+	for i, line := range lines {
+		if m := optionWithTrailerRe.FindStringSubmatch(line); touched[i] && m != nil {
+			splitLines = append(splitLines, m[1], m[2])
+			splitTouched = append(splitTouched, true, true)
+			trailer = append(trailer, false, true)
+			continue
+		}
+		splitLines = append(splitLines, line)
+		splitTouched = append(splitTouched, touched[i])
+		trailer = append(trailer, false)
+	}
+	lines, touched = splitLines, splitTouched
+
+	// Indent options, imports and the code split off after options.
+	depths := braceDepths(lines)
+	unit := indentUnit(lines, touched, depths)
+	for i, line := range lines {
+		if !touched[i] {
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		switch {
+		case trailer[i] && strings.HasPrefix(trimmed, "}"):
+			lines[i] = openerIndent(lines, depths, i) + trimmed
+		case trailer[i], strings.HasPrefix(trimmed, "option "), strings.HasPrefix(trimmed, "import "):
+			lines[i] = siblingIndent(lines, touched, depths, i, unit) + trimmed
+		}
+	}
+
+	// Sort inserted imports into their import block.
+	for i := 0; i < len(lines); i++ {
+		m := importRe.FindStringSubmatch(lines[i])
+		if !touched[i] || m == nil {
+			continue
+		}
+		begin, end := i, i+1
+		for begin > 0 && importRe.MatchString(lines[begin-1]) {
+			begin--
+		}
+		for end < len(lines) && importRe.MatchString(lines[end]) {
+			end++
+		}
+		line := lines[i]
+		lines = append(lines[:i], lines[i+1:]...)
+		touched = append(touched[:i], touched[i+1:]...)
+		end--
+		pos := end
+		for j := begin; j < end; j++ {
+			if other := importRe.FindStringSubmatch(lines[j]); other[1] > m[1] {
+				pos = j
+				break
+			}
+		}
+		lines = append(lines[:pos], append([]string{line}, lines[pos:]...)...)
+		touched = append(touched[:pos], append([]bool{true}, touched[pos:]...)...)
+		if pos > i {
+			i-- // the line now at i has not been looked at yet
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// siblingIndent returns the indentation for line i: the indentation of the
+// closest untouched statement at the same nesting level, or the indentation of
+// the enclosing block plus unit if there is none.
+func siblingIndent(lines []string, touched []bool, depths []int, i int, unit string) string {
+	sibling := func(j int) bool {
+		trimmed := strings.TrimSpace(lines[j])
+		return !touched[j] && depths[j] == depths[i] && trimmed != "" && !strings.HasPrefix(trimmed, "}")
+	}
+	for j := i - 1; j >= 0 && depths[j] >= depths[i]; j-- {
+		if sibling(j) {
+			return leadingSpace(lines[j])
+		}
+	}
+	for j := i + 1; j < len(lines) && depths[j] >= depths[i]; j++ {
+		if sibling(j) {
+			return leadingSpace(lines[j])
+		}
+	}
+	if depths[i] == 0 {
+		return ""
+	}
+	return openerIndent(lines, depths, i) + unit
+}
+
+// openerIndent returns the indentation of the line that opens the block
+// containing line i.
+func openerIndent(lines []string, depths []int, i int) string {
+	for j := i - 1; j >= 0; j-- {
+		if depths[j] < depths[i] {
+			return leadingSpace(lines[j])
+		}
+	}
+	return ""
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// indentUnit returns the indentation of the first untouched statement in a
+// top-level block, or two spaces if there is none.
+func indentUnit(lines []string, touched []bool, depths []int) string {
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if touched[i] || depths[i] != 1 || trimmed == "" || strings.HasPrefix(trimmed, "}") {
+			continue
+		}
+		if ws := leadingSpace(line); ws != "" {
+			return ws
+		}
+	}
+	return "  "
+}
+
+// braceDepths returns the nesting depth at the beginning of each line,
+// ignoring braces in strings and comments.
+func braceDepths(lines []string) []int {
+	depths := make([]int, len(lines))
+	depth := 0
+	inBlockComment := false
+	for i, line := range lines {
+		depths[i] = depth
+		b := []byte(line)
+		var quote byte
+		for j := 0; j < len(b); j++ {
+			c := b[j]
+			switch {
+			case inBlockComment:
+				if bytes.HasPrefix(b[j:], []byte("*/")) {
+					inBlockComment = false
+					j++
+				}
+			case quote != 0:
+				if c == '\\' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+			case bytes.HasPrefix(b[j:], []byte("//")):
+				j = len(b)
+			case bytes.HasPrefix(b[j:], []byte("/*")):
+				inBlockComment = true
+				j++
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+			}
+		}
+	}
+	return depths
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package setapi
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatTouched(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		orig     string
+		modified string
+		want     string
+	}{
+		{
+			desc:     "unchanged",
+			orig:     "message M {\n int32 f = 1;\n}\n",
+			modified: "message M {\n int32 f = 1;\n}\n",
+			want:     "message M {\n int32 f = 1;\n}\n",
+		},
+		{
+			desc: "message option",
+			orig: `message M {
+    int32 f = 1;
+    message Inner { string s = 1; }
+}
+`,
+			modified: `message M {
+option features.(pb.go).api_level = API_HYBRID;
+    int32 f = 1;
+    message Inner {
+option features.(pb.go).api_level = API_OPEN; string s = 1; }
+}
+`,
+			want: `message M {
+    option features.(pb.go).api_level = API_HYBRID;
+    int32 f = 1;
+    message Inner {
+        option features.(pb.go).api_level = API_OPEN;
+        string s = 1; }
+}
+`,
+		},
+		{
+			desc:     "empty message",
+			orig:     "message M {}\n",
+			modified: "message M {\noption features.(pb.go).api_level = API_HYBRID;}\n",
+			want:     "message M {\n  option features.(pb.go).api_level = API_HYBRID;\n}\n",
+		},
+		{
+			desc: "sorted import and file option",
+			orig: `edition = "2023";
+
+import "a/b.proto";
+import "z/y.proto";
+
+option go_package = "x/z"; // comment with {
+`,
+			modified: `edition = "2023";
+
+import "a/b.proto";
+import "z/y.proto";
+import "google/protobuf/go_features.proto";
+
+option go_package = "x/z"; // comment with {
+  option features.(pb.go).api_level = API_HYBRID;
+`,
+			want: `edition = "2023";
+
+import "a/b.proto";
+import "google/protobuf/go_features.proto";
+import "z/y.proto";
+
+option go_package = "x/z"; // comment with {
+option features.(pb.go).api_level = API_HYBRID;
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := string(formatTouched([]byte(tc.orig), []byte(tc.modified)))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("formatTouched() returned unexpected content (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
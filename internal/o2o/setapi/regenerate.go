@@ -0,0 +1,211 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package setapi
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"google.golang.org/open2opaque/internal/o2o/args"
+	"google.golang.org/open2opaque/internal/protodetect"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+	"google.golang.org/open2opaque/internal/protoparse"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+// generatorCommand returns the command that regenerates the Go code for the
+// proto files at paths (which generate the Go packages importPaths):
+//
+//   - "buf" runs buf generate for the files, with the -buf_gen_yaml template
+//     if set.
+//   - "go_generate" runs go generate in the Go packages.
+//   - Any other value is a shell command, which receives the files as
+//     positional parameters, e.g.
+//     'protoc --go_out=. --go_opt=paths=source_relative "$@"'.
+func (cmd *Cmd) generatorCommand(ctx context.Context, paths, importPaths []string) *exec.Cmd {
+	switch cmd.regenerate {
+	case "buf":
+		a := []string{"generate"}
+		if cmd.bufGenYAML != "" {
+			a = append(a, "--template", cmd.bufGenYAML)
+		}
+		for _, p := range paths {
+			a = append(a, "--path", p)
+		}
+		return exec.CommandContext(ctx, "buf", a...)
+	case "go_generate":
+		return exec.CommandContext(ctx, "go", append([]string{"generate"}, importPaths...)...)
+	}
+	return exec.CommandContext(ctx, "sh", append([]string{"-c", cmd.regenerate, "sh"}, paths...)...)
+}
+
+// wantMessage is a message whose regenerated Go type is checked.
+type wantMessage struct {
+	path    string // path of the .proto file
+	message string // package-local proto message name, e.g. A.B
+	goPkg   string // Go import path
+	goName  string // Go type name
+	wantAPI gofeaturespb.GoFeatures_APILevel
+}
+
+// regenerateAndVerify runs the -regenerate generator for the proto files at
+// paths and then checks that the Go types of all their messages have the API
+// level that the (updated) proto files and protoc-gen-go options specify.
+func (cmd *Cmd) regenerateAndVerify(ctx context.Context, ix *args.Index, paths []string) error {
+	// Read the options again: -set_buf_gen_yaml may have changed them.
+	goOpts, err := cmd.generatorOptions()
+	if err != nil {
+		return err
+	}
+	genOpts, err := protodetect.NewGeneratorOptions(goOpts...)
+	if err != nil {
+		return err
+	}
+	mappings, err := cmd.goPackageMappings(goOpts)
+	if err != nil {
+		return err
+	}
+	var want []wantMessage
+	var importPaths []string
+	for _, path := range paths {
+		msgs, err := wantMessages(ix, path, genOpts, mappings)
+		if err != nil {
+			return err
+		}
+		want = append(want, msgs...)
+		for _, m := range msgs {
+			if !slices.Contains(importPaths, m.goPkg) {
+				importPaths = append(importPaths, m.goPkg)
+			}
+		}
+	}
+
+	c := cmd.generatorCommand(ctx, paths, importPaths)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	logf("Regenerating Go code: %s", strings.Join(c.Args, " "))
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("regenerating Go code failed (the proto files were changed, regenerate the Go code before running rewrite): %v", err)
+	}
+	if len(importPaths) == 0 {
+		logf("No messages to verify")
+		return nil
+	}
+
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedTypes,
+	}, importPaths...)
+	if err != nil {
+		return fmt.Errorf("loading regenerated Go packages: %v", err)
+	}
+	scopes := make(map[string]*types.Scope)
+	for _, pkg := range pkgs {
+		if pkg.Types != nil {
+			scopes[pkg.PkgPath] = pkg.Types.Scope()
+		}
+	}
+	if problems := verifyMessages(want, scopes); len(problems) > 0 {
+		return fmt.Errorf("%d messages do not have the expected API level after regenerating the Go code:\n\t%s", len(problems), strings.Join(problems, "\n\t"))
+	}
+	logf("Verified the API level of %d messages in %d Go packages", len(want), len(importPaths))
+	return nil
+}
+
+// wantMessages returns the messages of the proto file at path along with
+// their expected API level and Go type. Files without a Go import path are
+// skipped.
+func wantMessages(ix *args.Index, path string, genOpts *protodetect.GeneratorOptions, mappings map[string]string) ([]wantMessage, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// The protoc-gen-go options (apilevelM and M) refer to files by import
+	// path. Files outside of the include roots are looked up by their path.
+	name, err := ix.ImportPath(path)
+	if err != nil {
+		name = path
+	}
+	fopt, err := parse(name, content, genOpts, false)
+	if err != nil {
+		return nil, err
+	}
+	goPkg, _, _ := strings.Cut(fopt.Desc.GetOptions().GetGoPackage(), ";")
+	if m, ok := mappings[name]; ok {
+		goPkg = m
+	}
+	if goPkg == "" {
+		logf("Skipping verification of %s, which has no Go import path (set go_package or pass an M option with -go_opt)", path)
+		return nil, nil
+	}
+	var msgs []wantMessage
+	for _, mopt := range fopt.MessageOpts {
+		traverseMsgTree(mopt, func(mopt *protoparse.MessageOpt) error {
+			msgs = append(msgs, wantMessage{
+				path:    path,
+				message: mopt.Message,
+				goPkg:   goPkg,
+				goName:  protodetecttypes.GoMessageName(mopt.Message),
+				wantAPI: mopt.GoAPI,
+			})
+			return nil
+		})
+	}
+	return msgs, nil
+}
+
+// verifyMessages checks the API level of the Go types of msgs, which are
+// looked up in the scopes of their Go packages, and returns a description of
+// each mismatch.
+func verifyMessages(msgs []wantMessage, scopes map[string]*types.Scope) []string {
+	var problems []string
+	for _, m := range msgs {
+		scope, ok := scopes[m.goPkg]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: message %s: Go package %s not found", m.path, m.message, m.goPkg))
+			continue
+		}
+		obj, ok := scope.Lookup(m.goName).(*types.TypeName)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: message %s: Go type %s.%s not found", m.path, m.message, m.goPkg, m.goName))
+			continue
+		}
+		got := protodetecttypes.Type{T: obj.Type()}.MessageAPI()
+		if want := messageAPI(m.wantAPI); got != want {
+			problems = append(problems, fmt.Sprintf("%s: message %s: Go type %s.%s has the %s API, want %s", m.path, m.message, m.goPkg, m.goName, apiName(got), apiName(want)))
+		}
+	}
+	return problems
+}
+
+func messageAPI(level gofeaturespb.GoFeatures_APILevel) protodetecttypes.MessageAPI {
+	switch level {
+	case gofeaturespb.GoFeatures_API_OPEN:
+		return protodetecttypes.OpenAPI
+	case gofeaturespb.GoFeatures_API_HYBRID:
+		return protodetecttypes.HybridAPI
+	case gofeaturespb.GoFeatures_API_OPAQUE:
+		return protodetecttypes.OpaqueAPI
+	}
+	return protodetecttypes.Invalid
+}
+
+func apiName(api protodetecttypes.MessageAPI) string {
+	switch api {
+	case protodetecttypes.OpenAPI:
+		return "OPEN"
+	case protodetecttypes.HybridAPI:
+		return "HYBRID"
+	case protodetecttypes.OpaqueAPI:
+		return "OPAQUE"
+	}
+	return "unknown (not a generated message)"
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package setapi
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/o2o/args"
+	"google.golang.org/open2opaque/internal/protodetect"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+func TestVerifyMessages(t *testing.T) {
+	const src = `package foopb
+
+type Open struct {
+	state int ` + "`protogen:\"open.v1\"`" + `
+}
+
+type Hybrid struct {
+	state int ` + "`protogen:\"hybrid.v1\"`" + `
+}
+
+type Opaque_Nested struct {
+	state int ` + "`protogen:\"opaque.v1\"`" + `
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.pb.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("example.com/foopb", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := map[string]*types.Scope{pkg.Path(): pkg.Scope()}
+
+	msg := func(name string, api gofeaturespb.GoFeatures_APILevel) wantMessage {
+		return wantMessage{
+			path:    "foo.proto",
+			message: name,
+			goPkg:   "example.com/foopb",
+			goName:  protodetecttypes.GoMessageName(name),
+			wantAPI: api,
+		}
+	}
+	msgs := []wantMessage{
+		msg("Open", gofeaturespb.GoFeatures_API_OPEN),
+		msg("Hybrid", gofeaturespb.GoFeatures_API_OPAQUE),
+		msg("Opaque.Nested", gofeaturespb.GoFeatures_API_OPAQUE),
+		msg("Missing", gofeaturespb.GoFeatures_API_HYBRID),
+		{path: "bar.proto", message: "Bar", goPkg: "example.com/barpb", goName: "Bar", wantAPI: gofeaturespb.GoFeatures_API_HYBRID},
+	}
+	want := []string{
+		"foo.proto: message Hybrid: Go type example.com/foopb.Hybrid has the HYBRID API, want OPAQUE",
+		"foo.proto: message Missing: Go type example.com/foopb.Missing not found",
+		"bar.proto: message Bar: Go package example.com/barpb not found",
+	}
+	if diff := cmp.Diff(want, verifyMessages(msgs, scopes)); diff != "" {
+		t.Errorf("verifyMessages() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWantMessagesImportPath(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "proto")
+	path := filepath.Join(root, "x", "a.proto")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const content = `edition = "2023";
+package x;
+option go_package = "example.com/x";
+message A {}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The mapping refers to the import path, which differs from the path.
+	genOpts, err := protodetect.NewGeneratorOptions("apilevelMx/a.proto=API_OPAQUE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wantMessages(args.NewIndex([]string{root}), path, genOpts, map[string]string{"x/a.proto": "example.com/xpb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []wantMessage{{
+		path:    path,
+		message: "A",
+		goPkg:   "example.com/xpb",
+		goName:  "A",
+		wantAPI: gofeaturespb.GoFeatures_API_OPAQUE,
+	}}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(wantMessage{})); diff != "" {
+		t.Errorf("wantMessages() diff (-want +got):\n%s", diff)
+	}
+}
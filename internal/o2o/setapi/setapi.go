@@ -16,6 +16,7 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 
@@ -23,7 +24,11 @@ import (
 	"github.com/google/subcommands"
 	"golang.org/x/sync/errgroup"
 	pb "google.golang.org/open2opaque/internal/apiflagdata"
+	"google.golang.org/open2opaque/internal/fix"
 	"google.golang.org/open2opaque/internal/o2o/args"
+	"google.golang.org/open2opaque/internal/o2o/bufgen"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+	"google.golang.org/open2opaque/internal/o2o/protofiles"
 	"google.golang.org/open2opaque/internal/protodetect"
 	"google.golang.org/open2opaque/internal/protoparse"
 	descpb "google.golang.org/protobuf/types/descriptorpb"
@@ -38,6 +43,18 @@ type Cmd struct {
 	maxProcs    uint
 	protoFmt    string
 	kind        string
+	journalDir  string
+	protoPaths  []string
+	bufYAML     string
+	dryRun      bool
+	check       bool
+	excludes    []string
+	goPackages  []string
+	goOpts      []string
+	bufGenYAML  string
+	setBufGen   bool
+	descSet     string
+	regenerate  string
 }
 
 // Name implements subcommand.Command.
@@ -50,13 +67,56 @@ func (*Cmd) Synopsis() string {
 
 // Usage implements subcommand.Command.
 func (*Cmd) Usage() string {
-	return `Usage: open2opaque setapi [-api=<` + strings.Join(validApis, "|") + `>] [-input_file=<input-list>] [<path/file.proto>] [<protopkg1>] [<protopkg2.MessageName>]
+	return `Usage: open2opaque setapi [-api=<` + strings.Join(validApis, "|") + `>] [-input_file=<input-list>] [-exclude=<pattern>] [-go_package=<importpath>] [<path/file.proto>] [<dir>] [<glob>] [<protopkg1>] [<protopkg2.MessageName>]
 
 The setapi subcommand adjusts the Go API level option on the specified proto file(s) / package(s) / message(s).
 
 The setapi subcommand can either read the proto file name(s) / package(s) / message(s)
 from a text file (-input_file) or from the command line arguments, or both.
 
+Either all changed files are written or none: if writing any file fails, the
+files written so far are restored. On success, setapi lists the changed files.
+
+Directories are walked recursively and glob patterns (where ** matches any
+number of directories) are expanded to the .proto files they contain. Files
+matching an -exclude pattern, e.g. 'third_party/**', are left unchanged.
+
+Proto packages and fully-qualified message names are looked up in the .proto
+files below the include roots (-proto_path or the modules of -buf_yaml). A
+package expands to all of its files.
+
+-go_package selects the .proto files that generate the given Go packages, e.g.
+-go_package=example.com/foo/foopb/... (where ... matches any string, like in
+go list), so that setapi can act on the same package list as rewrite. The Go
+import path of a file is taken from protoc-gen-go M options (-go_opt), from a
+descriptor set written by protoc --descriptor_set_out (-descriptor_set), or
+from its go_package option, in this order.
+
+The default API level of a file, which determines whether an explicit API flag
+is needed, depends on the protoc-gen-go options (default_api_level and
+apilevelM). Pass the options used for code generation with -go_opt or
+-buf_gen_yaml so that setapi matches what protoc-gen-go generates.
+
+With -set_buf_gen_yaml, setapi changes the API level in the protoc-gen-go
+configuration of the -buf_gen_yaml file instead of in the .proto files. Without
+inputs, it sets the default_api_level option, which flips all files without an
+explicit API flag. With inputs, e.g. the directory of a buf module, it sets
+apilevelM options for the input files (by import path relative to -proto_path
+or the -buf_yaml modules); files added to the module later are not covered.
+Only the opt values of the protoc-gen-go plugins are changed, not the managed
+mode settings. Later setapi runs with the same -buf_gen_yaml and include roots
+treat these options as the default API level of the files.
+
+With -regenerate, setapi regenerates the Go code of the input files after
+writing the changes, so that rewrite can use the new API. -regenerate=buf runs
+buf generate (with the -buf_gen_yaml template), -regenerate=go_generate runs go
+generate in the Go packages of the input files, and any other value is run as a
+shell command with the .proto files as arguments, e.g.
+-regenerate='protoc --go_out=. --go_opt=paths=source_relative "$@"'. Afterwards,
+setapi loads the regenerated Go packages and reports all messages whose Go type
+does not have the API level that the .proto files and protoc-gen-go options
+specify. -regenerate is ignored with -dry_run and -check.
+
 Command-line flag documentation follows:
 `
 }
@@ -68,7 +128,31 @@ func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&cmd.skipCleanup, "skip_cleanup", false, "skip the cleanup step, which removes the file-level flag if it equals the default and removes message flags if they equal the file-level API")
 	f.UintVar(&cmd.maxProcs, "max_procs", 32, "max number of files concurrently processed")
 	protofmtDefault := ""
-	f.StringVar(&cmd.protoFmt, "protofmt", protofmtDefault, "if non-empty, a formatter program for .proto files")
+	f.StringVar(&cmd.protoFmt, "protofmt", protofmtDefault, "if non-empty, a formatter program for .proto files; empty means the built-in formatter, which only formats the inserted and changed lines")
+	f.Func("proto_path", "include root in which proto packages and messages given as inputs are looked up (can be repeated, or a list separated by '"+string(os.PathListSeparator)+"'); default is the current directory", func(s string) error {
+		cmd.protoPaths = append(cmd.protoPaths, filepath.SplitList(s)...)
+		return nil
+	})
+	f.StringVar(&cmd.bufYAML, "buf_yaml", "", "buf.yaml file whose modules are used as include roots instead of -proto_path")
+	f.Func("exclude", "glob pattern of .proto files or directories to leave unchanged, e.g. 'third_party/**' (can be repeated)", func(s string) error {
+		cmd.excludes = append(cmd.excludes, s)
+		return nil
+	})
+	f.Func("go_package", "Go import path pattern of generated packages whose .proto files to update, e.g. example.com/foo/foopb/... (can be repeated)", func(s string) error {
+		cmd.goPackages = append(cmd.goPackages, s)
+		return nil
+	})
+	f.Func("go_opt", "protoc-gen-go options, as passed to protoc with --go_opt (can be repeated): default_api_level and apilevelM options determine the default API level, M options resolve -go_package", func(s string) error {
+		cmd.goOpts = append(cmd.goOpts, s)
+		return nil
+	})
+	f.StringVar(&cmd.bufGenYAML, "buf_gen_yaml", "", "buf.gen.yaml file from whose protoc-gen-go plugin configuration the protoc-gen-go options are read, in addition to -go_opt")
+	f.BoolVar(&cmd.setBufGen, "set_buf_gen_yaml", false, "instead of editing .proto files, set the API level in the protoc-gen-go configuration of -buf_gen_yaml: as default_api_level if there are no inputs, or as apilevelM overrides for the input files")
+	f.StringVar(&cmd.descSet, "descriptor_set", "", "FileDescriptorSet file (protoc --descriptor_set_out) whose go_package options are used to resolve -go_package")
+	f.StringVar(&cmd.regenerate, "regenerate", "", "after writing the changes, regenerate the Go code of the input files and verify the API level of the regenerated messages; 'buf' runs buf generate, 'go_generate' runs go generate in the generated Go packages, any other value is a shell command that receives the .proto files as arguments (\"$@\")")
+	f.BoolVar(&cmd.dryRun, "dry_run", false, "do not write any proto files, print a unified diff of the changes per file instead")
+	f.BoolVar(&cmd.check, "check", false, "do not write any proto files, exit with a non-zero status if any file would be changed (combine with -dry_run to also print the diffs)")
+	f.StringVar(&cmd.journalDir, "journal_dir", "", "directory in which the original content of all written files is journaled, so that the run can be reverted with 'open2opaque undo'; empty means the default directory in the user cache directory")
 }
 
 // Execute implements subcommand.Command.
@@ -94,6 +178,10 @@ func Command() *Cmd {
 type Task struct {
 	// Path of the proto file.
 	Path string
+	// Import path of the proto file, i.e. its path relative to the include
+	// root, under which per-file protoc-gen-go options (apilevelM) are looked
+	// up. If empty, Path is used.
+	ImportPath string
 	// The content of the proto file.
 	Content []byte
 	// If not empty, set the API level only for the message with this
@@ -115,6 +203,9 @@ type Task struct {
 	// ErrorOnExempt is true, Process returns an error. Otherwise, the original
 	// content is returned.
 	ErrorOnExempt bool
+	// The protoc-gen-go options used to generate Go code for the file, which
+	// determine the default API level. Nil means no options.
+	GeneratorOptions *protodetect.GeneratorOptions
 }
 
 func (cmd *Cmd) setapi(ctx context.Context, f *flag.FlagSet) error {
@@ -132,30 +223,99 @@ func (cmd *Cmd) setapi(ctx context.Context, f *flag.FlagSet) error {
 	}
 	inputs = append(inputs, f.Args()...)
 
+	goOpts, err := cmd.generatorOptions()
+	if err != nil {
+		return err
+	}
+	genOpts, err := protodetect.NewGeneratorOptions(goOpts...)
+	if err != nil {
+		return err
+	}
+
 	var tasks []Task
 	kind := cmd.kind
 	if kind == "" {
-		kind = "proto_filename"
+		kind = "autodetect"
+	}
+	ix, err := cmd.protoIndex()
+	if err != nil {
+		return err
 	}
+	var targets []args.Target
+	var patterns []string
 	for _, input := range inputs {
-		_, filename, symbol, err := args.ToProtoFilename(ctx, input, kind)
+		if protofiles.IsPattern(input) {
+			patterns = append(patterns, input)
+			continue
+		}
+		_, resolved, err := ix.Resolve(input, kind)
 		if err != nil {
 			return err
 		}
-		content, err := os.ReadFile(filename)
+		targets = append(targets, resolved...)
+	}
+	if len(cmd.goPackages) > 0 {
+		resolved, err := cmd.resolveGoPackages(ix, goOpts)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, resolved...)
+	}
+	if len(patterns) > 0 {
+		files, err := protofiles.Find(patterns, cmd.excludes)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			targets = append(targets, args.Target{Filename: f})
+		}
+	}
+	var bufGenFiles []string
+	for _, t := range targets {
+		// Excludes also apply to files of resolved packages and messages.
+		excluded, err := protofiles.Excluded(t.Filename, cmd.excludes)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			logf("Skipping excluded file %s", t.Filename)
+			continue
+		}
+		if cmd.setBufGen {
+			if t.Symbol != "" {
+				return fmt.Errorf("-set_buf_gen_yaml can't set the API level of message %s, only of files", t.Symbol)
+			}
+			bufGenFiles = append(bufGenFiles, t.Filename)
+			continue
+		}
+		content, err := os.ReadFile(t.Filename)
 		if err != nil {
 			return err
 		}
+		// Files outside of the include roots are looked up by their path.
+		importPath, _ := ix.ImportPath(t.Filename)
 		tasks = append(tasks, Task{
-			Path:          filename,
-			Symbol:        symbol,
-			Content:       content,
-			TargetAPI:     api,
-			SkipCleanup:   cmd.skipCleanup,
-			ErrorOnExempt: true,
+			Path:             t.Filename,
+			ImportPath:       importPath,
+			Symbol:           t.Symbol,
+			Content:          content,
+			TargetAPI:        api,
+			SkipCleanup:      cmd.skipCleanup,
+			ErrorOnExempt:    true,
+			GeneratorOptions: genOpts,
 		})
 	}
 
+	if cmd.setBufGen {
+		if len(bufGenFiles) == 0 && (len(inputs) > 0 || len(cmd.goPackages) > 0) {
+			// Don't fall back to changing the default for all files.
+			return fmt.Errorf("all inputs are excluded")
+		}
+		if err := cmd.updateBufGen(api, ix, bufGenFiles); err != nil {
+			return err
+		}
+		return cmd.regenerateAfterWrite(ctx, ix, bufGenFiles)
+	}
 	if len(tasks) == 0 {
 		return fmt.Errorf("missing inputs, use either -list (one input per line) and / or pass input file name(s) / package(s) / message(s) as non-flag arguments")
 	}
@@ -174,15 +334,15 @@ func (cmd *Cmd) setapi(ctx context.Context, f *flag.FlagSet) error {
 
 	protofmt := cmd.protoFmt
 	if protofmt == "" {
-		protofmt = "cat"
+		protofmt = builtinFormatter
 	}
-	eg, ctx := errgroup.WithContext(ctx)
+	eg, egCtx := errgroup.WithContext(ctx)
 	eg.SetLimit(int(cmd.maxProcs))
 	for itask, task := range tasks {
 		itask, task := itask, task
 		eg.Go(func() error {
 			var err error
-			outputs[itask], err = Process(ctx, task, protofmt)
+			outputs[itask], err = Process(egCtx, task, protofmt)
 			return err
 		})
 	}
@@ -190,21 +350,198 @@ func (cmd *Cmd) setapi(ctx context.Context, f *flag.FlagSet) error {
 		return fmt.Errorf("aborting without writing any proto files: %v", err)
 	}
 
-	// Write the outputs back to the input files.
-	eg, ctx = errgroup.WithContext(ctx)
-	eg.SetLimit(int(cmd.maxProcs))
+	if cmd.dryRun || cmd.check {
+		return cmd.preview(tasks, outputs)
+	}
+	if err := cmd.write(tasks, outputs); err != nil {
+		return err
+	}
+	var paths []string
+	for _, task := range tasks {
+		paths = append(paths, task.Path)
+	}
+	return cmd.regenerateAfterWrite(ctx, ix, paths)
+}
+
+// regenerateAfterWrite regenerates and verifies the Go code of the proto files
+// at paths if -regenerate is set.
+func (cmd *Cmd) regenerateAfterWrite(ctx context.Context, ix *args.Index, paths []string) error {
+	if cmd.regenerate == "" || cmd.dryRun || cmd.check {
+		return nil
+	}
+	return cmd.regenerateAndVerify(ctx, ix, paths)
+}
+
+// write writes the outputs back to the input files, journaling the original
+// content for open2opaque undo. Either all changed files are written or none.
+func (cmd *Cmd) write(tasks []Task, outputs [][]byte) error {
+	var files []journal.File
 	for itask, task := range tasks {
-		itask, task := itask, task
-		eg.Go(func() error {
-			return os.WriteFile(task.Path, outputs[itask], 0644)
-		})
+		if !bytes.Equal(task.Content, outputs[itask]) {
+			files = append(files, journal.File{Name: task.Path, Data: outputs[itask], Perm: 0644})
+		}
 	}
-	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("error while writing proto files: %v", err)
+	if len(files) == 0 {
+		logf("No changes")
+		return nil
+	}
+	root, err := journal.ResolveDir(cmd.journalDir)
+	if err != nil {
+		return err
 	}
+	jnl, err := journal.New(root, "setapi")
+	if err != nil {
+		return fmt.Errorf("can't start the undo journal: %v", err)
+	}
+	werr := jnl.WriteFiles(files)
+	if err := jnl.Close(); err != nil {
+		return fmt.Errorf("can't finish the undo journal: %v", err)
+	}
+	if werr != nil {
+		if len(jnl.Paths()) > 0 {
+			return fmt.Errorf("error while writing proto files (to revert the files that remain modified, use: open2opaque undo %s): %v", jnl.ID(), werr)
+		}
+		return fmt.Errorf("error while writing proto files, no files were changed: %v", werr)
+	}
+	var changed []string
+	for _, f := range files {
+		changed = append(changed, f.Name)
+	}
+	logf("Changed %d files:\n\t%s", len(changed), strings.Join(changed, "\n\t"))
+	logf("To revert the changes of this run, use: open2opaque undo %s", jnl.ID())
 	return nil
 }
 
+// updateBufGen sets the API level in the -buf_gen_yaml file instead of in the
+// .proto files: as protoc-gen-go default_api_level option if there are no
+// input files, or else as apilevelM options overriding the default for each
+// input file (e.g. all files of a buf module).
+func (cmd *Cmd) updateBufGen(api gofeaturespb.GoFeatures_APILevel, ix *args.Index, files []string) error {
+	if cmd.bufGenYAML == "" {
+		return fmt.Errorf("-set_buf_gen_yaml requires -buf_gen_yaml")
+	}
+	orig, err := os.ReadFile(cmd.bufGenYAML)
+	if err != nil {
+		return err
+	}
+	cfg, err := bufgen.Load(cmd.bufGenYAML)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		if err := cfg.SetDefaultAPILevel(api); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		name, err := ix.ImportPath(f)
+		if err != nil {
+			return err
+		}
+		if err := cfg.SetFileAPILevel(name, api); err != nil {
+			return err
+		}
+	}
+	content, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+	tasks := []Task{{Path: cmd.bufGenYAML, Content: orig}}
+	outputs := [][]byte{content}
+	if cmd.dryRun || cmd.check {
+		return cmd.preview(tasks, outputs)
+	}
+	return cmd.write(tasks, outputs)
+}
+
+// preview prints the changes that setapi would make (-dry_run) and reports
+// whether there are any (-check), without writing files.
+func (cmd *Cmd) preview(tasks []Task, outputs [][]byte) error {
+	var changed []string
+	for itask, task := range tasks {
+		if bytes.Equal(task.Content, outputs[itask]) {
+			continue
+		}
+		changed = append(changed, task.Path)
+		if !cmd.dryRun {
+			continue
+		}
+		diff, err := fix.UnifiedDiff(task.Path, task.Content, outputs[itask])
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(diff)
+	}
+	if cmd.check && len(changed) > 0 {
+		return fmt.Errorf("%d proto files need changes:\n\t%s", len(changed), strings.Join(changed, "\n\t"))
+	}
+	if len(changed) == 0 {
+		logf("No changes")
+	}
+	return nil
+}
+
+// protoIndex returns an index of the proto files in the include roots.
+func (cmd *Cmd) protoIndex() (*args.Index, error) {
+	roots := cmd.protoPaths
+	if cmd.bufYAML != "" {
+		if len(roots) > 0 {
+			return nil, fmt.Errorf("-proto_path and -buf_yaml are mutually exclusive")
+		}
+		var err error
+		if roots, err = args.RootsFromBufYAML(cmd.bufYAML); err != nil {
+			return nil, err
+		}
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	return args.NewIndex(roots), nil
+}
+
+// generatorOptions returns the protoc-gen-go options from -buf_gen_yaml and
+// -go_opt.
+func (cmd *Cmd) generatorOptions() ([]string, error) {
+	var opts []string
+	if cmd.bufGenYAML != "" {
+		cfg, err := bufgen.Load(cmd.bufGenYAML)
+		if err != nil {
+			return nil, err
+		}
+		opts = cfg.GoOpts()
+	}
+	return append(opts, cmd.goOpts...), nil
+}
+
+// resolveGoPackages returns the .proto files generating the -go_package
+// packages.
+func (cmd *Cmd) resolveGoPackages(ix *args.Index, goOpts []string) ([]args.Target, error) {
+	mappings, err := cmd.goPackageMappings(goOpts)
+	if err != nil {
+		return nil, err
+	}
+	return ix.ResolveGoPackages(cmd.goPackages, mappings)
+}
+
+// goPackageMappings returns the Go import paths of proto files (by import
+// path) from -descriptor_set and the M options in goOpts, which take
+// precedence.
+func (cmd *Cmd) goPackageMappings(goOpts []string) (map[string]string, error) {
+	mappings := make(map[string]string)
+	if cmd.descSet != "" {
+		var err error
+		if mappings, err = args.GoPackagesFromDescriptorSet(cmd.descSet); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range goOpts {
+		if err := args.ParseGoOpt(opt, mappings); err != nil {
+			return nil, err
+		}
+	}
+	return mappings, nil
+}
+
 var (
 	apiMap = map[string]gofeaturespb.GoFeatures_APILevel{
 		"OPEN":   gofeaturespb.GoFeatures_API_OPEN,
@@ -262,10 +599,10 @@ func conflictingTasks(tasks []Task) []Task {
 
 var logf = func(format string, a ...any) { fmt.Fprintf(os.Stderr, "[setapi] "+format+"\n", a...) }
 
-func parse(path string, content []byte, skipMessages bool) (*protoparse.FileOpt, error) {
+func parse(path string, content []byte, genOpts *protodetect.GeneratorOptions, skipMessages bool) (*protoparse.FileOpt, error) {
 	parser := protoparse.NewParserWithAccessor(func(string) (io.ReadCloser, error) {
 		return io.NopCloser(bytes.NewReader(content)), nil
-	})
+	}).WithGeneratorOptions(genOpts)
 	fopt, err := parser.ParseFile(path, skipMessages)
 	if err != nil {
 		return nil, fmt.Errorf("protoparse.ParseFile: %v", err)
@@ -273,8 +610,8 @@ func parse(path string, content []byte, skipMessages bool) (*protoparse.FileOpt,
 	return fopt, nil
 }
 
-func parentAPI(path string, content []byte, msgName string) (gofeaturespb.GoFeatures_APILevel, error) {
-	fopt, err := parse(path, content, false)
+func parentAPI(path string, content []byte, genOpts *protodetect.GeneratorOptions, msgName string) (gofeaturespb.GoFeatures_APILevel, error) {
+	fopt, err := parse(path, content, genOpts, false)
 	if err != nil {
 		return gofeaturespb.GoFeatures_API_LEVEL_UNSPECIFIED, err
 	}
@@ -311,8 +648,9 @@ func traverseMsgTree(opt *protoparse.MessageOpt, f func(*protoparse.MessageOpt)
 // Process modifies the API level of a proto file or of a particular message in
 // a proto file, see the doc comment of the type Task for more details. Before
 // returning the modified file content, the file is formatted by executing
-// formatter (use "cat" if you don't have a formatter handy). This function
-// doesn't modify the []byte task.Content.
+// formatter (use "cat" if you don't have a formatter handy), or by the built-in
+// formatter if formatter is "builtin". This function doesn't modify the []byte
+// task.Content.
 func Process(ctx context.Context, task Task, formatter string) ([]byte, error) {
 	if task.Path == "" {
 		return nil, fmt.Errorf("path is empty")
@@ -324,19 +662,24 @@ func Process(ctx context.Context, task Task, formatter string) ([]byte, error) {
 	// Clone the input file content in case the caller will use the slice after
 	// the call.
 	content := slices.Clone(task.Content)
+	// The protoc-gen-go options refer to files by import path.
+	path := task.Path
+	if task.ImportPath != "" {
+		path = task.ImportPath
+	}
 
 	var err error
 	if task.Symbol == "" {
-		content, err = setFileAPI(task.Path, content, task.TargetAPI, task.SkipCleanup, task.ErrorOnExempt)
+		content, err = setFileAPI(path, content, task.GeneratorOptions, task.TargetAPI, task.SkipCleanup, task.ErrorOnExempt)
 		if err != nil {
 			return nil, fmt.Errorf("setFileAPI: %v", err)
 		}
 	} else {
-		parentAPI, err := parentAPI(task.Path, content, task.Symbol)
+		parentAPI, err := parentAPI(path, content, task.GeneratorOptions, task.Symbol)
 		if err != nil {
 			return nil, fmt.Errorf("parentAPI: %v", err)
 		}
-		content, err = setMsgAPI(task.Path, content, task.Symbol, parentAPI, task.TargetAPI, task.SkipCleanup)
+		content, err = setMsgAPI(path, content, task.GeneratorOptions, task.Symbol, parentAPI, task.TargetAPI, task.SkipCleanup)
 		if err != nil {
 			if errors.Is(err, ErrLeadingCommentPreventsEdit) && !task.ErrorOnExempt {
 				// Don't error out if leading comment exempted edit but ErrorOnExempt
@@ -349,11 +692,14 @@ func Process(ctx context.Context, task Task, formatter string) ([]byte, error) {
 	}
 
 	if !task.SkipCleanup {
-		content, err = cleanup(task.Path, content)
+		content, err = cleanup(path, content, task.GeneratorOptions)
 		if err != nil {
 			return nil, fmt.Errorf("cleanup: %v", err)
 		}
 	}
+	if formatter == builtinFormatter {
+		return formatTouched(task.Content, content), nil
+	}
 	content, err = FormatFile(ctx, content, formatter)
 	if err != nil {
 		return nil, fmt.Errorf("FormatFile: %v", err)
@@ -408,8 +754,8 @@ func levelLineFor(fopt *protoparse.FileOpt, targetAPI gofeaturespb.GoFeatures_AP
 	return "", fmt.Errorf("file %s is not using Protobuf Editions (https://protobuf.dev/editions/overview/) yet", fopt.File)
 }
 
-func setFileAPI(path string, content []byte, targetAPI gofeaturespb.GoFeatures_APILevel, skipCleanup, errorOnExempt bool) ([]byte, error) {
-	fopt, err := parse(path, content, true)
+func setFileAPI(path string, content []byte, genOpts *protodetect.GeneratorOptions, targetAPI gofeaturespb.GoFeatures_APILevel, skipCleanup, errorOnExempt bool) ([]byte, error) {
+	fopt, err := parse(path, content, genOpts, true)
 	if err != nil {
 		return nil, err
 	}
@@ -417,7 +763,7 @@ func setFileAPI(path string, content []byte, targetAPI gofeaturespb.GoFeatures_A
 		return nil, fmt.Errorf("BUG: fopt.APIInfo is nil")
 	}
 
-	if defaultAPI := protodetect.DefaultFileLevel(path); defaultAPI == targetAPI {
+	if defaultAPI := genOpts.DefaultFileLevel(path); defaultAPI == targetAPI {
 		if !fopt.IsExplicit {
 			logf("File %s is already on the target API level by default, doing nothing", path)
 			return content, nil
@@ -494,10 +840,10 @@ func findMsg(opt *protoparse.MessageOpt, name string) *protoparse.MessageOpt {
 // ignored if Task.ErrorOnExempt is false.
 var ErrLeadingCommentPreventsEdit = errors.New("leading comment prevents edit, check the logs for more details")
 
-func setMsgAPI(path string, content []byte, msgName string, parentAPI, targetAPI gofeaturespb.GoFeatures_APILevel, skipCleanup bool) ([]byte, error) {
+func setMsgAPI(path string, content []byte, genOpts *protodetect.GeneratorOptions, msgName string, parentAPI, targetAPI gofeaturespb.GoFeatures_APILevel, skipCleanup bool) ([]byte, error) {
 	// This function is called recursively. Parse content every time because the
 	// position information changes when parent messages are manipulated.
-	fopt, err := parse(path, content, false)
+	fopt, err := parse(path, content, genOpts, false)
 	if err != nil {
 		return nil, err
 	}
@@ -535,7 +881,7 @@ func setMsgAPI(path string, content []byte, msgName string, parentAPI, targetAPI
 		if mopt.GoAPI != targetAPI && fopt.Syntax == "editions" {
 			logf("Before changing API flag of message %q, descending into children to prevent recursive change of API level", msgName)
 			for _, child := range mopt.Children {
-				content, err = setMsgAPI(path, content, child.Message, targetAPI, child.GoAPI, skipCleanup)
+				content, err = setMsgAPI(path, content, genOpts, child.Message, targetAPI, child.GoAPI, skipCleanup)
 				if err != nil {
 					return nil, err
 				}
@@ -557,7 +903,7 @@ func setMsgAPI(path string, content []byte, msgName string, parentAPI, targetAPI
 		if fopt.Syntax == "editions" {
 			logf("Before changing API flag of message %q, descending into children to prevent recursive change of API level", msgName)
 			for _, child := range mopt.Children {
-				content, err = setMsgAPI(path, content, child.Message, targetAPI, child.GoAPI, skipCleanup)
+				content, err = setMsgAPI(path, content, genOpts, child.Message, targetAPI, child.GoAPI, skipCleanup)
 				if err != nil {
 					return nil, err
 				}
@@ -588,7 +934,7 @@ func setMsgAPI(path string, content []byte, msgName string, parentAPI, targetAPI
 	if fopt.Syntax == "editions" {
 		logf("Before changing API flag of message %q, descending into children to prevent recursive change of API level", msgName)
 		for _, child := range mopt.Children {
-			content, err = setMsgAPI(path, content, child.Message, targetAPI, child.GoAPI, skipCleanup)
+			content, err = setMsgAPI(path, content, genOpts, child.Message, targetAPI, child.GoAPI, skipCleanup)
 			if err != nil {
 				return nil, err
 			}
@@ -606,12 +952,12 @@ func setMsgAPI(path string, content []byte, msgName string, parentAPI, targetAPI
 	return content, nil
 }
 
-func cleanup(path string, content []byte) ([]byte, error) {
+func cleanup(path string, content []byte, genOpts *protodetect.GeneratorOptions) ([]byte, error) {
 	// Cleanup 1: If all messages are on the same API level, flip the file API
 	// level to that value. If said level is the default for the path, don't use
 	// an explicit flag.
 	var err error
-	content, err = cleanupAllMsgsToFile(path, content)
+	content, err = cleanupAllMsgsToFile(path, content, genOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -622,18 +968,18 @@ func cleanup(path string, content []byte) ([]byte, error) {
 	// a nested message is its parent message.
 	//
 	// Parse again after the previous cleanup might have modified content.
-	content, err = cleanupSameAsParent(path, content)
+	content, err = cleanupSameAsParent(path, content, genOpts)
 	if err != nil {
 		return nil, err
 	}
 
 	// Cleanup 3: ensure the go_features.proto import is present if and only if
 	// any parts of the file set the features.(pb.go).api_level option.
-	return cleanupGoFeaturesImport(path, content)
+	return cleanupGoFeaturesImport(path, content, genOpts)
 }
 
-func cleanupAllMsgsToFile(path string, content []byte) ([]byte, error) {
-	fopt, err := parse(path, content, false)
+func cleanupAllMsgsToFile(path string, content []byte, genOpts *protodetect.GeneratorOptions) ([]byte, error) {
+	fopt, err := parse(path, content, genOpts, false)
 	if err != nil {
 		return nil, err
 	}
@@ -658,15 +1004,15 @@ func cleanupAllMsgsToFile(path string, content []byte) ([]byte, error) {
 	// exemptions.
 	const errorOnExempt = false
 	const skipCleanup = false
-	content, err = setFileAPI(path, content, targetAPI, skipCleanup, errorOnExempt)
+	content, err = setFileAPI(path, content, genOpts, targetAPI, skipCleanup, errorOnExempt)
 	if err != nil {
 		return nil, fmt.Errorf("setFileAPI: %v", err)
 	}
 	return content, nil
 }
 
-func cleanupSameAsParent(path string, content []byte) ([]byte, error) {
-	fopt, err := parse(path, content, false)
+func cleanupSameAsParent(path string, content []byte, genOpts *protodetect.GeneratorOptions) ([]byte, error) {
+	fopt, err := parse(path, content, genOpts, false)
 	if err != nil {
 		return nil, err
 	}
@@ -759,9 +1105,9 @@ func usesFeature(fopt *protoparse.FileOpt) bool {
 	return false
 }
 
-func cleanupGoFeaturesImport(path string, content []byte) ([]byte, error) {
+func cleanupGoFeaturesImport(path string, content []byte, genOpts *protodetect.GeneratorOptions) ([]byte, error) {
 	const featuresProto = "google/protobuf/go_features.proto"
-	fopt, err := parse(path, content, false)
+	fopt, err := parse(path, content, genOpts, false)
 	if err != nil {
 		return nil, fmt.Errorf("parse: %v", err)
 	}
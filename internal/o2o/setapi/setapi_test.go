@@ -13,6 +13,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/open2opaque/internal/o2o/setapi"
+	"google.golang.org/open2opaque/internal/protodetect"
 	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
 )
 
@@ -1265,3 +1266,117 @@ message B{
 		})
 	}
 }
+
+func TestGeneratorOptions(t *testing.T) {
+	const (
+		openExplicit = `edition = "2023";
+package pkg;
+import "google/protobuf/go_features.proto";
+option features.(pb.go).api_level = API_OPEN;
+message M{}
+`
+		hybridExplicit = `edition = "2023";
+package pkg;
+import "google/protobuf/go_features.proto";
+option features.(pb.go).api_level = API_HYBRID;
+message M{}
+`
+		noFlag = `edition = "2023";
+package pkg;
+
+message M{}
+`
+	)
+	testCases := []struct {
+		name       string
+		goOpts     []string
+		path       string // default: x.proto
+		importPath string
+		protoIn    string
+		targetAPI  gofeaturespb.GoFeatures_APILevel
+		protoWant  string
+	}{
+		{
+			name:      "no_options__open_flag_is_redundant",
+			protoIn:   openExplicit,
+			targetAPI: gofeaturespb.GoFeatures_API_OPEN,
+			protoWant: noFlag,
+		},
+		{
+			name:      "default_hybrid__open_flag_is_needed",
+			goOpts:    []string{"paths=source_relative,default_api_level=API_HYBRID"},
+			protoIn:   openExplicit,
+			targetAPI: gofeaturespb.GoFeatures_API_OPEN,
+			protoWant: openExplicit,
+		},
+		{
+			name:      "default_hybrid__hybrid_flag_is_redundant",
+			goOpts:    []string{"default_api_level=API_HYBRID"},
+			protoIn:   hybridExplicit,
+			targetAPI: gofeaturespb.GoFeatures_API_HYBRID,
+			protoWant: noFlag,
+		},
+		{
+			name:      "apilevelM_hybrid__hybrid_flag_is_redundant",
+			goOpts:    []string{"Mx.proto=example.com/x", "apilevelMx.proto=API_HYBRID"},
+			protoIn:   hybridExplicit,
+			targetAPI: gofeaturespb.GoFeatures_API_HYBRID,
+			protoWant: noFlag,
+		},
+		{
+			name:      "apilevelM_other_file__hybrid_flag_is_needed",
+			goOpts:    []string{"apilevelMy.proto=API_HYBRID"},
+			protoIn:   hybridExplicit,
+			targetAPI: gofeaturespb.GoFeatures_API_HYBRID,
+			protoWant: hybridExplicit,
+		},
+		{
+			name:       "apilevelM_by_import_path__hybrid_flag_is_redundant",
+			goOpts:     []string{"apilevelMx.proto=API_HYBRID"},
+			path:       "proto/x.proto",
+			importPath: "x.proto",
+			protoIn:    hybridExplicit,
+			targetAPI:  gofeaturespb.GoFeatures_API_HYBRID,
+			protoWant:  noFlag,
+		},
+		{
+			name:       "apilevelM_by_file_path__hybrid_flag_is_needed",
+			goOpts:     []string{"apilevelMproto/x.proto=API_HYBRID"},
+			path:       "proto/x.proto",
+			importPath: "x.proto",
+			protoIn:    hybridExplicit,
+			targetAPI:  gofeaturespb.GoFeatures_API_HYBRID,
+			protoWant:  hybridExplicit,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			genOpts, err := protodetect.NewGeneratorOptions(tc.goOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := tc.path
+			if path == "" {
+				path = "x.proto"
+			}
+			task := setapi.Task{
+				Path:             path,
+				ImportPath:       tc.importPath,
+				Content:          []byte(tc.protoIn),
+				TargetAPI:        tc.targetAPI,
+				GeneratorOptions: genOpts,
+			}
+			protoGot, err := setapi.Process(context.Background(), task, "cat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.protoWant, string(protoGot)); diff != "" {
+				t.Errorf("Process: diff (-want +got):\n%v\n", diff)
+			}
+		})
+	}
+
+	if _, err := protodetect.NewGeneratorOptions("default_api_level=API_UNKNOWN"); err == nil {
+		t.Errorf("NewGeneratorOptions(default_api_level=API_UNKNOWN) succeeded, want error")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CodeOwners maps paths to owners following the CODEOWNERS syntax used by
+// GitHub and GitLab: each line contains a gitignore-style pattern followed by
+// owners, and the last matching line wins.
+type CodeOwners struct {
+	root  string
+	rules []ownerRule
+}
+
+type ownerRule struct {
+	re     *regexp.Regexp
+	owners []string
+}
+
+// CodeOwnersLocations lists where CODEOWNERS files are looked for, relative to
+// the repository root, in order of precedence.
+var CodeOwnersLocations = []string{
+	"CODEOWNERS",
+	".github/CODEOWNERS",
+	"docs/CODEOWNERS",
+	".gitlab/CODEOWNERS",
+}
+
+// FindCodeOwners loads the first existing CODEOWNERS file in the standard
+// locations below root. It returns nil (and no error) if there is none.
+func FindCodeOwners(root string) (*CodeOwners, error) {
+	for _, loc := range CodeOwnersLocations {
+		path := filepath.Join(root, loc)
+		if _, err := os.Stat(path); err == nil {
+			return LoadCodeOwners(path, root)
+		}
+	}
+	return nil, nil
+}
+
+// LoadCodeOwners parses the CODEOWNERS file at path. Patterns are interpreted
+// relative to root.
+func LoadCodeOwners(path, root string) (*CodeOwners, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	co := &CodeOwners{root: root}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue // skip empty lines, comments and GitLab sections
+		}
+		if idx := strings.Index(line, " #"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		re, err := patternToRegexp(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		co.rules = append(co.rules, ownerRule{re: re, owners: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return co, nil
+}
+
+// For returns the owners of path, which is either absolute or relative to the
+// current directory.
+func (co *CodeOwners) For(path string) []string {
+	if co == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(co.root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	for i := len(co.rules) - 1; i >= 0; i-- {
+		if co.rules[i].re.MatchString(rel) {
+			return co.rules[i].owners
+		}
+	}
+	return nil
+}
+
+// patternToRegexp translates a gitignore-style pattern to a regular expression
+// matching slash-separated paths relative to the repository root.
+func patternToRegexp(pattern string) (*regexp.Regexp, error) {
+	// Patterns with a slash at the beginning or in the middle are relative to
+	// the root; others match at any directory level.
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if dirOnly {
+		b.WriteString("/.*$")
+	} else {
+		// A pattern that matches a directory applies to everything in it.
+		b.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(b.String())
+}
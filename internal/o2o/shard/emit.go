@@ -0,0 +1,260 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WriteSummary writes a table listing all shards with their owners, file
+// counts and red counts.
+func WriteSummary(w io.Writer, shards []*Shard) {
+	fmt.Fprintf(w, "%-6s %-6s %-5s %-12s %-30s %s\n", "SHARD", "FILES", "RED", "DEPENDS ON", "KEY", "OWNERS")
+	for _, s := range shards {
+		var deps []string
+		for _, d := range s.DependsOn {
+			deps = append(deps, fmt.Sprint(d))
+		}
+		depStr := strings.Join(deps, ",")
+		if depStr == "" {
+			depStr = "-"
+		}
+		if s.Cycle {
+			depStr += " (cycle)"
+		}
+		owners := strings.Join(s.Owners, " ")
+		if owners == "" {
+			owners = "-"
+		}
+		fmt.Fprintf(w, "%-6d %-6d %-5d %-12s %-30s %s\n", s.Index, len(s.Files), s.RedCount, depStr, s.Key, owners)
+	}
+}
+
+// Description returns a commit message or patch header for the shard.
+func (s *Shard) Description(total int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "open2opaque: shard %d/%d (%s)\n\n", s.Index, total, s.Key)
+	if len(s.Owners) > 0 {
+		fmt.Fprintf(&b, "Owners: %s\n", strings.Join(s.Owners, " "))
+	}
+	fmt.Fprintf(&b, "Files: %d\n", len(s.Files))
+	fmt.Fprintf(&b, "Red rewrites: %d\n", s.RedCount)
+	if len(s.DependsOn) > 0 {
+		var deps []string
+		for _, d := range s.DependsOn {
+			deps = append(deps, fmt.Sprint(d))
+		}
+		fmt.Fprintf(&b, "Depends on shards: %s\n", strings.Join(deps, ", "))
+	}
+	return b.String()
+}
+
+func (s *Shard) paths() []string {
+	paths := make([]string, len(s.Files))
+	for idx, f := range s.Files {
+		paths[idx] = f.Path
+	}
+	return paths
+}
+
+func git(ctx context.Context, env []string, args ...string) (string, error) {
+	return gitInput(ctx, env, nil, args...)
+}
+
+func gitInput(ctx context.Context, env []string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out), nil
+}
+
+// Originals returns the content that the file at path had before the
+// rewrite, or false if the rewrite created the file.
+type Originals func(path string) (content []byte, existed bool, err error)
+
+// current returns the content of the file at path in the working tree, or
+// false if the file does not exist.
+func current(path string) ([]byte, bool, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	return b, err == nil, err
+}
+
+// emitter computes the changes of shards in a temporary git index, so that
+// neither the working tree nor the user's index are touched.
+type emitter struct {
+	ctx       context.Context
+	env       []string // selects the temporary index
+	root      string   // the top-level directory of the working tree
+	originals Originals
+}
+
+func newEmitter(ctx context.Context, tmp string, originals Originals) (*emitter, error) {
+	root, err := git(ctx, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	return &emitter{
+		ctx:       ctx,
+		env:       []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")},
+		root:      strings.TrimSpace(root),
+		originals: originals,
+	}, nil
+}
+
+// tree returns a tree object that equals the tree base, with the content of
+// paths replaced by content.
+func (e *emitter) tree(base string, paths []string, content Originals) (string, error) {
+	if _, err := git(e.ctx, e.env, "read-tree", base); err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		b, ok, err := content(path)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			if _, err := git(e.ctx, e.env, "update-index", "--force-remove", "--", path); err != nil {
+				return "", err
+			}
+			continue
+		}
+		blob, err := gitInput(e.ctx, nil, b, "hash-object", "-w", "--stdin", "--path="+path)
+		if err != nil {
+			return "", err
+		}
+		mode := "100644"
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0111 != 0 {
+			mode = "100755"
+		}
+		if _, err := git(e.ctx, e.env, "update-index", "--add", "--cacheinfo", mode+","+strings.TrimSpace(blob)+","+path); err != nil {
+			return "", err
+		}
+	}
+	tree, err := git(e.ctx, e.env, "write-tree")
+	return strings.TrimSpace(tree), err
+}
+
+// diff returns the changes that the rewrite made to the files of s: the
+// difference between their journaled original and their current content.
+// Other changes in the working tree are not included.
+func (e *emitter) diff(s *Shard) (string, error) {
+	paths := s.paths()
+	before, err := e.tree("HEAD", paths, e.originals)
+	if err != nil {
+		return "", err
+	}
+	after, err := e.tree(before, paths, current)
+	if err != nil {
+		return "", err
+	}
+	return git(e.ctx, nil, "diff", before, after, "--")
+}
+
+// WritePatches writes one patch per shard into dir, containing the changes
+// that the rewrite made to the shard's files (relative to their content
+// before the rewrite, as returned by originals). It returns the patch file
+// names.
+func WritePatches(ctx context.Context, shards []*Shard, dir string, originals Originals) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "open2opaque-shard")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	e, err := newEmitter(ctx, tmp, originals)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range shards {
+		diff, err := e.diff(s)
+		if err != nil {
+			return names, err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("shard-%03d.patch", s.Index))
+		content := s.Description(len(shards)) + "\n" + diff
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// CreateBranches creates one branch per shard, named prefix followed by the
+// shard index. Each branch contains a single commit with the changes that the
+// rewrite made to the shard's files (see WritePatches), on top of the branch
+// of the previous shard (HEAD for the first shard). Thus each branch contains
+// the shards it depends on. The working tree and the index are not modified.
+// It returns the branch names.
+func CreateBranches(ctx context.Context, shards []*Shard, prefix string, originals Originals) ([]string, error) {
+	parent, err := git(ctx, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	parent = strings.TrimSpace(parent)
+	tmp, err := os.MkdirTemp("", "open2opaque-shard")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	e, err := newEmitter(ctx, tmp, originals)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range shards {
+		diff, err := e.diff(s)
+		if err != nil {
+			return names, err
+		}
+		if _, err := git(ctx, e.env, "read-tree", parent); err != nil {
+			return names, err
+		}
+		if diff != "" {
+			if _, err := gitInput(ctx, e.env, []byte(diff), "-C", e.root, "apply", "--cached"); err != nil {
+				return names, fmt.Errorf("shard %d does not apply on top of the previous shards: %v", s.Index, err)
+			}
+		}
+		tree, err := git(ctx, e.env, "write-tree")
+		if err != nil {
+			return names, err
+		}
+		commit, err := git(ctx, nil, "commit-tree", strings.TrimSpace(tree), "-p", parent, "-m", s.Description(len(shards)))
+		if err != nil {
+			return names, err
+		}
+		parent = strings.TrimSpace(commit)
+		name := fmt.Sprintf("%s%03d", prefix, s.Index)
+		if _, err := git(ctx, nil, "branch", "--force", name, parent); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pad separates the unrelated change from the rewritten line in a.go.
+const pad = "\n\n\n\n\n"
+
+// setupRepo creates a git repository with a committed a.go and b.go, makes it
+// the current directory and simulates a rewrite of both files on top of an
+// unrelated, uncommitted change to a.go. It returns the originals of the
+// rewrite.
+func setupRepo(t *testing.T) Originals {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ctx := context.Background()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.go", "package a\n\n"+pad+"var A = 1\n")
+	write("b.go", "package a\n\nvar B = 1\n")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "test"},
+		{"config", "user.email", "test@example.com"},
+		{"add", "a.go", "b.go"},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		if _, err := git(ctx, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	originals := map[string]string{
+		"a.go": "package a\n\n// unrelated\n" + pad + "var A = 1\n",
+		"b.go": "package a\n\nvar B = 1\n",
+	}
+	write("a.go", "package a\n\n// unrelated\n"+pad+"var A = 2\n")
+	write("b.go", "package a\n\nvar B = 2\n")
+	write("c.go", "package a\n\nvar C = 2\n")
+	return func(path string) ([]byte, bool, error) {
+		content, ok := originals[path]
+		return []byte(content), ok, nil
+	}
+}
+
+var emitShards = []*Shard{
+	{Index: 1, Key: "a", Files: []*File{{Path: "a.go"}}},
+	{Index: 2, Key: "b", Files: []*File{{Path: "b.go"}, {Path: "c.go"}}, DependsOn: []int{1}},
+}
+
+func TestWritePatches(t *testing.T) {
+	originals := setupRepo(t)
+	names, err := WritePatches(context.Background(), emitShards, "patches", originals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("WritePatches() = %v, want 2 patches", names)
+	}
+	for _, tc := range []struct {
+		name         string
+		want, unwant []string
+	}{
+		{names[0], []string{"-var A = 1", "+var A = 2"}, []string{"unrelated", "b.go"}},
+		{names[1], []string{"-var B = 1", "+var B = 2", "+var C = 2"}, []string{"a.go"}},
+	} {
+		b, err := os.ReadFile(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%s does not contain %q:\n%s", tc.name, want, b)
+			}
+		}
+		for _, unwant := range tc.unwant {
+			if strings.Contains(string(b), "+"+unwant) || strings.Contains(string(b), "/"+unwant) {
+				t.Errorf("%s contains %q:\n%s", tc.name, unwant, b)
+			}
+		}
+	}
+}
+
+func TestCreateBranches(t *testing.T) {
+	originals := setupRepo(t)
+	ctx := context.Background()
+	names, err := CreateBranches(ctx, emitShards, "shard-", originals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("CreateBranches() = %v, want 2 branches", names)
+	}
+
+	// The second shard is stacked on top of the first.
+	parent, err := git(ctx, nil, "rev-parse", names[1]+"^")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := git(ctx, nil, "rev-parse", names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent != first {
+		t.Errorf("parent of %s = %s, want %s (%s)", names[1], parent, first, names[0])
+	}
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		// The unrelated working tree change is not committed.
+		{"a.go", "package a\n\n" + pad + "var A = 2\n"},
+		{"b.go", "package a\n\nvar B = 2\n"},
+		{"c.go", "package a\n\nvar C = 2\n"},
+	} {
+		got, err := git(ctx, nil, "show", names[1]+":"+tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s:%s = %q, want %q", names[1], tc.path, got, tc.want)
+		}
+	}
+}
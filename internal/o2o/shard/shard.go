@@ -0,0 +1,253 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package shard partitions the files written by a large open2opaque run into
+// reviewable shards.
+package shard
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// File is a file written by open2opaque.
+type File struct {
+	// Path of the file.
+	Path string
+	// Import path of the Go package that contains the file.
+	Pkg string
+	// Import paths of the Go packages that Pkg imports.
+	Imports []string
+	// Owners of the file, e.g. from a CODEOWNERS file.
+	Owners []string
+	// Number of red (unsafe) rewrites in the file.
+	RedCount int
+}
+
+// Shard is a set of files that can be reviewed and submitted together.
+type Shard struct {
+	// Index is the 1-based position of the shard in submission order.
+	Index int
+	// Key is the directory or owner set that the shard groups.
+	Key   string
+	Files []*File
+	// Owners is the union of the owners of all files, sorted.
+	Owners []string
+	// RedCount is the total number of red rewrites in the shard.
+	RedCount int
+	// DependsOn lists the indexes of shards that contain packages imported by
+	// packages in this shard. All of them precede this shard unless there is
+	// an import cycle between shards, in which case Cycle is true.
+	DependsOn []int
+	Cycle     bool
+}
+
+// Grouping determines which files are kept close to each other.
+type Grouping string
+
+const (
+	// ByDir packs packages in directory order, so that shards contain
+	// neighboring directories.
+	ByDir = Grouping("dir")
+	// ByOwner never mixes packages with different owners in a shard.
+	ByOwner = Grouping("owner")
+)
+
+// Config configures Partition.
+type Config struct {
+	// MaxFiles is the maximum number of files per shard. A single package with
+	// more files is never split and forms a shard on its own.
+	MaxFiles int
+	By       Grouping
+}
+
+// unit is a Go package: the smallest entity that is never split across shards,
+// so that each package builds at any point of the submission order.
+type unit struct {
+	pkg     string
+	dir     string
+	owners  []string
+	files   []*File
+	imports map[string]bool
+}
+
+// Partition splits files into shards of at most cfg.MaxFiles files each and
+// orders them such that shards containing dependencies come first.
+func Partition(files []*File, cfg Config) ([]*Shard, error) {
+	if cfg.MaxFiles <= 0 {
+		return nil, fmt.Errorf("MaxFiles must be positive, got %d", cfg.MaxFiles)
+	}
+	if cfg.By != ByDir && cfg.By != ByOwner {
+		return nil, fmt.Errorf("invalid grouping %q, valid values are %q and %q", cfg.By, ByDir, ByOwner)
+	}
+
+	units := make(map[string]*unit)
+	for _, f := range files {
+		u, ok := units[f.Pkg]
+		if !ok {
+			u = &unit{
+				pkg:     f.Pkg,
+				dir:     filepath.Dir(f.Path),
+				imports: make(map[string]bool),
+			}
+			units[f.Pkg] = u
+		}
+		u.files = append(u.files, f)
+		u.owners = union(u.owners, f.Owners)
+		for _, imp := range f.Imports {
+			u.imports[imp] = true
+		}
+	}
+	sorted := make([]*unit, 0, len(units))
+	for _, u := range units {
+		sort.Slice(u.files, func(i, j int) bool { return u.files[i].Path < u.files[j].Path })
+		sorted = append(sorted, u)
+	}
+	key := func(u *unit) string {
+		if cfg.By == ByOwner {
+			return strings.Join(u.owners, " ")
+		}
+		return ""
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		ui, uj := sorted[i], sorted[j]
+		if ki, kj := key(ui), key(uj); ki != kj {
+			return ki < kj
+		}
+		if ui.dir != uj.dir {
+			return ui.dir < uj.dir
+		}
+		return ui.pkg < uj.pkg
+	})
+
+	// Greedily pack units into shards.
+	var shards []*Shard
+	shardOf := make(map[string]*Shard) // package to shard
+	var cur *Shard
+	for _, u := range sorted {
+		k := key(u)
+		if cur == nil || cur.Key != k || len(cur.Files)+len(u.files) > cfg.MaxFiles {
+			cur = &Shard{Key: k}
+			shards = append(shards, cur)
+		}
+		cur.Files = append(cur.Files, u.files...)
+		cur.Owners = union(cur.Owners, u.owners)
+		for _, f := range u.files {
+			cur.RedCount += f.RedCount
+		}
+		shardOf[u.pkg] = cur
+	}
+	if cfg.By == ByDir {
+		for _, s := range shards {
+			s.Key = commonDir(s.Files)
+		}
+	}
+
+	return orderShards(shards, units, shardOf), nil
+}
+
+// orderShards sorts shards topologically by the imports of their packages and
+// assigns the final indexes.
+func orderShards(shards []*Shard, units map[string]*unit, shardOf map[string]*Shard) []*Shard {
+	deps := make(map[*Shard]map[*Shard]bool)
+	for _, s := range shards {
+		deps[s] = make(map[*Shard]bool)
+	}
+	for pkg, u := range units {
+		s := shardOf[pkg]
+		for imp := range u.imports {
+			if d, ok := shardOf[imp]; ok && d != s {
+				deps[s][d] = true
+			}
+		}
+	}
+
+	var ordered []*Shard
+	done := make(map[*Shard]bool)
+	for len(ordered) < len(shards) {
+		var next *Shard
+		for _, s := range shards {
+			if done[s] {
+				continue
+			}
+			ready := true
+			for d := range deps[s] {
+				if !done[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = s
+				break
+			}
+		}
+		if next == nil {
+			// Import cycle between shards: continue with the first remaining
+			// shard in packing order.
+			for _, s := range shards {
+				if !done[s] {
+					next = s
+					next.Cycle = true
+					break
+				}
+			}
+		}
+		done[next] = true
+		ordered = append(ordered, next)
+	}
+
+	for idx, s := range ordered {
+		s.Index = idx + 1
+	}
+	for _, s := range ordered {
+		for d := range deps[s] {
+			s.DependsOn = append(s.DependsOn, d.Index)
+		}
+		sort.Ints(s.DependsOn)
+	}
+	return ordered
+}
+
+func union(a, b []string) []string {
+	set := make(map[string]bool)
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		set[s] = true
+	}
+	res := make([]string, 0, len(set))
+	for s := range set {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// commonDir returns the longest common directory of all files.
+func commonDir(files []*File) string {
+	if len(files) == 0 {
+		return ""
+	}
+	common := strings.Split(filepath.Dir(files[0].Path), string(filepath.Separator))
+	for _, f := range files[1:] {
+		parts := strings.Split(filepath.Dir(f.Path), string(filepath.Separator))
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return "."
+	}
+	dir := strings.Join(common, string(filepath.Separator))
+	if dir == "" {
+		return string(filepath.Separator)
+	}
+	return dir
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPartition(t *testing.T) {
+	files := []*File{
+		{Path: "app/main.go", Pkg: "example.com/app", Imports: []string{"example.com/lib/a", "example.com/lib/b"}, Owners: []string{"@app"}, RedCount: 1},
+		{Path: "app/main_test.go", Pkg: "example.com/app", Imports: []string{"example.com/lib/a"}, Owners: []string{"@app"}},
+		{Path: "lib/a/a.go", Pkg: "example.com/lib/a", Imports: []string{"example.com/lib/b"}, Owners: []string{"@lib"}, RedCount: 2},
+		{Path: "lib/b/b.go", Pkg: "example.com/lib/b", Owners: []string{"@lib"}},
+		{Path: "lib/b/b2.go", Pkg: "example.com/lib/b", Owners: []string{"@lib"}, RedCount: 3},
+	}
+
+	type summary struct {
+		Key       string
+		Files     []string
+		Owners    []string
+		RedCount  int
+		DependsOn []int
+	}
+	summarize := func(shards []*Shard) []summary {
+		var out []summary
+		for idx, s := range shards {
+			if s.Index != idx+1 {
+				t.Errorf("shard %d has Index %d", idx, s.Index)
+			}
+			out = append(out, summary{
+				Key:       s.Key,
+				Files:     s.paths(),
+				Owners:    s.Owners,
+				RedCount:  s.RedCount,
+				DependsOn: s.DependsOn,
+			})
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want []summary
+	}{
+		{
+			name: "by dir",
+			cfg:  Config{MaxFiles: 2, By: ByDir},
+			want: []summary{
+				{Key: "lib/b", Files: []string{"lib/b/b.go", "lib/b/b2.go"}, Owners: []string{"@lib"}, RedCount: 3},
+				{Key: "lib/a", Files: []string{"lib/a/a.go"}, Owners: []string{"@lib"}, RedCount: 2, DependsOn: []int{1}},
+				{Key: "app", Files: []string{"app/main.go", "app/main_test.go"}, Owners: []string{"@app"}, RedCount: 1, DependsOn: []int{1, 2}},
+			},
+		},
+		{
+			name: "by dir with large shards",
+			cfg:  Config{MaxFiles: 10, By: ByDir},
+			want: []summary{
+				{Key: ".", Files: []string{"app/main.go", "app/main_test.go", "lib/a/a.go", "lib/b/b.go", "lib/b/b2.go"}, Owners: []string{"@app", "@lib"}, RedCount: 6},
+			},
+		},
+		{
+			name: "by owner",
+			cfg:  Config{MaxFiles: 10, By: ByOwner},
+			want: []summary{
+				{Key: "@lib", Files: []string{"lib/a/a.go", "lib/b/b.go", "lib/b/b2.go"}, Owners: []string{"@lib"}, RedCount: 5},
+				{Key: "@app", Files: []string{"app/main.go", "app/main_test.go"}, Owners: []string{"@app"}, RedCount: 1, DependsOn: []int{1}},
+			},
+		},
+		{
+			// Packages are never split, even if they exceed MaxFiles.
+			name: "oversized package",
+			cfg:  Config{MaxFiles: 1, By: ByOwner},
+			want: []summary{
+				{Key: "@lib", Files: []string{"lib/b/b.go", "lib/b/b2.go"}, Owners: []string{"@lib"}, RedCount: 3},
+				{Key: "@lib", Files: []string{"lib/a/a.go"}, Owners: []string{"@lib"}, RedCount: 2, DependsOn: []int{1}},
+				{Key: "@app", Files: []string{"app/main.go", "app/main_test.go"}, Owners: []string{"@app"}, RedCount: 1, DependsOn: []int{1, 2}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			shards, err := Partition(files, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, summarize(shards)); diff != "" {
+				t.Errorf("Partition(%+v) returned unexpected shards (-want +got):\n%s", tc.cfg, diff)
+			}
+		})
+	}
+
+	for _, cfg := range []Config{{MaxFiles: 0, By: ByDir}, {MaxFiles: 1, By: "team"}} {
+		if _, err := Partition(files, cfg); err == nil {
+			t.Errorf("Partition(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestPartitionCycle(t *testing.T) {
+	files := []*File{
+		{Path: "a/a.go", Pkg: "a", Imports: []string{"b"}, Owners: []string{"@a"}},
+		{Path: "b/b.go", Pkg: "b", Imports: []string{"a"}, Owners: []string{"@b"}},
+	}
+	shards, err := Partition(files, Config{MaxFiles: 10, By: ByOwner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 2 {
+		t.Fatalf("Partition returned %d shards, want 2", len(shards))
+	}
+	if !shards[0].Cycle {
+		t.Errorf("Partition did not mark the import cycle")
+	}
+}
+
+func TestCodeOwners(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, ".github"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `# Default owners.
+*                  @everyone
+*.go               @gophers
+/docs/             @writers
+lib/**/testdata    @testers
+internal/          @core # trailing comment
+/cmd/*.go          @cli
+`
+	if err := os.WriteFile(filepath.Join(root, ".github", "CODEOWNERS"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	co, err := FindCodeOwners(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if co == nil {
+		t.Fatal("FindCodeOwners did not find .github/CODEOWNERS")
+	}
+
+	for _, tc := range []struct {
+		path string
+		want []string
+	}{
+		{"README.md", []string{"@everyone"}},
+		{"main.go", []string{"@gophers"}},
+		{"pkg/x/y.go", []string{"@gophers"}},
+		{"docs/index.md", []string{"@writers"}},
+		{"docs/example.go", []string{"@writers"}},
+		{"sub/docs/index.md", []string{"@everyone"}},
+		{"lib/a/b/testdata/x.go", []string{"@testers"}},
+		{"lib/testdata/x.txt", []string{"@testers"}},
+		{"internal/fix/fix.go", []string{"@core"}},
+		{"third_party/internal/x.go", []string{"@core"}},
+		{"cmd/main.go", []string{"@cli"}},
+		{"cmd/sub/main.go", []string{"@gophers"}},
+	} {
+		if got := co.For(filepath.Join(root, tc.path)); !cmp.Equal(got, tc.want) {
+			t.Errorf("For(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+	if got := co.For(filepath.Join(filepath.Dir(root), "elsewhere.go")); got != nil {
+		t.Errorf("For(path outside of root) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package status implements the status open2opaque subcommand, which reports
+// the effective Go API level of proto files and messages.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"flag"
+	"github.com/google/subcommands"
+	"golang.org/x/tools/go/packages"
+	"google.golang.org/open2opaque/internal/o2o/args"
+	"google.golang.org/open2opaque/internal/o2o/bufgen"
+	"google.golang.org/open2opaque/internal/o2o/protofiles"
+	"google.golang.org/open2opaque/internal/protodetect"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+	"google.golang.org/open2opaque/internal/protoparse"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+// Cmd implements the status subcommand of the open2opaque tool.
+type Cmd struct {
+	format     string
+	goOpts     []string
+	bufGenYAML string
+	protoPaths []string
+	descSets   []string
+	goPackages []string
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "status" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "Report the effective Go API level of proto files and messages."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque status [-format=<table|json>] [-proto_path=<dir>] [-descriptor_set=<file>] [-go_package=<pattern>] [<path/file.proto|dir> ...]
+
+The status subcommand prints a tree of the specified proto files (directories
+are searched recursively) and their messages, each with its effective Go API
+level and where the level comes from:
+
+  explicit   the API flag is set on the file or message
+  default    the file uses the default API level of its syntax or edition
+  inherited  the message inherits the API level of its file or parent message
+
+Default API levels depend on the protoc-gen-go options used for code
+generation (default_api_level and apilevelM), pass them with -go_opt or
+-buf_gen_yaml. The apilevelM options refer to files by import path, relative
+to the -proto_path include root containing them.
+
+Explicit API flags with a leading comment are exempt from modification by
+setapi; the comment is reported as the exemption.
+
+Protos without .proto sources, e.g. third-party dependencies, can be audited
+from a FileDescriptorSet (-descriptor_set, written by protoc -o
+--include_imports) or from compiled Go packages (-go_package). For compiled
+packages, the API level of each message is read from its generated code
+(source "generated") and the messages are listed by Go type name under the
+.proto file recorded in the generated file; a file whose messages use
+different API levels is reported as MIXED.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.format, "format", "table", "output format, valid values: table, json")
+	f.Func("go_opt", "protoc-gen-go options, as passed to protoc with --go_opt, that determine the default API level (default_api_level, apilevelM); can be repeated", func(s string) error {
+		cmd.goOpts = append(cmd.goOpts, s)
+		return nil
+	})
+	f.StringVar(&cmd.bufGenYAML, "buf_gen_yaml", "", "buf.gen.yaml file from whose protoc-gen-go plugin configuration the protoc-gen-go options are read, in addition to -go_opt")
+	f.Func("proto_path", "include root relative to which the import paths of proto files are determined (can be repeated, or a list separated by '"+string(os.PathListSeparator)+"'); default is the current directory", func(s string) error {
+		cmd.protoPaths = append(cmd.protoPaths, filepath.SplitList(s)...)
+		return nil
+	})
+	f.Func("descriptor_set", "FileDescriptorSet file (protoc -o --include_imports) whose files are reported (can be repeated)", func(s string) error {
+		cmd.descSets = append(cmd.descSets, s)
+		return nil
+	})
+	f.Func("go_package", "Go package pattern, e.g. example.com/foo/..., of compiled generated packages whose messages are reported (can be repeated)", func(s string) error {
+		cmd.goPackages = append(cmd.goPackages, s)
+		return nil
+	})
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.status(ctx, f); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in status.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+// Sources of an API level.
+const (
+	SourceExplicit  = "explicit"
+	SourceDefault   = "default"
+	SourceInherited = "inherited"
+	// SourceGenerated means that the API level was read from compiled
+	// generated code.
+	SourceGenerated = "generated"
+)
+
+// apiMixed is the API level of a compiled file whose messages use different
+// API levels.
+const apiMixed = "MIXED"
+
+// File is the API level status of a proto file.
+type File struct {
+	Path string `json:"path"`
+	// Origin is set for files that were not read from .proto sources: the
+	// descriptor set or compiled Go package the file was read from.
+	Origin  string `json:"origin,omitempty"`
+	Package string `json:"package,omitempty"`
+	Syntax  string `json:"syntax,omitempty"`
+	API     string `json:"api,omitempty"`
+	Source  string `json:"source,omitempty"`
+	// Exemption is the leading comment of the API flag, if any.
+	Exemption string     `json:"exemption,omitempty"`
+	Messages  []*Message `json:"messages,omitempty"`
+	// Error is set if the file could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Message is the API level status of a message.
+type Message struct {
+	Name   string `json:"name"`
+	API    string `json:"api"`
+	Source string `json:"source"`
+	// InheritedFrom is the file or message whose API level is inherited.
+	InheritedFrom string     `json:"inherited_from,omitempty"`
+	Exemption     string     `json:"exemption,omitempty"`
+	Messages      []*Message `json:"messages,omitempty"`
+}
+
+// Summary counts files and messages by API level.
+type Summary struct {
+	Files    map[string]int `json:"files"`
+	Messages map[string]int `json:"messages"`
+	Errors   int            `json:"errors,omitempty"`
+}
+
+// Report is the result of the status subcommand.
+type Report struct {
+	Files   []*File `json:"files"`
+	Summary Summary `json:"summary"`
+}
+
+func (cmd *Cmd) status(ctx context.Context, f *flag.FlagSet) error {
+	if cmd.format != "table" && cmd.format != "json" {
+		return fmt.Errorf("invalid -format value %q, valid values: table, json", cmd.format)
+	}
+	if f.NArg() == 0 && len(cmd.descSets) == 0 && len(cmd.goPackages) == 0 {
+		return fmt.Errorf("missing inputs, pass proto file name(s) or directories as non-flag arguments, or use -descriptor_set or -go_package")
+	}
+	var paths []string
+	if f.NArg() > 0 {
+		var err error
+		if paths, err = protofiles.Find(f.Args(), nil); err != nil {
+			return err
+		}
+	}
+	var goOpts []string
+	if cmd.bufGenYAML != "" {
+		cfg, err := bufgen.Load(cmd.bufGenYAML)
+		if err != nil {
+			return err
+		}
+		goOpts = cfg.GoOpts()
+	}
+	genOpts, err := protodetect.NewGeneratorOptions(append(goOpts, cmd.goOpts...)...)
+	if err != nil {
+		return err
+	}
+	roots := cmd.protoPaths
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	report := Collect(paths, args.NewIndex(roots), genOpts)
+	for _, path := range cmd.descSets {
+		set, err := protodetect.ReadFileDescriptorSet(path)
+		if err != nil {
+			return err
+		}
+		files, err := genOpts.DescriptorSetLevels(set)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		report.AddDescriptorSet(path, files)
+	}
+	if len(cmd.goPackages) > 0 {
+		if err := report.addGoPackages(ctx, cmd.goPackages); err != nil {
+			return err
+		}
+	}
+	if cmd.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.WriteTable(os.Stdout)
+}
+
+func apiName(api gofeaturespb.GoFeatures_APILevel) string {
+	return strings.TrimPrefix(api.String(), "API_")
+}
+
+func exemption(info *protoparse.APIInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.LeadingComment
+}
+
+// Collect parses the proto files and returns their status, with default API
+// levels determined by the protoc-gen-go options genOpts (which can be nil).
+// The options refer to files by their import path in ix; files outside of its
+// include roots, or all files if ix is nil, are looked up by path. Files that
+// cannot be parsed are reported with an error instead of failing the whole
+// report.
+func Collect(paths []string, ix *args.Index, genOpts *protodetect.GeneratorOptions) *Report {
+	report := &Report{
+		Summary: Summary{
+			Files:    make(map[string]int),
+			Messages: make(map[string]int),
+		},
+	}
+	for _, path := range paths {
+		file := &File{Path: path}
+		report.Files = append(report.Files, file)
+		name := path
+		if ix != nil {
+			if importPath, err := ix.ImportPath(path); err == nil {
+				name = importPath
+			}
+		}
+		parser := protoparse.NewParserWithAccessor(func(string) (io.ReadCloser, error) {
+			return os.Open(path)
+		})
+		fopt, err := parser.WithGeneratorOptions(genOpts).ParseFile(name, false)
+		if err != nil {
+			file.Error = err.Error()
+			report.Summary.Errors++
+			continue
+		}
+		file.Package = fopt.Package
+		file.Syntax = fopt.Syntax
+		file.API = apiName(fopt.GoAPI)
+		file.Source = SourceDefault
+		if fopt.IsExplicit {
+			file.Source = SourceExplicit
+			file.Exemption = exemption(fopt.APIInfo)
+		}
+		report.Summary.Files[file.API]++
+		for _, mopt := range fopt.MessageOpts {
+			file.Messages = append(file.Messages, message(mopt, path, &report.Summary))
+		}
+	}
+	return report
+}
+
+func message(mopt *protoparse.MessageOpt, file string, summary *Summary) *Message {
+	m := &Message{
+		Name:   mopt.Message,
+		API:    apiName(mopt.GoAPI),
+		Source: SourceInherited,
+	}
+	switch {
+	case mopt.IsExplicit:
+		m.Source = SourceExplicit
+		m.Exemption = exemption(mopt.APIInfo)
+	case mopt.Parent != nil:
+		m.InheritedFrom = mopt.Parent.Message
+	default:
+		m.InheritedFrom = file
+	}
+	summary.Messages[m.API]++
+	for _, c := range mopt.Children {
+		m.Messages = append(m.Messages, message(c, file, summary))
+	}
+	return m
+}
+
+// AddDescriptorSet adds the files of the FileDescriptorSet read from path,
+// whose API levels were determined with protodetect.DescriptorSetLevels.
+func (r *Report) AddDescriptorSet(path string, files []*protodetect.FileLevel) {
+	for _, fl := range files {
+		file := &File{
+			Path:    fl.Path,
+			Origin:  "descriptor set " + path,
+			Package: fl.Package,
+			Syntax:  fl.Syntax,
+			API:     apiName(fl.API),
+			Source:  SourceDefault,
+		}
+		if fl.IsExplicit {
+			file.Source = SourceExplicit
+		}
+		r.Summary.Files[file.API]++
+		for _, ml := range fl.Messages {
+			file.Messages = append(file.Messages, descriptorSetMessage(ml, nil, fl.Path, &r.Summary))
+		}
+		r.Files = append(r.Files, file)
+	}
+}
+
+func descriptorSetMessage(ml, parent *protodetect.MessageLevel, file string, summary *Summary) *Message {
+	m := &Message{
+		Name:   ml.Name,
+		API:    apiName(ml.API),
+		Source: SourceInherited,
+	}
+	switch {
+	case ml.IsExplicit:
+		m.Source = SourceExplicit
+	case parent != nil:
+		m.InheritedFrom = parent.Name
+	default:
+		m.InheritedFrom = file
+	}
+	summary.Messages[m.API]++
+	for _, c := range ml.Messages {
+		m.Messages = append(m.Messages, descriptorSetMessage(c, ml, file, summary))
+	}
+	return m
+}
+
+// addGoPackages loads the compiled Go packages matching patterns and adds
+// their messages.
+func (r *Report) addGoPackages(ctx context.Context, patterns []string) error {
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedTypes,
+	}, patterns...)
+	if err != nil {
+		return fmt.Errorf("loading Go packages: %v", err)
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 || pkg.Types == nil {
+			var errs []string
+			for _, e := range pkg.Errors {
+				errs = append(errs, e.Error())
+			}
+			r.Files = append(r.Files, &File{Path: pkg.PkgPath, Origin: "Go package " + pkg.PkgPath, Error: strings.Join(errs, "; ")})
+			r.Summary.Errors++
+			continue
+		}
+		r.AddGoPackage(pkg.PkgPath, protodetecttypes.PackageMessages(pkg.Types, pkg.Fset))
+	}
+	return nil
+}
+
+// AddGoPackage adds the messages of the compiled Go package importPath,
+// grouped by the .proto file they were generated from.
+func (r *Report) AddGoPackage(importPath string, msgs []protodetecttypes.GeneratedMessage) {
+	byFile := make(map[string]*File)
+	var files []*File
+	for _, gm := range msgs {
+		name := gm.ProtoFile
+		if name == "" {
+			name = importPath + " (unknown .proto file)"
+		}
+		file, ok := byFile[name]
+		if !ok {
+			file = &File{Path: name, Origin: "Go package " + importPath, Source: SourceGenerated}
+			byFile[name] = file
+			files = append(files, file)
+		}
+		api := gm.API.String()
+		switch file.API {
+		case "":
+			file.API = api
+		case api:
+		default:
+			file.API = apiMixed
+		}
+		file.Messages = append(file.Messages, &Message{Name: gm.Name, API: api, Source: SourceGenerated})
+		r.Summary.Messages[api]++
+	}
+	for _, file := range files {
+		r.Summary.Files[file.API]++
+	}
+	r.Files = append(r.Files, files...)
+}
+
+// WriteTable writes the report as an indented tree followed by the summary.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE / MESSAGE\tAPI\tSOURCE\tEXEMPTION")
+	var writeMsgs func(msgs []*Message, depth int)
+	writeMsgs = func(msgs []*Message, depth int) {
+		for _, m := range msgs {
+			source := m.Source
+			if m.InheritedFrom != "" {
+				source += " from " + m.InheritedFrom
+			}
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\n", strings.Repeat("  ", depth), m.Name, m.API, source, oneLine(m.Exemption))
+			writeMsgs(m.Messages, depth+1)
+		}
+	}
+	for _, f := range r.Files {
+		if f.Error != "" {
+			fmt.Fprintf(tw, "%s\tERROR\t%s\t\n", f.Path, oneLine(f.Error))
+			continue
+		}
+		path := f.Path
+		if f.Origin != "" {
+			path += " (" + f.Origin + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", path, f.API, f.Source, oneLine(f.Exemption))
+		writeMsgs(f.Messages, 1)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nFiles:    %s\n", counts(r.Summary.Files))
+	fmt.Fprintf(w, "Messages: %s\n", counts(r.Summary.Messages))
+	if r.Summary.Errors > 0 {
+		fmt.Fprintf(w, "Errors:   %d files could not be parsed\n", r.Summary.Errors)
+	}
+	return nil
+}
+
+func counts(m map[string]int) string {
+	var parts []string
+	for _, api := range []string{"OPEN", "HYBRID", "OPAQUE"} {
+		parts = append(parts, fmt.Sprintf("%s %d", api, m[api]))
+	}
+	if n := m[apiMixed]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%s %d", apiMixed, n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/o2o/args"
+	"google.golang.org/open2opaque/internal/protodetect"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.proto")
+	content := `edition = "2023";
+package p;
+import "google/protobuf/go_features.proto";
+option features.(pb.go).api_level = API_HYBRID;
+message A {
+  message B {
+    // Exempt: still used by legacy code.
+    option features.(pb.go).api_level = API_OPEN;
+    message C {}
+  }
+}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.proto")
+	if err := os.WriteFile(broken, []byte("syntax = "), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := Collect([]string{path, broken}, nil, nil)
+	want := &File{
+		Path:    path,
+		Package: "p",
+		Syntax:  "editions",
+		API:     "HYBRID",
+		Source:  SourceExplicit,
+		Messages: []*Message{{
+			Name:          "A",
+			API:           "HYBRID",
+			Source:        SourceInherited,
+			InheritedFrom: path,
+			Messages: []*Message{{
+				Name:      "A.B",
+				API:       "OPEN",
+				Source:    SourceExplicit,
+				Exemption: "Exempt: still used by legacy code.",
+				Messages: []*Message{{
+					Name:          "A.B.C",
+					API:           "OPEN",
+					Source:        SourceInherited,
+					InheritedFrom: "A.B",
+				}},
+			}},
+		}},
+	}
+	if diff := cmp.Diff(want, report.Files[0]); diff != "" {
+		t.Errorf("Collect() returned unexpected file status (-want +got):\n%s", diff)
+	}
+	if report.Files[1].Error == "" {
+		t.Errorf("Collect() did not report an error for %s", broken)
+	}
+	wantSummary := Summary{
+		Files:    map[string]int{"HYBRID": 1},
+		Messages: map[string]int{"HYBRID": 1, "OPEN": 2},
+		Errors:   1,
+	}
+	if diff := cmp.Diff(wantSummary, report.Summary); diff != "" {
+		t.Errorf("Collect() returned unexpected summary (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"inherited from A.B", "Exempt: still used by legacy code.", "Files:    OPEN 0, HYBRID 1, OPAQUE 0"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteTable() output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestCollectImportPath(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "proto")
+	path := filepath.Join(root, "x", "a.proto")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("edition = \"2023\";\npackage x;\nmessage A {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The mapping refers to the import path, which differs from the path.
+	genOpts, err := protodetect.NewGeneratorOptions("apilevelMx/a.proto=API_HYBRID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		ix   *args.Index
+		want string
+	}{
+		{ix: args.NewIndex([]string{root}), want: "HYBRID"},
+		{ix: nil, want: "OPEN"},
+	} {
+		report := Collect([]string{path}, tc.ix, genOpts)
+		if got := report.Files[0]; got.Error != "" || got.API != tc.want {
+			t.Errorf("Collect(%s, ix=%v): API %q (error %q), want %q", path, tc.ix != nil, got.API, got.Error, tc.want)
+		}
+	}
+}
+
+func TestAddDescriptorSet(t *testing.T) {
+	features := &descpb.FeatureSet{}
+	proto.SetExtension(features, gofeaturespb.E_Go, &gofeaturespb.GoFeatures{
+		ApiLevel: gofeaturespb.GoFeatures_API_OPAQUE.Enum(),
+	})
+	set := &descpb.FileDescriptorSet{
+		File: []*descpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(gofeaturespb.File_google_protobuf_go_features_proto),
+			{
+				Name:       proto.String("dep/a.proto"),
+				Package:    proto.String("dep"),
+				Syntax:     proto.String("editions"),
+				Edition:    descpb.Edition_EDITION_2023.Enum(),
+				Dependency: []string{"google/protobuf/go_features.proto"},
+				MessageType: []*descpb.DescriptorProto{{
+					Name: proto.String("A"),
+					NestedType: []*descpb.DescriptorProto{{
+						Name:    proto.String("B"),
+						Options: &descpb.MessageOptions{Features: features},
+					}},
+				}},
+			},
+		},
+	}
+	genOpts, err := protodetect.NewGeneratorOptions("default_api_level=API_HYBRID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := genOpts.DescriptorSetLevels(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Collect(nil, nil, nil)
+	report.AddDescriptorSet("deps.pb", files)
+	if got, want := len(report.Files), 3; got != want {
+		t.Fatalf("AddDescriptorSet() added %d files, want %d", got, want)
+	}
+	want := &File{
+		Path:    "dep/a.proto",
+		Origin:  "descriptor set deps.pb",
+		Package: "dep",
+		Syntax:  "editions",
+		API:     "HYBRID",
+		Source:  SourceDefault,
+		Messages: []*Message{{
+			Name:          "A",
+			API:           "HYBRID",
+			Source:        SourceInherited,
+			InheritedFrom: "dep/a.proto",
+			Messages: []*Message{{
+				Name:   "A.B",
+				API:    "OPAQUE",
+				Source: SourceExplicit,
+			}},
+		}},
+	}
+	if diff := cmp.Diff(want, report.Files[2]); diff != "" {
+		t.Errorf("AddDescriptorSet() returned unexpected file status (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddGoPackage(t *testing.T) {
+	report := Collect(nil, nil, nil)
+	report.AddGoPackage("example.com/foopb", []protodetecttypes.GeneratedMessage{
+		{Name: "A", API: protodetecttypes.OpenAPI, ProtoFile: "foo/a.proto"},
+		{Name: "B", API: protodetecttypes.HybridAPI, ProtoFile: "foo/b.proto"},
+		{Name: "B_C", API: protodetecttypes.OpaqueAPI, ProtoFile: "foo/b.proto"},
+		{Name: "D", API: protodetecttypes.OpaqueAPI},
+	})
+	var got []string
+	for _, f := range report.Files {
+		got = append(got, f.Path+" "+f.API)
+	}
+	want := []string{
+		"foo/a.proto OPEN",
+		"foo/b.proto MIXED",
+		"example.com/foopb (unknown .proto file) OPAQUE",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("AddGoPackage() returned unexpected files (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"foo/b.proto (Go package example.com/foopb)", "MIXED 1", "B_C"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteTable() output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package undo implements the undo open2opaque subcommand, which restores the
+// files written by an earlier rewrite or setapi run.
+package undo
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"flag"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/journal"
+)
+
+// Cmd implements the undo subcommand of the open2opaque tool.
+type Cmd struct {
+	journalDir string
+	list       bool
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "undo" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string { return "Restore the files written by an earlier run." }
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque undo [-list] [<run-id>]
+
+The undo subcommand restores exactly the files that an earlier rewrite or setapi
+run wrote, using the journal that the run recorded. If no run ID is given, the
+most recent run that was not undone yet is restored.
+
+Files that were modified after the run are never overwritten: if any file
+written by the run changed since, undo reports it and restores nothing.
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.journalDir, "journal_dir", "", "directory in which runs are journaled; empty means the default directory in the user cache directory")
+	f.BoolVar(&cmd.list, "list", false, "list the journaled runs instead of undoing one")
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.undo(ctx, f); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+func (cmd *Cmd) undo(ctx context.Context, f *flag.FlagSet) error {
+	root, err := journal.ResolveDir(cmd.journalDir)
+	if err != nil {
+		return err
+	}
+
+	if cmd.list {
+		runs, err := journal.List(root)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			state := ""
+			if run.Undone {
+				state = " (undone)"
+			}
+			fmt.Printf("%s\t%s\t%s\t%d files%s\n", run.ID, run.Command, run.Created.Format("2006-01-02 15:04:05"), len(run.Entries), state)
+		}
+		return nil
+	}
+
+	var id string
+	switch f.NArg() {
+	case 0:
+		run, err := journal.Latest(root)
+		if err != nil {
+			return err
+		}
+		id = run.ID
+	case 1:
+		id = f.Arg(0)
+	default:
+		f.Usage()
+		return fmt.Errorf("at most one run ID may be specified")
+	}
+
+	restored, err := journal.Undo(root, id)
+	for _, path := range restored {
+		fmt.Printf("restored %s\n", path)
+	}
+	if err != nil {
+		return fmt.Errorf("undoing run %s: %v", id, err)
+	}
+	fmt.Printf("Undid run %s: restored %d files.\n", id, len(restored))
+	return nil
+}
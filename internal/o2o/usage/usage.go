@@ -0,0 +1,211 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package usage analyzes how Go packages use the message types generated by
+// protoc-gen-go. It is shared by the subcommands that report on the migration
+// readiness of a repository.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"go/types"
+	"os"
+	"strings"
+
+	statspb "google.golang.org/open2opaque/internal/dashboard"
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/o2o/syncset"
+	"google.golang.org/open2opaque/internal/protodetecttypes"
+)
+
+// BlockingUses are the uses of a message that need the open struct API: code
+// with such uses stops building (or working) with the Opaque API.
+var BlockingUses = map[statspb.Use_Type]bool{
+	statspb.Use_DIRECT_FIELD_ACCESS:   true,
+	statspb.Use_INTERNAL_FIELD_ACCESS: true,
+	statspb.Use_SHALLOW_COPY:          true,
+	statspb.Use_EMBEDDING:             true,
+	statspb.Use_REFLECT_CALL:          true,
+}
+
+// Message is a message type generated by protoc-gen-go.
+type Message struct {
+	// GoPackage is the Go import path of the generated package.
+	GoPackage string
+	// Editable reports whether the generated package is in an editable module
+	// of the workspace, as opposed to a dependency.
+	Editable bool
+	protodetecttypes.GeneratedMessage
+}
+
+// GoType returns the qualified Go type name, e.g. example.com/foopb.M.
+func (m *Message) GoType() string {
+	return m.GoPackage + "." + m.Name
+}
+
+// Result is the outcome of Analyze.
+type Result struct {
+	// Messages are the generated message types of the analyzed packages and
+	// the packages that they (transitively) import.
+	Messages []Message
+	// Entries are the stats of the analyzed packages.
+	Entries []*statspb.Entry
+	// Failed describes the packages that could not be analyzed. Their uses
+	// are missing from Entries.
+	Failed []string
+}
+
+// Analyze loads the Go packages matching patterns in the build configurations
+// buildConfigs (see rewrite -build_configs) and collects the stats of their
+// uses of generated message types without rewriting them. Progress is logged
+// with logf.
+func Analyze(ctx context.Context, patterns []string, buildConfigs string, logf func(format string, a ...any)) (*Result, error) {
+	ws, err := loader.LoadWorkspace(ctx, ".")
+	if err != nil {
+		return nil, fmt.Errorf("can't determine the Go modules: %v", err)
+	}
+	targets, err := ws.Targets(ctx, patterns)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the package list: %v", err)
+	}
+	configs, err := loader.ParseBuildConfigs(buildConfigs)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) > 0 {
+		var expanded []*loader.Target
+		for _, bc := range configs {
+			for _, t := range targets {
+				t := *t
+				t.Build = bc
+				expanded = append(expanded, &t)
+			}
+		}
+		targets = expanded
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	l, err := loader.NewBlazeLoader(ctx, &loader.Config{}, wd)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close(ctx)
+
+	logf("Analyzing %d packages...", len(targets))
+	results := make(chan loader.LoadResult, len(targets))
+	go func() {
+		l.LoadPackages(ctx, targets, results)
+		close(results)
+	}()
+	processed := syncset.New()
+	messages := newMessageSet(ws)
+	res := &Result{}
+	for lr := range results {
+		if lr.Err != nil {
+			res.Failed = append(res.Failed, fmt.Sprintf("%s: %v", lr.Target.ID, lr.Err))
+			continue
+		}
+		messages.addImported(lr.Package)
+		cpkg := fix.ConfiguredPackage{
+			Loader:         l,
+			Pkg:            lr.Package,
+			ProcessedFiles: processed,
+			Testonly:       lr.Target.Testonly,
+		}
+		fixed, err := analyze(&cpkg)
+		if err != nil {
+			res.Failed = append(res.Failed, fmt.Sprintf("%s: %v", lr.Target.ID, err))
+			continue
+		}
+		res.Entries = append(res.Entries, fixed.AllStats()...)
+	}
+	res.Messages = messages.messages
+	return res, nil
+}
+
+// analyze runs the fix analysis without rewrites, which collects the usage
+// stats of the package.
+func analyze(cpkg *fix.ConfiguredPackage) (_ fix.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %s", r)
+		}
+	}()
+	return cpkg.Fix()
+}
+
+// messageSet collects the generated message types that loaded packages can
+// refer to.
+type messageSet struct {
+	ws       *loader.Workspace
+	seenPkgs map[string]bool
+	messages []Message
+}
+
+func newMessageSet(ws *loader.Workspace) *messageSet {
+	return &messageSet{ws: ws, seenPkgs: make(map[string]bool)}
+}
+
+// addImported adds the messages of the package and all packages that it
+// (transitively) imports.
+func (s *messageSet) addImported(pkg *loader.Package) {
+	var visit func(p *types.Package)
+	visit = func(p *types.Package) {
+		if s.seenPkgs[p.Path()] {
+			return
+		}
+		s.seenPkgs[p.Path()] = true
+		msgs := protodetecttypes.PackageMessages(p, pkg.Fileset)
+		editable := len(msgs) > 0 && s.editable(p.Scope().Lookup(msgs[0].Name), pkg.Fileset)
+		for _, m := range msgs {
+			s.messages = append(s.messages, Message{GoPackage: p.Path(), Editable: editable, GeneratedMessage: m})
+		}
+		for _, imp := range p.Imports() {
+			visit(imp)
+		}
+	}
+	visit(pkg.TypePkg)
+}
+
+// editable reports whether obj is declared in a file of an editable module.
+func (s *messageSet) editable(obj types.Object, fset *token.FileSet) bool {
+	if obj == nil || !obj.Pos().IsValid() {
+		return false
+	}
+	return s.ws.CheckWritable(fset.Position(obj.Pos()).Filename) == nil
+}
+
+// TypeName returns the named message type of a stats type name, e.g.
+// example.com/foopb.M for *example.com/foopb.M or []*example.com/foopb.M, or
+// "" for unnamed types.
+func TypeName(long string) string {
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(long, "*"), "[]")
+		if trimmed == long {
+			break
+		}
+		long = trimmed
+	}
+	if strings.ContainsAny(long, "{}[]() ") {
+		return ""
+	}
+	if pkg, name := SplitGoType(long); pkg == "" || name == "" {
+		return ""
+	}
+	return long
+}
+
+// SplitGoType splits a qualified Go type name into package path and name.
+func SplitGoType(goType string) (pkg, name string) {
+	i := strings.LastIndex(goType, ".")
+	if i < 0 {
+		return "", goType
+	}
+	return goType[:i], goType[i+1:]
+}
@@ -0,0 +1,22 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usage
+
+import "testing"
+
+func TestTypeName(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"*example.com/foopb.M", "example.com/foopb.M"},
+		{"[]*example.com/foopb.M", "example.com/foopb.M"},
+		{"example.com/foopb.M", "example.com/foopb.M"},
+		{"map[string]*example.com/foopb.M", ""},
+		{"struct{M *example.com/foopb.M}", ""},
+		{"int", ""},
+	} {
+		if got := TypeName(tc.in); got != tc.want {
+			t.Errorf("TypeName(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
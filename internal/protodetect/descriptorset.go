@@ -0,0 +1,134 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protodetect
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+	pluginpb "google.golang.org/protobuf/types/pluginpb"
+)
+
+// FileLevel is the Go API level of a file in a FileDescriptorSet.
+type FileLevel struct {
+	// Path is the name of the file, e.g. foo/bar.proto.
+	Path string
+	// Package is the proto package.
+	Package string
+	// Syntax is "proto2", "proto3" or "editions".
+	Syntax string
+	// API is the level of the generated code.
+	API gofeaturespb.GoFeatures_APILevel
+	// IsExplicit is set if the file sets the API level feature.
+	IsExplicit bool
+	// Messages are the messages defined at the file level.
+	Messages []*MessageLevel
+}
+
+// MessageLevel is the Go API level of a message in a FileDescriptorSet.
+type MessageLevel struct {
+	// Name is the package-local message name, e.g. A.B for message B defined
+	// in the body of A.
+	Name string
+	// API is the level of the generated code.
+	API gofeaturespb.GoFeatures_APILevel
+	// IsExplicit is set if the message sets the API level feature.
+	IsExplicit bool
+	// Messages are the nested messages, except for map entries.
+	Messages []*MessageLevel
+}
+
+// ReadFileDescriptorSet reads a FileDescriptorSet, as written by protoc -o
+// (or --descriptor_set_out).
+func ReadFileDescriptorSet(path string) (*descpb.FileDescriptorSet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("parsing the FileDescriptorSet %s: %v", path, err)
+	}
+	return set, nil
+}
+
+// DescriptorSetLevels returns the API levels that protoc-gen-go generates with
+// the options g for the files in set. The set must contain the imported files
+// before the files that import them, as protoc --include_imports writes them.
+// Unlike the protoparse package, this works without .proto sources, e.g. for
+// third-party dependencies.
+func (g *GeneratorOptions) DescriptorSetLevels(set *descpb.FileDescriptorSet) ([]*FileLevel, error) {
+	req := &pluginpb.CodeGeneratorRequest{Parameter: proto.String(g.String())}
+	for _, fd := range set.GetFile() {
+		if fd.GetOptions().GetGoPackage() == "" {
+			// protogen refuses files without Go import path, which does not
+			// affect the API level.
+			fd = proto.Clone(fd).(*descpb.FileDescriptorProto)
+			if fd.Options == nil {
+				fd.Options = &descpb.FileOptions{}
+			}
+			fd.Options.GoPackage = proto.String("unknown/" + strings.TrimSuffix(fd.GetName(), ".proto"))
+		}
+		req.ProtoFile = append(req.ProtoFile, fd)
+		req.FileToGenerate = append(req.FileToGenerate, fd.GetName())
+	}
+	plugin, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	var files []*FileLevel
+	for _, f := range plugin.Files {
+		syntax := f.Proto.GetSyntax()
+		if syntax == "" {
+			syntax = "proto2"
+		}
+		fl := &FileLevel{
+			Path:       f.Desc.Path(),
+			Package:    string(f.Desc.Package()),
+			Syntax:     syntax,
+			API:        f.APILevel,
+			IsExplicit: explicitAPILevel(f.Proto.GetOptions().GetFeatures()),
+		}
+		for _, m := range f.Messages {
+			if ml := messageLevel(f, m); ml != nil {
+				fl.Messages = append(fl.Messages, ml)
+			}
+		}
+		files = append(files, fl)
+	}
+	return files, nil
+}
+
+func messageLevel(f *protogen.File, m *protogen.Message) *MessageLevel {
+	if m.Desc.IsMapEntry() {
+		return nil
+	}
+	opts, _ := m.Desc.Options().(*descpb.MessageOptions)
+	ml := &MessageLevel{
+		Name:       strings.TrimPrefix(string(m.Desc.FullName()), string(f.Desc.Package())+"."),
+		API:        m.APILevel,
+		IsExplicit: explicitAPILevel(opts.GetFeatures()),
+	}
+	for _, n := range m.Messages {
+		if nl := messageLevel(f, n); nl != nil {
+			ml.Messages = append(ml.Messages, nl)
+		}
+	}
+	return ml
+}
+
+// explicitAPILevel reports whether the features set the Go API level.
+func explicitAPILevel(fs *descpb.FeatureSet) bool {
+	if fs == nil || !proto.HasExtension(fs, gofeaturespb.E_Go) {
+		return false
+	}
+	return proto.GetExtension(fs, gofeaturespb.E_Go).(*gofeaturespb.GoFeatures).ApiLevel != nil
+}
@@ -8,6 +8,7 @@ package protodetect
 
 import (
 	"fmt"
+	"strings"
 
 	"google.golang.org/protobuf/compiler/protogen"
 	"google.golang.org/protobuf/proto"
@@ -34,11 +35,43 @@ func fromFeatureToOld(apiLevel gofeaturespb.GoFeatures_APILevel) pb.GoAPI {
 	}
 }
 
-func apiLevelForDescriptor(fd *descpb.FileDescriptorProto) gofeaturespb.GoFeatures_APILevel {
-	return apiLevelForDescriptorOpts(protogen.Options{}, fd)
+// GeneratorOptions are protoc-gen-go options (as passed with --go_opt) that
+// affect the API level of generated code: default_api_level and the
+// per-file apilevelM<file>=<level> mappings. Other options, like M mappings or
+// paths=source_relative, are accepted and have no effect on the API level. The
+// nil *GeneratorOptions means no options.
+type GeneratorOptions struct {
+	parameter string
 }
 
-func apiLevelForDescriptorOpts(opts protogen.Options, fd *descpb.FileDescriptorProto) gofeaturespb.GoFeatures_APILevel {
+// NewGeneratorOptions returns the generator options from one or more
+// comma-separated lists of protoc-gen-go options. It returns an error if
+// protoc-gen-go would reject the options.
+func NewGeneratorOptions(opts ...string) (*GeneratorOptions, error) {
+	var params []string
+	for _, o := range opts {
+		if o != "" {
+			params = append(params, o)
+		}
+	}
+	g := &GeneratorOptions{parameter: strings.Join(params, ",")}
+	if _, err := g.apiLevel(&descpb.FileDescriptorProto{Name: proto.String("dummy.proto")}); err != nil {
+		return nil, fmt.Errorf("invalid protoc-gen-go options %q: %v", g.parameter, err)
+	}
+	return g, nil
+}
+
+// String returns the options as a comma-separated list.
+func (g *GeneratorOptions) String() string {
+	if g == nil {
+		return ""
+	}
+	return g.parameter
+}
+
+// apiLevel determines the API level of fd by querying protogen using a stub
+// plugin request.
+func (g *GeneratorOptions) apiLevel(fd *descpb.FileDescriptorProto) (gofeaturespb.GoFeatures_APILevel, error) {
 	fopts := fd.Options
 	if fopts == nil {
 		fopts = &descpb.FileOptions{}
@@ -46,21 +79,23 @@ func apiLevelForDescriptorOpts(opts protogen.Options, fd *descpb.FileDescriptorP
 	}
 	fopts.GoPackage = proto.String("dummy/package")
 
-	// Determine the API level by querying protogen using a stub plugin request.
-	plugin, err := opts.New(&pluginpb.CodeGeneratorRequest{
+	plugin, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		Parameter: proto.String(g.String()),
 		ProtoFile: []*descpb.FileDescriptorProto{fd},
 	})
 	if err != nil {
-		panic(err)
+		return gofeaturespb.GoFeatures_API_LEVEL_UNSPECIFIED, err
 	}
 	if got, want := len(plugin.Files), 1; got != want {
 		panic(fmt.Sprintf("protogen returned %d plugin.Files entries, expected %d", got, want))
 	}
-	return plugin.Files[0].APILevel
+	return plugin.Files[0].APILevel, nil
 }
 
-// DefaultFileLevel returns the default go_api_flag option for given path.
-func DefaultFileLevel(path string) gofeaturespb.GoFeatures_APILevel {
+// DefaultFileLevel returns the default go_api_flag option for given path,
+// i.e. the API level that protoc-gen-go generates with the options g for a
+// file without go_api_flag option.
+func (g *GeneratorOptions) DefaultFileLevel(path string) gofeaturespb.GoFeatures_APILevel {
 	if path == "testonly-opaque-default-dummy.proto" {
 		// Magic filename to test the edition 2024+ behavior (Opaque API by
 		// default) from setapi_test.go
@@ -68,7 +103,18 @@ func DefaultFileLevel(path string) gofeaturespb.GoFeatures_APILevel {
 	}
 
 	fd := &descpb.FileDescriptorProto{Name: proto.String(path)}
-	return apiLevelForDescriptor(fd)
+	level, err := g.apiLevel(fd)
+	if err != nil {
+		// NewGeneratorOptions validated the options.
+		panic(err)
+	}
+	return level
+}
+
+// DefaultFileLevel returns the default go_api_flag option for given path
+// without any generator options.
+func DefaultFileLevel(path string) gofeaturespb.GoFeatures_APILevel {
+	return (*GeneratorOptions)(nil).DefaultFileLevel(path)
 }
 
 // MapGoAPIFlag maps current and old values of the go API flag to current values.
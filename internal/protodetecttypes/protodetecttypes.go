@@ -8,9 +8,17 @@
 package protodetecttypes
 
 import (
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"go/types"
 	"reflect"
+	"strconv"
 	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	descpb "google.golang.org/protobuf/types/descriptorpb"
 )
 
 // Type represents a go/types.Type obtained at build time.
@@ -63,3 +71,218 @@ func determineAPI(tag reflect.StructTag) MessageAPI {
 		return Invalid
 	}
 }
+
+// String returns the name of the API level as used in .proto files: OPEN,
+// HYBRID or OPAQUE, or INVALID for types that are not messages.
+func (api MessageAPI) String() string {
+	switch api {
+	case OpenAPI:
+		return "OPEN"
+	case HybridAPI:
+		return "HYBRID"
+	case OpaqueAPI:
+		return "OPAQUE"
+	default:
+		return "INVALID"
+	}
+}
+
+// GeneratedMessage is a message type in a compiled Go package.
+type GeneratedMessage struct {
+	// Name is the Go type name, e.g. A_B for message B defined in the body of
+	// message A.
+	Name string
+	// API is the API of the generated type.
+	API MessageAPI
+	// ProtoFile is the .proto file from which the type was generated, as
+	// recorded in the generated file. Empty if unknown.
+	ProtoFile string
+	// FullName is the proto full name of the message, e.g. foo.A.B, as
+	// recorded in the raw descriptor of the generated file. Empty if unknown.
+	FullName string
+}
+
+// PackageMessages returns the message types of a generated package, e.g. one
+// loaded from export data. Positions of the package's objects are resolved
+// with fset to find the generated files.
+func PackageMessages(pkg *types.Package, fset *token.FileSet) []GeneratedMessage {
+	files := make(map[string]*generatedFile) // by file name
+	var msgs []GeneratedMessage
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		api := Type{T: tn.Type()}.MessageAPI()
+		if api == Invalid {
+			continue
+		}
+		m := GeneratedMessage{Name: name, API: api}
+		if tn.Pos().IsValid() {
+			fname := fset.Position(tn.Pos()).Filename
+			gf, ok := files[fname]
+			if !ok {
+				gf = readGeneratedFile(fname)
+				files[fname] = gf
+			}
+			m.ProtoFile = gf.protoFile
+			m.FullName = gf.fullNames[name]
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// generatedFile is what PackageMessages reads from a file generated by
+// protoc-gen-go.
+type generatedFile struct {
+	protoFile string
+	fullNames map[string]string // Go type name → proto full name
+}
+
+// readGeneratedFile reads the name of the .proto file from the header that
+// protoc-gen-go writes to generated files:
+//
+//	// source: foo/bar.proto
+//
+// and the message names from the raw descriptor (file_foo_bar_proto_rawDesc).
+// Unreadable information is left empty.
+func readGeneratedFile(fname string) *generatedFile {
+	gf := &generatedFile{fullNames: make(map[string]string)}
+	f, err := parser.ParseFile(token.NewFileSet(), fname, nil, parser.ParseComments)
+	if err != nil {
+		return gf
+	}
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if name, ok := strings.CutPrefix(c.Text, "// source: "); ok {
+				gf.protoFile = strings.TrimSpace(name)
+			}
+		}
+	}
+	raw, ok := rawDescriptor(f)
+	if !ok {
+		return gf
+	}
+	fd := &descpb.FileDescriptorProto{}
+	if err := proto.Unmarshal(raw, fd); err != nil {
+		return gf
+	}
+	if gf.protoFile == "" {
+		gf.protoFile = fd.GetName()
+	}
+	var walk func(prefix, local string, msgs []*descpb.DescriptorProto)
+	walk = func(prefix, local string, msgs []*descpb.DescriptorProto) {
+		for _, m := range msgs {
+			name := local + m.GetName()
+			gf.fullNames[GoMessageName(name)] = prefix + name
+			walk(prefix, name+".", m.GetNestedType())
+		}
+	}
+	prefix := ""
+	if pkg := fd.GetPackage(); pkg != "" {
+		prefix = pkg + "."
+	}
+	walk(prefix, "", fd.GetMessageType())
+	return gf
+}
+
+// rawDescriptor evaluates the raw descriptor in a generated file, which
+// protoc-gen-go writes as a string constant (a concatenation of string
+// literals) or, in older versions, as a []byte variable.
+func rawDescriptor(f *ast.File) ([]byte, bool) {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || (gd.Tok != token.CONST && gd.Tok != token.VAR) {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if len(vs.Names) != 1 || len(vs.Values) != 1 || !strings.HasSuffix(vs.Names[0].Name, "_rawDesc") {
+				continue
+			}
+			return evalBytes(vs.Values[0])
+		}
+	}
+	return nil, false
+}
+
+func evalBytes(expr ast.Expr) ([]byte, bool) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind != token.STRING {
+			return nil, false
+		}
+		s, err := strconv.Unquote(expr.Value)
+		return []byte(s), err == nil
+	case *ast.BinaryExpr:
+		if expr.Op != token.ADD {
+			return nil, false
+		}
+		x, ok := evalBytes(expr.X)
+		if !ok {
+			return nil, false
+		}
+		y, ok := evalBytes(expr.Y)
+		return append(x, y...), ok
+	case *ast.CompositeLit:
+		b := make([]byte, 0, len(expr.Elts))
+		for _, elt := range expr.Elts {
+			lit, ok := elt.(*ast.BasicLit)
+			if !ok || lit.Kind != token.INT {
+				return nil, false
+			}
+			v, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil {
+				return nil, false
+			}
+			b = append(b, byte(v))
+		}
+		return b, true
+	}
+	return nil, false
+}
+
+// GoMessageName converts a package-local proto message name (e.g. A.B) to the
+// name of the Go type that protoc-gen-go generates for it (e.g. A_B). This is
+// a copy of the unexported strs.GoCamelCase of the protobuf module.
+func GoMessageName(s string) string {
+	isLower := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isLower(s[i+1]):
+			// Skip over '.' in ".{{lowercase}}".
+		case c == '.':
+			b = append(b, '_') // convert '.' to '_'
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// Convert initial '_' to ensure we start with a capital letter.
+			// Do the same for '_' after '.' to match historic behavior.
+			b = append(b, 'X') // convert '_' to 'X'
+		case c == '_' && i+1 < len(s) && isLower(s[i+1]):
+			// Skip over '_' in "_{{lowercase}}".
+		case isDigit(c):
+			b = append(b, c)
+		default:
+			// Assume we have a letter now - if not, it's a bogus identifier.
+			// The next word is a sequence of characters that must start upper
+			// case.
+			if isLower(c) {
+				c -= 'a' - 'A' // convert lowercase to uppercase
+			}
+			b = append(b, c)
+
+			// Accept lower case sequence that follows.
+			for ; i+1 < len(s) && isLower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
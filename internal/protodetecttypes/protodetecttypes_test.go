@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protodetecttypes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestGoMessageName(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"M", "M"},
+		{"my_msg", "MyMsg"},
+		{"M.Nested", "M_Nested"},
+		{"M.nested_msg", "MNestedMsg"},
+		{"_m", "XM"},
+		{"M2x", "M2X"},
+	} {
+		if got := GoMessageName(tc.in); got != tc.want {
+			t.Errorf("GoMessageName(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestReadGeneratedFile(t *testing.T) {
+	fd := &descpb.FileDescriptorProto{
+		Name:    proto.String("foo/foo.proto"),
+		Package: proto.String("foo.v1"),
+		MessageType: []*descpb.DescriptorProto{{
+			Name:       proto.String("M"),
+			NestedType: []*descpb.DescriptorProto{{Name: proto.String("inner_msg")}},
+		}},
+	}
+	raw, err := proto.Marshal(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newer protoc-gen-go versions write a string constant, older ones a
+	// []byte variable.
+	var byteLits []string
+	for _, b := range raw {
+		byteLits = append(byteLits, fmt.Sprintf("0x%02x", b))
+	}
+	half := len(raw) / 2
+	for _, tc := range []struct {
+		name, decl string
+	}{
+		{"const", fmt.Sprintf("const file_foo_foo_proto_rawDesc = \"\" +\n\t%q +\n\t%q\n", raw[:half], raw[half:])},
+		{"var", fmt.Sprintf("var file_foo_foo_proto_rawDesc = []byte{\n\t%s,\n}\n", strings.Join(byteLits, ", "))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "foo.pb.go")
+			src := "// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: foo/foo.proto\n\npackage foopb\n\n" + tc.decl
+			if err := os.WriteFile(fname, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want := &generatedFile{
+				protoFile: "foo/foo.proto",
+				fullNames: map[string]string{
+					"M":         "foo.v1.M",
+					"MInnerMsg": "foo.v1.M.inner_msg",
+				},
+			}
+			if diff := cmp.Diff(want, readGeneratedFile(fname), cmp.AllowUnexported(generatedFile{})); diff != "" {
+				t.Errorf("readGeneratedFile() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -110,7 +110,10 @@ func (tr TextRange) ToByteRange(content []byte) (beginByte, endByte int, err err
 type APIInfo struct {
 	TextRange         TextRange
 	HasLeadingComment bool
-	path              []int32
+	// LeadingComment is the trimmed leading comment of the API flag, which
+	// exempts the flag from modification by setapi.
+	LeadingComment string
+	path           []int32
 }
 
 // FileOpt contains the Go API level info for a file along with other proto
@@ -170,12 +173,13 @@ type MessageOpt struct {
 
 // Parser parses proto source files for go_api_flag values.
 type Parser struct {
-	parser protoparse.Parser
+	parser  protoparse.Parser
+	genOpts *protodetect.GeneratorOptions
 }
 
 // NewParser constructs a Parser with default file accessor.
 func NewParser() *Parser {
-	return &Parser{protoparse.Parser{
+	return &Parser{parser: protoparse.Parser{
 		InterpretOptionsInUnlinkedFiles: true,
 		IncludeSourceCodeInfo:           true,
 	}}
@@ -183,13 +187,20 @@ func NewParser() *Parser {
 
 // NewParserWithAccessor constructs a Parser with a custom file accessor.
 func NewParserWithAccessor(acc protoparse.FileAccessor) *Parser {
-	return &Parser{protoparse.Parser{
+	return &Parser{parser: protoparse.Parser{
 		InterpretOptionsInUnlinkedFiles: true,
 		IncludeSourceCodeInfo:           true,
 		Accessor:                        acc,
 	}}
 }
 
+// WithGeneratorOptions returns p after configuring it to determine default
+// API levels with the given protoc-gen-go options.
+func (p *Parser) WithGeneratorOptions(opts *protodetect.GeneratorOptions) *Parser {
+	p.genOpts = opts
+	return p
+}
+
 func fromOldToFeature(apiLevel pb.GoAPI) (gofeaturespb.GoFeatures_APILevel, error) {
 	switch apiLevel {
 	case pb.GoAPI_OPEN_V1:
@@ -230,13 +241,13 @@ func uninterpretedGoAPIFeature(opts []*descpb.UninterpretedOption) (gofeaturespb
 	return gofeaturespb.GoFeatures_API_LEVEL_UNSPECIFIED, -1
 }
 
-func fileGoAPIEditions(desc *descpb.FileDescriptorProto) (gofeaturespb.GoFeatures_APILevel, bool, []int32, error) {
+func fileGoAPIEditions(desc *descpb.FileDescriptorProto, genOpts *protodetect.GeneratorOptions) (gofeaturespb.GoFeatures_APILevel, bool, []int32, error) {
 	if proto.HasExtension(desc.GetOptions().GetFeatures(), gofeaturespb.E_Go) {
 		panic("unimplemented: Go extension features are fully parsed in file options")
 	}
 	api, idx := uninterpretedGoAPIFeature(desc.GetOptions().GetUninterpretedOption())
 	if api == gofeaturespb.GoFeatures_API_LEVEL_UNSPECIFIED {
-		return protodetect.DefaultFileLevel(desc.GetName()), false, nil, nil
+		return genOpts.DefaultFileLevel(desc.GetName()), false, nil, nil
 	}
 	const (
 		// https://github.com/protocolbuffers/protobuf/blob/v29.1/src/google/protobuf/descriptor.proto#L122
@@ -269,7 +280,7 @@ func (p *Parser) ParseFile(name string, skipMessages bool) (*FileOpt, error) {
 	var sciPath []int32
 
 	syntax := desc.GetSyntax()
-	fileAPI, explicit, sciPath, err = fileGoAPIEditions(desc)
+	fileAPI, explicit, sciPath, err = fileGoAPIEditions(desc, p.genOpts)
 	if err != nil {
 		return nil, fmt.Errorf("fileGoAPIEditions: %v", err)
 	}
@@ -327,6 +338,7 @@ func fillAPIInfos(infos []*APIInfo, info *descpb.SourceCodeInfo) {
 		if info, ok := m[fmt.Sprint(loc.GetPath())]; ok {
 			info.TextRange = SpanToTextRange(loc.GetSpan())
 			leading := strings.TrimSpace(loc.GetLeadingComments())
+			info.LeadingComment = leading
 			switch {
 			default:
 				info.HasLeadingComment = leading != ""
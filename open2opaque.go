@@ -18,8 +18,14 @@ import (
 
 	"flag"
 	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/editions"
+	"google.golang.org/open2opaque/internal/o2o/inventory"
+	"google.golang.org/open2opaque/internal/o2o/migrate"
+	"google.golang.org/open2opaque/internal/o2o/readiness"
 	"google.golang.org/open2opaque/internal/o2o/rewrite"
 	"google.golang.org/open2opaque/internal/o2o/setapi"
+	"google.golang.org/open2opaque/internal/o2o/status"
+	"google.golang.org/open2opaque/internal/o2o/undo"
 	"google.golang.org/open2opaque/internal/o2o/version"
 )
 
@@ -53,13 +59,21 @@ Report issues at https://github.com/golang/open2opaque/issues
 	commander.Register(commander.HelpCommand(), groupOther)
 	commander.Register(commander.FlagsCommand(), groupOther)
 	registerVersion(commander)
+	commander.Register(undo.Command(), groupOther)
 
 	// Comes first in the help output (alphabetically)
 	const groupRewrite = "automatically rewriting Go code"
 	commander.Register(rewrite.Command(), groupRewrite)
+	commander.Register(migrate.Command(), groupRewrite)
 
 	const groupFlag = "managing the API level"
 	commander.Register(setapi.Command(), groupFlag)
+	commander.Register(status.Command(), groupFlag)
+	commander.Register(readiness.Command(), groupFlag)
+	commander.Register(inventory.Command(), groupFlag)
+
+	const groupProto = "converting proto files"
+	commander.Register(editions.Command(), groupProto)
 
 	flag.Usage = func() {
 		commander.HelpCommand().Execute(ctx, flag.CommandLine)